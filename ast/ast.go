@@ -155,6 +155,16 @@ func (il *IntegerLiteral) expressionNode()      {}
 func (il *IntegerLiteral) TokenLiteral() string { return il.Token.Literal }
 func (il *IntegerLiteral) String() string       { return il.Token.Literal }
 
+// FloatLiteral =======================================================================================   FloatLiteral
+type FloatLiteral struct {
+	Token token.Token
+	Value float64
+}
+
+func (fl *FloatLiteral) expressionNode()      {}
+func (fl *FloatLiteral) TokenLiteral() string { return fl.Token.Literal }
+func (fl *FloatLiteral) String() string       { return fl.Token.Literal }
+
 // PrefixExpression ==================================================================================  PrefixExpression
 type PrefixExpression struct {
 	Token    token.Token // The prefix token, e.g. !
@@ -260,6 +270,7 @@ type CallExpression struct {
 	Token     token.Token // The '(' token
 	Function  Expression  // Identifier or FunctionLiteral
 	Arguments []Expression
+	Tail      bool // the result of the call is what its function returns, set by MarkTailCalls
 }
 
 func (ce *CallExpression) expressionNode()      {}
@@ -277,6 +288,56 @@ func (ce *CallExpression) String() string {
 	return out.String()
 }
 
+// MarkTailCalls sets Tail of the calls in tail position of a function body: the returned calls and
+// the call the body ends with, also in the branches of an if expression the body ends with.
+// Calls in nested function literals are marked when those are parsed.
+func MarkTailCalls(body *BlockStatement) {
+	if body == nil {
+		return
+	}
+	for i, s := range body.Statements {
+		switch s := s.(type) {
+		case *ReturnStatement:
+			markTailExpression(s.ReturnValue)
+		case *ExpressionStatement:
+			if i == len(body.Statements)-1 {
+				markTailExpression(s.Expression)
+			} else if ie, ok := s.Expression.(*IfExpression); ok {
+				// the returns in its branches are still in tail position
+				markReturns(ie.Consequence)
+				markReturns(ie.Alternative)
+			}
+		}
+	}
+}
+
+func markTailExpression(exp Expression) {
+	switch exp := exp.(type) {
+	case *CallExpression:
+		exp.Tail = true
+	case *IfExpression:
+		MarkTailCalls(exp.Consequence)
+		MarkTailCalls(exp.Alternative)
+	}
+}
+
+func markReturns(block *BlockStatement) {
+	if block == nil {
+		return
+	}
+	for _, s := range block.Statements {
+		switch s := s.(type) {
+		case *ReturnStatement:
+			markTailExpression(s.ReturnValue)
+		case *ExpressionStatement:
+			if ie, ok := s.Expression.(*IfExpression); ok {
+				markReturns(ie.Consequence)
+				markReturns(ie.Alternative)
+			}
+		}
+	}
+}
+
 // StringLiteral ===================================================================================   StringLiteral
 type StringLiteral struct {
 	Token token.Token
@@ -326,10 +387,37 @@ func (ie *IndexExpression) String() string {
 	return out.String()
 }
 
+// SliceExpression =================================================================================    SliceExpression
+type SliceExpression struct {
+	Token token.Token // The [ token
+	Left  Expression
+	Start Expression // nil when left out, the slice starts at the beginning
+	End   Expression // nil when left out, the slice runs to the end
+}
+
+func (se *SliceExpression) expressionNode()      {}
+func (se *SliceExpression) TokenLiteral() string { return se.Token.Literal }
+func (se *SliceExpression) String() string {
+	var out bytes.Buffer
+	out.WriteString("(")
+	out.WriteString(se.Left.String())
+	out.WriteString("[")
+	if se.Start != nil {
+		out.WriteString(se.Start.String())
+	}
+	out.WriteString(":")
+	if se.End != nil {
+		out.WriteString(se.End.String())
+	}
+	out.WriteString("])")
+	return out.String()
+}
+
 // HashLiteral =======================================================================================    HashLiteral
 type HashLiteral struct {
 	Token token.Token // the '{' token
 	Pairs map[Expression]Expression
+	Keys  []Expression // the keys of Pairs in the order of the source
 }
 
 func (hl *HashLiteral) expressionNode()      {}
@@ -337,8 +425,8 @@ func (hl *HashLiteral) TokenLiteral() string { return hl.Token.Literal }
 func (hl *HashLiteral) String() string {
 	var out bytes.Buffer
 	var pairs []string
-	for key, value := range hl.Pairs {
-		pairs = append(pairs, key.String()+":"+value.String())
+	for _, key := range hl.Keys {
+		pairs = append(pairs, key.String()+":"+hl.Pairs[key].String())
 	}
 	out.WriteString("{")
 	out.WriteString(strings.Join(pairs, ", "))
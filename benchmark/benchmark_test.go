@@ -0,0 +1,39 @@
+package main
+
+import (
+	"jonathan/compiler"
+	"jonathan/lexer"
+	"jonathan/parser"
+	"jonathan/vm"
+	"testing"
+)
+
+// BenchmarkVm runs every workload on the vm, compiled without and with the optimisations. To compare the
+// dispatch loop of two commits run go test ./benchmark -run NONE -bench Vm -count 5 on both and compare with benchstat.
+func BenchmarkVm(b *testing.B) {
+	for _, w := range workloads {
+		for _, optimized := range []bool{false, true} {
+			name := w.name
+			if optimized {
+				name += "/optimized"
+			}
+			b.Run(name, func(b *testing.B) {
+				comp := compiler.NewCompiler()
+				if optimized {
+					comp.SetOptimizations(compiler.AllOptimizations)
+				}
+				err := comp.Compile(parser.NewParser(lexer.NewLexer(w.input)).ParseProgram())
+				if err != nil {
+					b.Fatalf("compiler error: %s", err)
+				}
+				bytecode := comp.Bytecode()
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					if err := vm.NewVm(bytecode).Run(); err != nil {
+						b.Fatalf("vm error: %s", err)
+					}
+				}
+			})
+		}
+	}
+}
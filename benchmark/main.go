@@ -8,6 +8,7 @@ import (
 	"jonathan/lexer"
 	"jonathan/object"
 	"jonathan/parser"
+	"jonathan/register"
 	"jonathan/vm"
 	"time"
 )
@@ -16,9 +17,14 @@ import (
 // chmod +x fibonacci
 // ./fibonacci -engine=eval
 // ./fibonacci -engine=vm
+// ./fibonacci -engine=register
+// ./fibonacci -engine=vm -optimize -workload=all
 
-var engine = flag.String("engine", "vm", "user 'vm' or 'eval'")
-var input = `
+var engine = flag.String("engine", "vm", "user 'vm', 'register' or 'eval'")
+var optimize = flag.Bool("optimize", false, "compile with all optimisations for the vm")
+var workload = flag.String("workload", "fibonacci", "the workload to run, or 'all'")
+
+var fibonacci = `
 let fibonacci = fn(x) {
 	if	(x == 0){
 		0
@@ -33,38 +39,108 @@ let fibonacci = fn(x) {
 fibonacci(35);
 `
 
+// workloads are the programs the benchmark can run, each of them stresses another part of the engines
+var workloads = []struct {
+	name  string
+	input string
+}{
+	{"fibonacci", fibonacci},
+	// a loop of arithmetic on locals and constants
+	{"loop", `let loop = fn(n, acc) { if (n == 0) { acc } else { loop(n - 1, acc + n * 2) } }; loop(3000000, 0);`},
+	// creating and calling closures
+	{"closures", `
+let adder = fn(x) { fn(y) { x + y } };
+let apply = fn(n, acc) { if (n < 1) { acc } else { apply(n - 1, adder(n)(acc)) } };
+apply(1000000, 0);
+`},
+	// builtins and arrays
+	{"arrays", `
+let build = fn(n, acc) { if (n == 0) { acc } else { build(n - 1, push(acc, n)) } };
+let sum = fn(a, i, acc) { if (i == len(a)) { acc } else { sum(a, i + 1, acc + a[i]) } };
+sum(build(3000, []), 0, 0);
+`},
+	// hash lookups and string concatenation
+	{"hashes", `
+let h = {"one": 1, "two": 2, "three": 3};
+let lookup = fn(n, acc) { if (n == 0) { acc } else { lookup(n - 1, acc + h["one"] + h["three"]) } };
+let repeat = fn(s, n) { if (n == 0) { s } else { repeat(s + "a", n - 1) } };
+lookup(1000000, 0) + len(repeat("", 5000));
+`},
+	// building small array literals and indexing into them
+	{"indexing", `
+let step = fn(n, acc) { if (n == 0) { acc } else { let a = [n, n + 1, n + 2]; step(n - 1, acc + a[0] + a[2]) } };
+step(1000000, 0);
+`},
+	// building small hash literals and looking their keys up
+	{"hashbuild", `
+let step = fn(n, acc) { if (n == 0) { acc } else { let h = {"x": n, "y": n * 2}; step(n - 1, acc + h["x"] + h["y"]) } };
+step(500000, 0);
+`},
+}
+
 func main() {
 	flag.Parse()
-	var duration time.Duration
-	var result object.Object
+	found := false
+	for _, w := range workloads {
+		if *workload != "all" && *workload != w.name {
+			continue
+		}
+		found = true
+		result, duration, err := run(w.input)
+		if err != nil {
+			fmt.Printf("workload %s: %s\n", w.name, err)
+			return
+		}
+		fmt.Printf("engine=%s, workload=%s, result=%s, duration=%s\n",
+			*engine,
+			w.name,
+			result.Inspect(),
+			duration)
+	}
+	if !found {
+		fmt.Printf("unknown workload %s\n", *workload)
+	}
+}
+
+// run runs input with the selected engine and returns its result and the time the engine took, without compiling
+func run(input string) (object.Object, time.Duration, error) {
 	l := lexer.NewLexer(input)
 	p := parser.NewParser(l)
 	program := p.ParseProgram()
-	if *engine == "vm" {
+	switch *engine {
+	case "vm":
 		comp := compiler.NewCompiler()
+		if *optimize {
+			comp.SetOptimizations(compiler.AllOptimizations)
+		}
 		err := comp.Compile(program)
 		if err != nil {
-			fmt.Printf("compiler error: %s", err)
-			return
+			return nil, 0, fmt.Errorf("compiler error: %s", err)
 		}
 		machine := vm.NewVm(comp.Bytecode())
 		start := time.Now()
 		err = machine.Run()
 		if err != nil {
-			fmt.Printf("vm error: %s", err)
-			return
+			return nil, 0, fmt.Errorf("vm error: %s", err)
 		}
-		duration = time.Since(start)
-		result = machine.LastPoppedStackElem()
-
-	} else {
+		return machine.LastPoppedStackElem(), time.Since(start), nil
+	case "register":
+		comp := register.NewCompiler()
+		err := comp.Compile(program)
+		if err != nil {
+			return nil, 0, fmt.Errorf("compiler error: %s", err)
+		}
+		machine := register.NewVm(comp.Bytecode())
+		start := time.Now()
+		err = machine.Run()
+		if err != nil {
+			return nil, 0, fmt.Errorf("vm error: %s", err)
+		}
+		return machine.LastResult(), time.Since(start), nil
+	default:
 		env := object.NewEnvironment()
 		start := time.Now()
-		result = evaluator.Eval(program, env)
-		duration = time.Since(start)
+		result := evaluator.Eval(program, env)
+		return result, time.Since(start), nil
 	}
-	fmt.Printf("engine=%s, result=%s, duration=%s\n",
-		*engine,
-		result.Inspect(),
-		duration)
 }
@@ -36,6 +36,7 @@ const (
 	OpArray
 	OpHash
 	OpIndex
+	OpSlice
 	OpCall
 	OpReturnValue
 	OpReturn
@@ -48,6 +49,21 @@ const (
 	OpClosure
 	OpGetFree
 	OpCurrentClosure
+
+	OpDup
+	OpTailCall
+
+	OpWide
+
+	// specialised forms of common instruction sequences, the compiler emits them when asked to
+	OpGetLocal0
+	OpGetLocal1
+	OpGetLocal2
+	OpGetLocal3
+	OpAddConst
+	OpSubConst
+	OpJumpIfNotGreater
+	OpJumpIfNotEqual
 )
 
 type Definition struct {
@@ -79,6 +95,7 @@ var definitions = map[Opcode]*Definition{
 	OpArray:         {"OpArray", []int{2}}, // operand：elements number
 	OpHash:          {"OpHash", []int{2}},  // operand：number of key+ number of value
 	OpIndex:         {"OpIndex", []int{}},
+	OpSlice:         {"OpSlice", []int{}}, // the sliced value, the start and the end on the stack, null for a bound left out
 	OpCall:          {"OpCall", []int{1}}, // operand: hold the number of arguments
 	OpReturnValue:   {"OpReturnValue", []int{}},
 	OpReturn:        {"OpReturn", []int{}},
@@ -89,6 +106,18 @@ var definitions = map[Opcode]*Definition{
 	OpClosure:        {"OpClosure", []int{2, 1}}, // the first operand is the constant index,the second operand specifies the number of free variables
 	OpGetFree:        {"OpGetFree", []int{1}},    // operand: the index in closure object Free
 	OpCurrentClosure: {"OpCurrentClosure", []int{}},
+	OpDup:            {"OpDup", []int{}},       // push the top of the stack once more
+	OpTailCall:       {"OpTailCall", []int{1}}, // like OpCall, but the callee replaces the frame of the caller
+	OpWide:           {"OpWide", []int{}},      // prefix: the operands of the next instruction are twice as wide
+
+	OpGetLocal0:        {"OpGetLocal0", []int{}},
+	OpGetLocal1:        {"OpGetLocal1", []int{}},
+	OpGetLocal2:        {"OpGetLocal2", []int{}},
+	OpGetLocal3:        {"OpGetLocal3", []int{}},
+	OpAddConst:         {"OpAddConst", []int{2}},         // OpConstant and OpAdd, operand: the constant index
+	OpSubConst:         {"OpSubConst", []int{2}},         // OpConstant and OpSub
+	OpJumpIfNotGreater: {"OpJumpIfNotGreater", []int{2}}, // OpGreaterThan and OpJumpNotTruthy, operand: the position
+	OpJumpIfNotEqual:   {"OpJumpIfNotEqual", []int{2}},   // OpEqual and OpJumpNotTruthy
 }
 
 func Lookup(op byte) (*Definition, error) {
@@ -99,6 +128,65 @@ func Lookup(op byte) (*Definition, error) {
 	return def, nil
 }
 
+// Widen returns the definition of op when it follows an OpWide prefix
+func Widen(def *Definition) *Definition {
+	widths := make([]int, len(def.OperandWidths))
+	for i, w := range def.OperandWidths {
+		widths[i] = 2 * w
+	}
+	return &Definition{Name: def.Name, OperandWidths: widths}
+}
+
+// MakeWide makes the instruction prefixed with OpWide, so its operands use twice the bytes.
+func MakeWide(op Opcode, operands ...int) []byte {
+	def, ok := definitions[op]
+	if !ok {
+		return []byte{}
+	}
+	return append([]byte{byte(OpWide)}, makeInstruction(op, Widen(def), operands)...)
+}
+
+// Encode makes the instruction in the narrowest form its operands fit in.
+// Unlike Make it fails instead of truncating an operand that doesn't fit.
+func Encode(op Opcode, operands ...int) ([]byte, error) {
+	def, ok := definitions[op]
+	if !ok {
+		return nil, fmt.Errorf("opcode %d undefined", op)
+	}
+	if len(operands) != len(def.OperandWidths) {
+		return nil, fmt.Errorf("%s wants %d operands, got %d", def.Name, len(def.OperandWidths), len(operands))
+	}
+	if fits(def, operands) {
+		return Make(op, operands...), nil
+	}
+	wide := Widen(def)
+	for i, o := range operands {
+		if o < 0 || o > maxOperand(wide.OperandWidths[i]) {
+			return nil, fmt.Errorf("operand %d of %s out of range: %d, the maximum is %d", i, def.Name, o, maxOperand(wide.OperandWidths[i]))
+		}
+	}
+	return MakeWide(op, operands...), nil
+}
+
+// Fits reports whether the operands fit in op without an OpWide prefix
+func Fits(op Opcode, operands ...int) bool {
+	def, ok := definitions[op]
+	return ok && fits(def, operands)
+}
+
+func fits(def *Definition, operands []int) bool {
+	for i, o := range operands {
+		if o < 0 || o > maxOperand(def.OperandWidths[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+func maxOperand(width int) int {
+	return 1<<(8*width) - 1
+}
+
 // Make : use the op and operands to make instruction(byte array)
 // the first byte is Opcode (1 type)
 func Make(op Opcode, operands ...int) []byte {
@@ -106,6 +194,10 @@ func Make(op Opcode, operands ...int) []byte {
 	if !ok {
 		return []byte{}
 	}
+	return makeInstruction(op, def, operands)
+}
+
+func makeInstruction(op Opcode, def *Definition, operands []int) []byte {
 	instructionLen := 1
 	for _, w := range def.OperandWidths {
 		instructionLen += w
@@ -116,6 +208,8 @@ func Make(op Opcode, operands ...int) []byte {
 	for i, o := range operands {
 		width := def.OperandWidths[i]
 		switch width {
+		case 4:
+			binary.BigEndian.PutUint32(instruction[offset:], uint32(o))
 		case 2:
 			binary.BigEndian.PutUint16(instruction[offset:], uint16(o))
 		case 1:
@@ -154,11 +248,22 @@ func (ins Instructions) String() string {
 			if err != nil {
 				return ""
 			}
+			i++
 			continue
 		}
+		prefix := ""
+		start := i
+		if Opcode(ins[i]) == OpWide && i+1 < len(ins) {
+			wideDef, err := Lookup(ins[i+1])
+			if err == nil {
+				prefix = "OpWide "
+				def = Widen(wideDef)
+				i++
+			}
+		}
 		operands, read := ReadOperands(def, ins[i+1:])
 		// the variable i is the index of byte in Instructions
-		_, err = fmt.Fprintf(&out, "%04d %s ", i, ins.fmtInstruction(def, operands))
+		_, err = fmt.Fprintf(&out, "%04d %s%s\n", start, prefix, ins.fmtInstruction(def, operands))
 		if err != nil {
 			return ""
 		}
@@ -172,6 +277,8 @@ func ReadOperands(def *Definition, ins Instructions) ([]int, int) {
 	offset := 0
 	for i, width := range def.OperandWidths {
 		switch width {
+		case 4:
+			operands[i] = int(ReadUint32(ins[offset:]))
 		case 2:
 			operands[i] = int(ReadUint16(ins[offset:]))
 		case 1:
@@ -182,6 +289,10 @@ func ReadOperands(def *Definition, ins Instructions) ([]int, int) {
 	return operands, offset
 }
 
+func ReadUint32(ins Instructions) uint32 {
+	return binary.BigEndian.Uint32(ins)
+}
+
 func ReadUint16(ins Instructions) uint16 {
 	return binary.BigEndian.Uint16(ins)
 }
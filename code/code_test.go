@@ -82,3 +82,54 @@ func TestReadOperands(t *testing.T) {
 		}
 	}
 }
+
+func TestEncode(t *testing.T) {
+	tests := []struct {
+		op       Opcode
+		operands []int
+		expected []byte
+	}{
+		{OpConstant, []int{65535}, []byte{byte(OpConstant), 255, 255}},
+		{OpConstant, []int{65536}, []byte{byte(OpWide), byte(OpConstant), 0, 1, 0, 0}},
+		{OpGetLocal, []int{256}, []byte{byte(OpWide), byte(OpGetLocal), 1, 0}},
+		{OpClosure, []int{1, 256}, []byte{byte(OpWide), byte(OpClosure), 0, 0, 0, 1, 1, 0}},
+		{OpAdd, []int{}, []byte{byte(OpAdd)}},
+	}
+	for _, tt := range tests {
+		instruction, err := Encode(tt.op, tt.operands...)
+		if err != nil {
+			t.Fatalf("encode failed: %s", err)
+		}
+		if string(instruction) != string(tt.expected) {
+			t.Errorf("wrong instruction. want=%v, got=%v", tt.expected, instruction)
+		}
+	}
+
+	errors := []struct {
+		op       Opcode
+		operands []int
+	}{
+		{OpGetLocal, []int{65536}},
+		{OpConstant, []int{-1}},
+		{OpCall, []int{}},
+	}
+	for _, tt := range errors {
+		if _, err := Encode(tt.op, tt.operands...); err == nil {
+			t.Errorf("expected an error encoding %d %v", tt.op, tt.operands)
+		}
+	}
+}
+
+func TestWideInstructionsString(t *testing.T) {
+	instructions := Instructions{}
+	instructions = append(instructions, MakeWide(OpConstant, 70000)...)
+	instructions = append(instructions, MakeWide(OpGetLocal, 300)...)
+	instructions = append(instructions, Make(OpPop)...)
+	expected := `0000 OpWide OpConstant 70000
+0006 OpWide OpGetLocal 300
+0010 OpPop
+`
+	if instructions.String() != expected {
+		t.Errorf("instructions wrongly formatted.\nwant=%q\ngot=%q", expected, instructions.String())
+	}
+}
@@ -5,7 +5,6 @@ import (
 	"jonathan/ast"
 	"jonathan/code"
 	"jonathan/object"
-	"sort"
 )
 
 // EmittedInstruction for jump
@@ -20,49 +19,109 @@ type CompilationScope struct {
 	instructions        code.Instructions  // the result instructions byte[] the compiler generated
 	lastInstruction     EmittedInstruction // the last emitted instruction
 	previousInstruction EmittedInstruction // the one before last emitted instruction
+	referencedNames     map[string]bool    // the names the function of the scope reads, set when dead code is eliminated
+	jumps               []emittedJump      // the jumps emitJump emitted, patchJump sets their targets
+}
+
+// emittedJump is a jump of a scope. Its position moves when a jump before it is widened.
+type emittedJump struct {
+	position int
+	patched  bool // the target is set, until then the operand is bogus
 }
 
 type Compiler struct {
-	constants   []object.Object // constant pool include integer\string\functionLiteral
-	symbolTable *SymbolTable    // trace the scope of symbol
+	constants   []object.Object         // constant pool include integer\string\functionLiteral
+	symbolTable *SymbolTable            // trace the scope of symbol
+	builtins    *object.BuiltinRegistry // the builtins the symbol table resolves, handed on to the vm in Bytecode
 	// it's convenient control of scopes when we decode the instruction
-	scopes     []CompilationScope // trace the instruction emitted. the instructions is a two-dimensional instructions arrays
-	scopeIndex int                // the index of scope depth
+	scopes        []CompilationScope // trace the instruction emitted. the instructions is a two-dimensional instructions arrays
+	scopeIndex    int                // the index of scope depth
+	optimizations Optimizations
+	strings       map[string]int // the index of every string constant
+	warnings      []string
+	err           error // the first instruction that couldn't be encoded, Compile returns it
+}
+
+// MaxGlobals is the number of global bindings the vm has room for.
+const MaxGlobals = 65536
+
+// jumps emitted at this position or later use wide operands right away, their targets are likely beyond 65535
+const wideJumpPosition = 1 << 15
+
+// Optimizations selects the optimisation passes of a compiler. None is enabled by default.
+type Optimizations uint
+
+const (
+	// FoldConstants evaluates constant arithmetic, comparisons and prefixes and the branches of constant if conditions at compile time
+	FoldConstants Optimizations = 1 << iota
+	// Peephole rewrites wasteful instruction sequences of every function and of the main program
+	Peephole
+	// EliminateDeadCode drops statements that can't be reached and bindings of pure values a function never reads
+	EliminateDeadCode
+	// Specialize replaces common instruction sequences with specialised opcodes, like OpAddConst for OpConstant and OpAdd
+	Specialize
+)
+
+// AllOptimizations enables every optimisation pass.
+const AllOptimizations = FoldConstants | Peephole | EliminateDeadCode | Specialize
+
+// Warnings returns the diagnostics about the code compiled so far, like statements that can't be reached.
+func (c *Compiler) Warnings() []string {
+	warnings := make([]string, len(c.warnings))
+	copy(warnings, c.warnings)
+	return warnings
+}
+
+// SetOptimizations enables the given passes for everything compiled afterwards.
+func (c *Compiler) SetOptimizations(optimizations Optimizations) {
+	c.optimizations = optimizations
 }
 
 func NewCompiler() *Compiler {
+	return NewCompilerWithBuiltins(object.NewBuiltinRegistry())
+}
+
+// NewCompilerWithBuiltins compiles against the given registry instead of the default builtins.
+func NewCompilerWithBuiltins(builtins *object.BuiltinRegistry) *Compiler {
 	mainScope := CompilationScope{
 		instructions:        code.Instructions{},
 		lastInstruction:     EmittedInstruction{},
 		previousInstruction: EmittedInstruction{},
 	}
 	symbolTable := NewSymbolTable()
-	for i, v := range object.Builtins {
+	for i, v := range builtins.Definitions() {
 		symbolTable.DefineBuiltin(i, v.Name)
 	}
 	return &Compiler{
 		constants:   []object.Object{},
 		symbolTable: symbolTable,
+		builtins:    builtins,
 		scopes:      []CompilationScope{mainScope},
 		scopeIndex:  0,
+		strings:     map[string]int{},
 	}
 }
 
-func NewCompilerWithState(s *SymbolTable, constants []object.Object) *Compiler {
-	compiler := NewCompiler()
+// NewCompilerWithState continues compiling with the symbol table and constants of a previous run.
+// builtins must be the registry the builtins of s were defined from.
+func NewCompilerWithState(s *SymbolTable, constants []object.Object, builtins *object.BuiltinRegistry) *Compiler {
+	compiler := NewCompilerWithBuiltins(builtins)
 	compiler.symbolTable = s
 	compiler.constants = constants
+	for i, constant := range constants {
+		if str, ok := constant.(*object.String); ok {
+			compiler.strings[str.Value] = i
+		}
+	}
 	return compiler
 }
 
 func (c *Compiler) Compile(node ast.Node) error {
 	switch node := node.(type) {
 	case *ast.Program:
-		for _, s := range node.Statements {
-			err := c.Compile(s)
-			if err != nil {
-				return err
-			}
+		err := c.compileStatements(node.Statements)
+		if err != nil {
+			return err
 		}
 	case *ast.ExpressionStatement:
 		err := c.Compile(node.Expression)
@@ -74,10 +133,17 @@ func (c *Compiler) Compile(node ast.Node) error {
 		// this will be executed, when the expression operand is a identifier
 		symbol, ok := c.symbolTable.Resolve(node.Value)
 		if !ok {
+			if constant, ok := c.builtins.Constant(node.Value); ok {
+				c.emit(code.OpConstant, c.addConstant(constant))
+				return nil
+			}
 			return fmt.Errorf("undefined variable %s", node.Value)
 		}
 		c.loadSymbol(symbol)
 	case *ast.LetStatement:
+		if c.isUnusedBinding(node) {
+			return nil
+		}
 		// The function body could use the symbol before the body is compiled
 		symbol := c.symbolTable.DefineSymbol(node.Name.Value)
 		// Note: compile expression first. if the value is a integer. the previous instruction is a integer.
@@ -87,19 +153,30 @@ func (c *Compiler) Compile(node ast.Node) error {
 		if err != nil {
 			return err
 		}
+		if symbol.Scope == GlobalScope && symbol.Index >= MaxGlobals {
+			return fmt.Errorf("too many global bindings: %s is number %d, the limit is %d", symbol.Name, symbol.Index+1, MaxGlobals)
+		}
 		//symbol := c.symbolTable.DefineSymbol(node.Name.Value)
+		if isInteger(node.Value, c.symbolTable) {
+			c.symbolTable.markInteger(symbol)
+		}
 		if symbol.Scope == GlobalScope {
 			c.emit(code.OpSetGlobal, symbol.Index)
 		} else {
 			c.emit(code.OpSetLocal, symbol.Index)
 		}
 	case *ast.IfExpression:
+		if c.optimizations&(FoldConstants|EliminateDeadCode) != 0 {
+			if truthy, ok := constantTruthiness(fold(node.Condition, c.symbolTable)); ok {
+				return c.compileConstantIf(node, truthy)
+			}
+		}
 		err := c.Compile(node.Condition)
 		if err != nil {
 			return err
 		}
-		// Emit an `OpJumpNotTruthy` with a bogus value. and get the position of `OpJumpNotTruthy`
-		jumpNotTruthyPos := c.emit(code.OpJumpNotTruthy, 9999)
+		// Emit an `OpJumpNotTruthy` with a bogus value. and get the jump of `OpJumpNotTruthy`
+		jumpNotTruthy := c.emitJump(code.OpJumpNotTruthy)
 		err = c.Compile(node.Consequence)
 		if err != nil {
 			return err
@@ -108,10 +185,9 @@ func (c *Compiler) Compile(node ast.Node) error {
 			c.removeLastPop()
 		}
 		// Emit an `OpJump` with a bogus value
-		jumpPos := c.emit(code.OpJump, 9999)
-		// the position that 'no truthy' condition should jump to，the alternative expression
-		afterConsequencePos := len(c.currentInstructions())
-		c.changeOperand(jumpNotTruthyPos, afterConsequencePos)
+		jump := c.emitJump(code.OpJump)
+		// 'no truthy' condition jumps to the position after the jump, the alternative expression
+		c.patchJump(jumpNotTruthy)
 		if node.Alternative == nil {
 			c.emit(code.OpNull)
 		} else {
@@ -123,17 +199,19 @@ func (c *Compiler) Compile(node ast.Node) error {
 				c.removeLastPop()
 			}
 		}
-		// the position that 'truthy' condition should jump to，the position after alternative expression
-		afterAlternativePos := len(c.currentInstructions())
-		c.changeOperand(jumpPos, afterAlternativePos)
+		// 'truthy' condition jumps to the position after alternative expression
+		c.patchJump(jump)
 	case *ast.BlockStatement:
-		for _, s := range node.Statements {
-			err := c.Compile(s)
-			if err != nil {
-				return err
-			}
+		err := c.compileStatements(node.Statements)
+		if err != nil {
+			return err
 		}
 	case *ast.PrefixExpression:
+		if c.optimizations&FoldConstants != 0 {
+			if folded := fold(node, c.symbolTable); folded != ast.Expression(node) {
+				return c.Compile(folded)
+			}
+		}
 		err := c.Compile(node.Right)
 		if err != nil {
 			return err
@@ -147,6 +225,11 @@ func (c *Compiler) Compile(node ast.Node) error {
 			return fmt.Errorf("unknown operator %s", node.Operator)
 		}
 	case *ast.InfixExpression:
+		if c.optimizations&FoldConstants != 0 {
+			if folded := fold(node, c.symbolTable); folded != ast.Expression(node) {
+				return c.Compile(folded)
+			}
+		}
 		if node.Operator == "<" {
 			err := c.Compile(node.Right)
 			if err != nil {
@@ -189,6 +272,9 @@ func (c *Compiler) Compile(node ast.Node) error {
 	case *ast.IntegerLiteral:
 		integer := &object.Integer{Value: node.Value}
 		c.emit(code.OpConstant, c.addConstant(integer))
+	case *ast.FloatLiteral:
+		float := &object.Float{Value: node.Value}
+		c.emit(code.OpConstant, c.addConstant(float))
 	case *ast.StringLiteral:
 		str := &object.String{Value: node.Value}
 		c.emit(code.OpConstant, c.addConstant(str))
@@ -201,15 +287,8 @@ func (c *Compiler) Compile(node ast.Node) error {
 		}
 		c.emit(code.OpArray, len(node.Elements))
 	case *ast.HashLiteral:
-		var keys []ast.Expression
-		for k := range node.Pairs {
-			keys = append(keys, k)
-		}
-		sort.Slice(keys, func(i, j int) bool {
-			return keys[i].String() < keys[j].String()
-		})
-		// key and valve compiled into the instructions, then generate opHash instruction
-		for _, k := range keys {
+		// key and valve compiled into the instructions in the order of the source, then generate opHash instruction
+		for _, k := range node.Keys {
 			err := c.Compile(k)
 			if err != nil {
 				return err
@@ -230,6 +309,23 @@ func (c *Compiler) Compile(node ast.Node) error {
 			return err
 		}
 		c.emit(code.OpIndex)
+	case *ast.SliceExpression:
+		err := c.Compile(node.Left)
+		if err != nil {
+			return err
+		}
+		// a bound left out is null
+		for _, bound := range []ast.Expression{node.Start, node.End} {
+			if bound == nil {
+				c.emit(code.OpNull)
+				continue
+			}
+			err := c.Compile(bound)
+			if err != nil {
+				return err
+			}
+		}
+		c.emit(code.OpSlice)
 	case *ast.Boolean:
 		if node.Value {
 			c.emit(code.OpTrue)
@@ -238,6 +334,10 @@ func (c *Compiler) Compile(node ast.Node) error {
 		}
 	case *ast.FunctionLiteral:
 		c.enterScope()
+		if c.optimizations&EliminateDeadCode != 0 {
+			c.scopes[c.scopeIndex].referencedNames = map[string]bool{}
+			referencedNames(node.Body, c.scopes[c.scopeIndex].referencedNames)
+		}
 		if node.Name != "" {
 			c.symbolTable.DefineFunctionName(node.Name)
 		}
@@ -264,6 +364,7 @@ func (c *Compiler) Compile(node ast.Node) error {
 			Instructions:  instructions,
 			NumLocals:     numLocals,
 			NumParameters: len(node.Parameters),
+			Name:          node.Name,
 		}
 		fnIndex := c.addConstant(compiledFn)
 		c.emit(code.OpClosure, fnIndex, len(freeSymbols))
@@ -285,13 +386,80 @@ func (c *Compiler) Compile(node ast.Node) error {
 				return err
 			}
 		}
-		c.emit(code.OpCall, len(node.Arguments))
+		if node.Tail && c.scopeIndex > 0 {
+			c.emit(code.OpTailCall, len(node.Arguments))
+		} else {
+			c.emit(code.OpCall, len(node.Arguments))
+		}
 
 	}
 	c.PrintStatements()
+	return c.err
+}
+
+// compileStatements compiles the statements of a block. The statements after one that always returns
+// are reported in a warning and dropped when dead code is eliminated.
+func (c *Compiler) compileStatements(statements []ast.Statement) error {
+	reachable := true
+	for i, s := range statements {
+		err := c.Compile(s)
+		if err != nil {
+			return err
+		}
+		if reachable && terminates(s) && i < len(statements)-1 {
+			c.warnings = append(c.warnings, fmt.Sprintf("unreachable code after return: %s", statements[i+1].String()))
+			if c.optimizations&EliminateDeadCode != 0 {
+				return nil
+			}
+			reachable = false
+		}
+	}
+	return nil
+}
+
+// isUnusedBinding reports whether let binds a pure value to a name its function never reads
+func (c *Compiler) isUnusedBinding(let *ast.LetStatement) bool {
+	referenced := c.scopes[c.scopeIndex].referencedNames
+	return referenced != nil && !referenced[let.Name.Value] && isPure(let.Value)
+}
+
+// compileConstantIf compiles only the branch a constant condition selects. The lets of the other branch
+// still define their names, as they do without the optimisation, the names just never get a value.
+func (c *Compiler) compileConstantIf(node *ast.IfExpression, truthy bool) error {
+	branch, dropped := node.Consequence, node.Alternative
+	if !truthy {
+		branch, dropped = dropped, branch
+		c.defineDroppedLets(dropped)
+	}
+	if branch == nil {
+		c.emit(code.OpNull)
+	} else {
+		err := c.Compile(branch)
+		if err != nil {
+			return err
+		}
+		if c.lastInstructionIs(code.OpPop) {
+			c.removeLastPop()
+		}
+	}
+	if truthy {
+		c.defineDroppedLets(dropped)
+	}
 	return nil
 }
 
+// defineDroppedLets defines the names the lets of a branch that isn't compiled would have defined
+func (c *Compiler) defineDroppedLets(dropped *ast.BlockStatement) {
+	if dropped == nil {
+		return
+	}
+	for _, let := range letStatements(dropped, nil) {
+		if !c.isUnusedBinding(let) {
+			c.symbolTable.DefineSymbol(let.Name.Value)
+		}
+	}
+}
+
 func (c *Compiler) lastInstructionIs(op code.Opcode) bool {
 	if len(c.currentInstructions()) == 0 {
 		return false
@@ -315,26 +483,45 @@ func (c *Compiler) removeLastPop() {
 }
 
 func (c *Compiler) Bytecode() *Bytecode {
+	instructions := c.currentInstructions()
+	instructions = c.optimize(instructions)
 	return &Bytecode{
-		Instructions: c.currentInstructions(),
+		Instructions: instructions,
 		Constants:    c.constants,
+		Builtins:     c.builtins,
 	}
 }
 
 type Bytecode struct {
 	Instructions code.Instructions
 	Constants    []object.Object
+	Builtins     *object.BuiltinRegistry // the builtins OpGetBuiltin indexes into
 }
 
 // Store the operand object and get its index, then store the index in the instruction
+// Strings are interned: equal string constants share one index and so one object in the vm.
 func (c *Compiler) addConstant(obj object.Object) int {
+	str, ok := obj.(*object.String)
+	if ok {
+		if index, ok := c.strings[str.Value]; ok {
+			return index
+		}
+	}
 	c.constants = append(c.constants, obj)
+	if ok {
+		c.strings[str.Value] = len(c.constants) - 1
+	}
 	return len(c.constants) - 1
 }
 
 // Get instruction of the op,and add it into instructions. return the start position of op instruction
+// Operands too large for op are emitted with an OpWide prefix, operands that don't fit even then
+// make Compile fail.
 func (c *Compiler) emit(op code.Opcode, operands ...int) int {
-	ins := code.Make(op, operands...)
+	ins, err := code.Encode(op, operands...)
+	if err != nil && c.err == nil {
+		c.err = err
+	}
 	pos := c.addInstruction(ins)
 
 	c.setLastInstruction(op, pos)
@@ -374,11 +561,91 @@ func (c *Compiler) replaceInstruction(pos int, newInstruction []byte) {
 	}
 }
 
-// change the operand of the instruction and replace
-func (c *Compiler) changeOperand(opPos int, operand int) {
-	op := code.Opcode(c.currentInstructions()[opPos])
-	newInstruction := code.Make(op, operand)
-	c.replaceInstruction(opPos, newInstruction)
+// emitJump emits a jump with a bogus target that patchJump replaces, it returns the jump's index in the scope.
+// Far into large functions the jump is emitted wide right away.
+func (c *Compiler) emitJump(op code.Opcode) int {
+	var pos int
+	if len(c.currentInstructions()) < wideJumpPosition {
+		pos = c.emit(op, 9999)
+	} else {
+		pos = c.addInstruction(code.MakeWide(op, 9999))
+		c.setLastInstruction(op, pos)
+	}
+	scope := &c.scopes[c.scopeIndex]
+	scope.jumps = append(scope.jumps, emittedJump{position: pos})
+	return len(scope.jumps) - 1
+}
+
+// patchJump sets the target of the jump to the end of the instructions.
+// A narrow jump the target doesn't fit in is widened first.
+func (c *Compiler) patchJump(jump int) {
+	scope := &c.scopes[c.scopeIndex]
+	pos := scope.jumps[jump].position
+	ins := c.currentInstructions()
+	if code.Opcode(ins[pos]) != code.OpWide && !code.Fits(code.Opcode(ins[pos]), len(ins)) {
+		c.widenJump(jump)
+		pos = scope.jumps[jump].position
+		ins = c.currentInstructions()
+	}
+	if code.Opcode(ins[pos]) == code.OpWide {
+		c.replaceInstruction(pos, code.MakeWide(code.Opcode(ins[pos+1]), len(ins)))
+	} else {
+		c.replaceInstruction(pos, code.Make(code.Opcode(ins[pos]), len(ins)))
+	}
+	scope.jumps[jump].patched = true
+}
+
+// widenJump gives the jump a wide operand and moves the instructions after it. The targets of the patched jumps
+// are relocated, narrow ones that no longer fit are widened as well.
+func (c *Compiler) widenJump(jump int) {
+	scope := &c.scopes[c.scopeIndex]
+	list, err := decodeInstructions(scope.instructions)
+	if err != nil {
+		if c.err == nil {
+			c.err = err
+		}
+		return
+	}
+	indexes := make(map[int]int, len(list)) // the index of the instruction at every position
+	for i, ins := range list {
+		indexes[ins.position] = i
+	}
+	targets := map[int]int{} // the index of the instruction every patched jump lands on
+	for _, emitted := range scope.jumps {
+		if emitted.patched {
+			i := indexes[emitted.position]
+			targets[i] = resolveJump(list, list[i].operands[0])
+		}
+	}
+	list[indexes[scope.jumps[jump].position]].wide = true
+
+	positions := make([]int, len(list)+1) // the new position of every instruction and the end
+	for grown := true; grown; {
+		for i, ins := range list {
+			positions[i+1] = positions[i] + len(encodeInstruction(ins, ins.operands))
+		}
+		grown = false
+		for i, target := range targets {
+			if !list[i].wide && !code.Fits(list[i].op, positions[target]) {
+				list[i].wide = true
+				grown = true
+			}
+		}
+	}
+	out := make(code.Instructions, 0, positions[len(list)])
+	for i, ins := range list {
+		operands := ins.operands
+		if target, ok := targets[i]; ok {
+			operands = []int{positions[target]}
+		}
+		out = append(out, encodeInstruction(ins, operands)...)
+	}
+	scope.instructions = out
+	for i := range scope.jumps {
+		scope.jumps[i].position = positions[indexes[scope.jumps[i].position]]
+	}
+	scope.lastInstruction.Position = positions[indexes[scope.lastInstruction.Position]]
+	scope.previousInstruction.Position = positions[indexes[scope.previousInstruction.Position]]
 }
 
 // create a new instructions array
@@ -399,6 +666,17 @@ func (c *Compiler) leaveScope() code.Instructions {
 	c.scopes = c.scopes[:len(c.scopes)-1]
 	c.scopeIndex--
 	c.symbolTable = c.symbolTable.Outer // come back to the outer symbol table of scope
+	return c.optimize(instructions)
+}
+
+// optimize runs the passes over the instructions of a finished scope
+func (c *Compiler) optimize(instructions code.Instructions) code.Instructions {
+	if c.optimizations&Peephole != 0 {
+		instructions = peephole(instructions)
+	}
+	if c.optimizations&Specialize != 0 {
+		instructions = specialize(instructions)
+	}
 	return instructions
 }
 
@@ -148,10 +148,16 @@ func TestBooleanExpressions(t *testing.T) {
 }
 
 func runCompilerTests(t *testing.T, tests []compilerTestCase) {
+	t.Helper()
+	runCompilerTestsWithOptimizations(t, tests, 0)
+}
+
+func runCompilerTestsWithOptimizations(t *testing.T, tests []compilerTestCase, optimizations Optimizations) {
 	t.Helper()
 	for _, tt := range tests {
 		program := parse(tt.input)
 		compiler := NewCompiler()
+		compiler.SetOptimizations(optimizations)
 		err := compiler.Compile(program)
 		if err != nil {
 			t.Fatalf("compiler error: %s", err)
@@ -212,6 +218,11 @@ func testConstants(
 			if err != nil {
 				return fmt.Errorf("constant %d - testIntegerObject failed: %s", i, err)
 			}
+		case float64:
+			err := testFloatObject(constant, actual[i])
+			if err != nil {
+				return fmt.Errorf("constant %d - testFloatObject failed: %s", i, err)
+			}
 		case string:
 			err := testStringObject(constant, actual[i])
 			if err != nil {
@@ -243,6 +254,18 @@ func testIntegerObject(expected int64, actual object.Object) error {
 	return nil
 }
 
+func testFloatObject(expected float64, actual object.Object) error {
+	result, ok := actual.(*object.Float)
+	if !ok {
+		return fmt.Errorf("object is not Float. got=%T (%+v)", actual, actual)
+	}
+	if result.Value != expected {
+		return fmt.Errorf("object has wrong value. got=%g, want=%g",
+			result.Value, expected)
+	}
+	return nil
+}
+
 func testStringObject(expected string, actual object.Object) error {
 	result, ok := actual.(*object.String)
 	if !ok {
@@ -366,6 +389,22 @@ func TestStringExpressions(t *testing.T) {
 				code.Make(code.OpConstant, 1),
 				code.Make(code.OpAdd),
 				code.Make(code.OpPop),
+			}}, {
+			// equal strings share one constant
+			input: `"mon" + "key" + "mon"; fn() { "key" }`,
+			expectedConstants: []interface{}{"mon", "key", []code.Instructions{
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpReturnValue),
+			}},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpAdd),
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpAdd),
+				code.Make(code.OpPop),
+				code.Make(code.OpClosure, 2, 0),
+				code.Make(code.OpPop),
 			}},
 	}
 
@@ -478,6 +517,16 @@ func TestIndexExpressions(t *testing.T) {
 				code.Make(code.OpIndex),
 				code.Make(code.OpPop),
 			}},
+		{
+			input:             `"abc"[1:]`,
+			expectedConstants: []interface{}{"abc", 1},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpNull),
+				code.Make(code.OpSlice),
+				code.Make(code.OpPop),
+			}},
 	}
 
 	runCompilerTests(t, tests)
@@ -778,7 +827,7 @@ func TestBuiltins(t *testing.T) {
 				[]code.Instructions{
 					code.Make(code.OpGetBuiltin, 0),
 					code.Make(code.OpArray, 0),
-					code.Make(code.OpCall, 1),
+					code.Make(code.OpTailCall, 1),
 					code.Make(code.OpReturnValue),
 				},
 			},
@@ -912,7 +961,7 @@ func TestRecusiveFunction(t *testing.T) {
 					code.Make(code.OpGetLocal, 0),
 					code.Make(code.OpConstant, 0),
 					code.Make(code.OpSub),
-					code.Make(code.OpCall, 1),
+					code.Make(code.OpTailCall, 1),
 					code.Make(code.OpReturnValue),
 				},
 				1,
@@ -941,7 +990,7 @@ func TestRecusiveFunction(t *testing.T) {
 					code.Make(code.OpGetLocal, 0),
 					code.Make(code.OpConstant, 0),
 					code.Make(code.OpSub),
-					code.Make(code.OpCall, 1),
+					code.Make(code.OpTailCall, 1),
 					code.Make(code.OpReturnValue),
 				},
 				1,
@@ -950,7 +999,7 @@ func TestRecusiveFunction(t *testing.T) {
 					code.Make(code.OpSetLocal, 0),
 					code.Make(code.OpGetLocal, 0),
 					code.Make(code.OpConstant, 2),
-					code.Make(code.OpCall, 1),
+					code.Make(code.OpTailCall, 1),
 					code.Make(code.OpReturnValue),
 				}},
 			expectedInstructions: []code.Instructions{
@@ -963,3 +1012,229 @@ func TestRecusiveFunction(t *testing.T) {
 	}
 	runCompilerTests(t, tests)
 }
+
+func TestConstantFolding(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			// floats round, they are left for the vm
+			input:             "1.5 * 2",
+			expectedConstants: []interface{}{1.5, 2},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpMul),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             "1 + 2 * 3",
+			expectedConstants: []interface{}{7},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             "-(10 - 4) / 2",
+			expectedConstants: []interface{}{-3},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             `"mon" + "key"`,
+			expectedConstants: []interface{}{"monkey"},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             "1 < 2 == !false",
+			expectedConstants: []interface{}{},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpTrue),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             "!5",
+			expectedConstants: []interface{}{},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpFalse),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			// division by zero stays a runtime error
+			input:             "1 / (2 - 2)",
+			expectedConstants: []interface{}{1, 0},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpDiv),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			// strings are compared by value
+			input:             `"a" != "b"`,
+			expectedConstants: []interface{}{},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpTrue),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             "let x = 1; x + 2 - 5",
+			expectedConstants: []interface{}{1, 3},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpSub),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             "let x = 1; x * 2 * 3",
+			expectedConstants: []interface{}{1, 6},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpMul),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			// x may be a float, whose products round and don't wrap around
+			input:             "let x = len([]); x * 2 * 3",
+			expectedConstants: []interface{}{2, 3},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpGetBuiltin, 0),
+				code.Make(code.OpArray, 0),
+				code.Make(code.OpCall, 1),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpMul),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpMul),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             "if (1 > 2) { 10 } else { 20 }; 3333;",
+			expectedConstants: []interface{}{20, 3333},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpPop),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             "if (false) { 10 }",
+			expectedConstants: []interface{}{},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpNull),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+	runCompilerTestsWithOptimizations(t, tests, FoldConstants)
+}
+
+func TestWideOperands(t *testing.T) {
+	constants := make([]object.Object, 70000)
+	builtins := object.NewBuiltinRegistry()
+	compiler := NewCompilerWithState(NewSymbolTable(), constants, builtins)
+	err := compiler.Compile(parse("1"))
+	if err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	err = testInstructions([]code.Instructions{
+		code.MakeWide(code.OpConstant, 70000),
+		code.Make(code.OpPop),
+	}, compiler.Bytecode().Instructions)
+	if err != nil {
+		t.Fatalf("testInstructions failed: %s", err)
+	}
+
+	// jumps far into a large function are emitted wide
+	compiler = NewCompiler()
+	compiler.scopes[0].instructions = make(code.Instructions, 70002)
+	err = compiler.Compile(parse("if (true) { 1 } else { 2 }"))
+	if err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	err = testInstructions([]code.Instructions{
+		make(code.Instructions, 70002),
+		code.Make(code.OpTrue),
+		code.MakeWide(code.OpJumpNotTruthy, 70018),
+		code.Make(code.OpConstant, 0),
+		code.MakeWide(code.OpJump, 70021),
+		code.Make(code.OpConstant, 1),
+		code.Make(code.OpPop),
+	}, compiler.Bytecode().Instructions)
+	if err != nil {
+		t.Fatalf("testInstructions failed: %s", err)
+	}
+
+	// a jump emitted narrow is widened once its target is beyond 65535, the jumps after it move
+	compiler = NewCompiler()
+	outer := compiler.emitJump(code.OpJumpNotTruthy)
+	inner := compiler.emitJump(code.OpJump)
+	compiler.emit(code.OpTrue)
+	compiler.patchJump(inner)
+	compiler.addInstruction(make(code.Instructions, 70002))
+	compiler.patchJump(outer)
+	err = testInstructions([]code.Instructions{
+		code.MakeWide(code.OpJumpNotTruthy, 70012),
+		code.Make(code.OpJump, 10),
+		code.Make(code.OpTrue),
+		make(code.Instructions, 70002),
+	}, compiler.currentInstructions())
+	if err != nil {
+		t.Fatalf("testInstructions failed: %s", err)
+	}
+
+	// patched jumps whose targets no longer fit once a jump before them is widened are widened as well
+	compiler = NewCompiler()
+	first := compiler.emitJump(code.OpJump)
+	second := compiler.emitJump(code.OpJump)
+	compiler.addInstruction(make(code.Instructions, 65529))
+	compiler.patchJump(first)
+	compiler.addInstruction(make(code.Instructions, 6000))
+	compiler.patchJump(second)
+	err = testInstructions([]code.Instructions{
+		code.MakeWide(code.OpJump, 65541),
+		code.MakeWide(code.OpJump, 71541),
+		make(code.Instructions, 71529),
+	}, compiler.currentInstructions())
+	if err != nil {
+		t.Fatalf("testInstructions failed: %s", err)
+	}
+}
+
+func TestOperandLimits(t *testing.T) {
+	symbolTable := NewSymbolTable()
+	for i := 0; i < MaxGlobals; i++ {
+		symbolTable.DefineSymbol(fmt.Sprintf("g%d", i))
+	}
+	compiler := NewCompilerWithState(symbolTable, []object.Object{}, object.NewBuiltinRegistry())
+	err := compiler.Compile(parse("let oneTooMany = 1;"))
+	if err == nil || err.Error() != "too many global bindings: oneTooMany is number 65537, the limit is 65536" {
+		t.Errorf("wrong globals error. got=%v", err)
+	}
+
+	compiler = NewCompiler()
+	compiler.emit(code.OpGetLocal, 65536)
+	if compiler.err == nil {
+		t.Errorf("expected an error for a local index beyond the wide operand")
+	}
+}
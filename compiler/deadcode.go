@@ -0,0 +1,162 @@
+package compiler
+
+import "jonathan/ast"
+
+// terminates reports whether the statements after statement in the same block can never run
+func terminates(statement ast.Statement) bool {
+	switch statement := statement.(type) {
+	case *ast.ReturnStatement:
+		return true
+	case *ast.ExpressionStatement:
+		ifExpression, ok := statement.Expression.(*ast.IfExpression)
+		if !ok || ifExpression.Alternative == nil {
+			return false
+		}
+		return blockTerminates(ifExpression.Consequence) && blockTerminates(ifExpression.Alternative)
+	}
+	return false
+}
+
+func blockTerminates(block *ast.BlockStatement) bool {
+	for _, statement := range block.Statements {
+		if terminates(statement) {
+			return true
+		}
+	}
+	return false
+}
+
+// isPure reports whether evaluating expression can neither fail nor have side effects,
+// so a binding of it nobody reads can be dropped
+func isPure(expression ast.Expression) bool {
+	switch expression := expression.(type) {
+	case *ast.IntegerLiteral, *ast.FloatLiteral, *ast.StringLiteral, *ast.Boolean:
+		return true
+	case *ast.PrefixExpression:
+		return expression.Operator == "!" && isPure(expression.Right)
+	case *ast.ArrayLiteral:
+		for _, element := range expression.Elements {
+			if !isPure(element) {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// referencedNames collects the names of all identifiers node reads, including those in nested functions.
+// It goes by name only, a name a nested function shadows still counts as read.
+func referencedNames(node ast.Node, names map[string]bool) {
+	switch node := node.(type) {
+	case *ast.Program:
+		for _, s := range node.Statements {
+			referencedNames(s, names)
+		}
+	case *ast.BlockStatement:
+		for _, s := range node.Statements {
+			referencedNames(s, names)
+		}
+	case *ast.ExpressionStatement:
+		referencedNames(node.Expression, names)
+	case *ast.LetStatement:
+		referencedNames(node.Value, names)
+	case *ast.ReturnStatement:
+		referencedNames(node.ReturnValue, names)
+	case *ast.Identifier:
+		names[node.Value] = true
+	case *ast.PrefixExpression:
+		referencedNames(node.Right, names)
+	case *ast.InfixExpression:
+		referencedNames(node.Left, names)
+		referencedNames(node.Right, names)
+	case *ast.IfExpression:
+		referencedNames(node.Condition, names)
+		referencedNames(node.Consequence, names)
+		if node.Alternative != nil {
+			referencedNames(node.Alternative, names)
+		}
+	case *ast.FunctionLiteral:
+		referencedNames(node.Body, names)
+	case *ast.CallExpression:
+		referencedNames(node.Function, names)
+		for _, a := range node.Arguments {
+			referencedNames(a, names)
+		}
+	case *ast.ArrayLiteral:
+		for _, el := range node.Elements {
+			referencedNames(el, names)
+		}
+	case *ast.HashLiteral:
+		for k, v := range node.Pairs {
+			referencedNames(k, names)
+			referencedNames(v, names)
+		}
+	case *ast.IndexExpression:
+		referencedNames(node.Left, names)
+		referencedNames(node.Index, names)
+	case *ast.SliceExpression:
+		referencedNames(node.Left, names)
+		if node.Start != nil {
+			referencedNames(node.Start, names)
+		}
+		if node.End != nil {
+			referencedNames(node.End, names)
+		}
+	}
+}
+
+// letStatements collects the let statements of node that define names in the scope node is in,
+// those in nested functions define locals of the functions and are left out
+func letStatements(node ast.Node, lets []*ast.LetStatement) []*ast.LetStatement {
+	switch node := node.(type) {
+	case *ast.BlockStatement:
+		for _, s := range node.Statements {
+			lets = letStatements(s, lets)
+		}
+	case *ast.ExpressionStatement:
+		lets = letStatements(node.Expression, lets)
+	case *ast.LetStatement:
+		lets = append(lets, node)
+		lets = letStatements(node.Value, lets)
+	case *ast.ReturnStatement:
+		lets = letStatements(node.ReturnValue, lets)
+	case *ast.PrefixExpression:
+		lets = letStatements(node.Right, lets)
+	case *ast.InfixExpression:
+		lets = letStatements(node.Left, lets)
+		lets = letStatements(node.Right, lets)
+	case *ast.IfExpression:
+		lets = letStatements(node.Condition, lets)
+		lets = letStatements(node.Consequence, lets)
+		if node.Alternative != nil {
+			lets = letStatements(node.Alternative, lets)
+		}
+	case *ast.CallExpression:
+		lets = letStatements(node.Function, lets)
+		for _, a := range node.Arguments {
+			lets = letStatements(a, lets)
+		}
+	case *ast.ArrayLiteral:
+		for _, el := range node.Elements {
+			lets = letStatements(el, lets)
+		}
+	case *ast.HashLiteral:
+		for _, k := range node.Keys {
+			lets = letStatements(k, lets)
+			lets = letStatements(node.Pairs[k], lets)
+		}
+	case *ast.IndexExpression:
+		lets = letStatements(node.Left, lets)
+		lets = letStatements(node.Index, lets)
+	case *ast.SliceExpression:
+		lets = letStatements(node.Left, lets)
+		if node.Start != nil {
+			lets = letStatements(node.Start, lets)
+		}
+		if node.End != nil {
+			lets = letStatements(node.End, lets)
+		}
+	}
+	return lets
+}
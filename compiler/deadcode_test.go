@@ -0,0 +1,115 @@
+package compiler
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestEliminateDeadCode(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{
+			input: "fn() { return 1; 2; 3 }",
+			expected: `0000 OpClosure 1 0
+0004 OpPop
+fn 1:
+0000 OpConstant 0
+0003 OpReturnValue
+`,
+		},
+		{
+			input: "fn(x) { if (x) { return 1 } else { return 2 }; x + 3 }",
+			expected: `0000 OpClosure 2 0
+0004 OpPop
+fn 2:
+0000 OpGetLocal 0
+0002 OpJumpNotTruthy 12
+0005 OpConstant 0
+0008 OpReturnValue
+0009 OpJump 16
+0012 OpConstant 1
+0015 OpReturnValue
+0016 OpReturnValue
+`,
+		},
+		{
+			// unused pure bindings go, a call could have side effects and stays
+			input: "fn(x) { let a = 1; let b = [true, !false]; let c = x(); let d = 2; d }",
+			expected: `0000 OpClosure 1 0
+0004 OpPop
+fn 1:
+0000 OpGetLocal 0
+0002 OpCall 0
+0004 OpSetLocal 1
+0006 OpConstant 0
+0009 OpSetLocal 2
+0011 OpGetLocal 2
+0013 OpReturnValue
+`,
+		},
+		{
+			// a nested function reading the binding keeps it
+			input: "fn() { let a = 1; fn() { a } }",
+			expected: `0000 OpClosure 2 0
+0004 OpPop
+fn 1:
+0000 OpGetFree 0
+0002 OpReturnValue
+fn 2:
+0000 OpConstant 0
+0003 OpSetLocal 0
+0005 OpGetLocal 0
+0007 OpClosure 1 1
+0011 OpReturnValue
+`,
+		},
+		{
+			// globals can be read by later programs of the same state
+			input: "let a = 1;",
+			expected: `0000 OpConstant 0
+0003 OpSetGlobal 0
+`,
+		},
+		{
+			input: "if (false) { 1 } else { 2 }",
+			expected: `0000 OpConstant 0
+0003 OpPop
+`,
+		},
+	}
+	for _, tt := range tests {
+		got := disassemble(compileWithOptimizations(t, tt.input, EliminateDeadCode))
+		if got != tt.expected {
+			t.Errorf("%s: wrong instructions.\nwant=\n%s\ngot=\n%s", tt.input, tt.expected, got)
+		}
+	}
+}
+
+func TestUnreachableCodeWarnings(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected []string
+	}{
+		{"fn() { 1 }", []string{}},
+		{"fn() { return 1; 2; return 3; 4 }", []string{"unreachable code after return: 2"}},
+		{
+			"fn(x) { if (x) { return 1; x } else { return 2 }; x }",
+			[]string{"unreachable code after return: x", "unreachable code after return: x"},
+		},
+	}
+	for _, optimizations := range []Optimizations{0, EliminateDeadCode} {
+		for _, tt := range tests {
+			compiler := NewCompiler()
+			compiler.SetOptimizations(optimizations)
+			err := compiler.Compile(parse(tt.input))
+			if err != nil {
+				t.Fatalf("compiler error: %s", err)
+			}
+			if !reflect.DeepEqual(compiler.Warnings(), tt.expected) {
+				t.Errorf("%s: wrong warnings. want=%q, got=%q", tt.input, tt.expected, compiler.Warnings())
+			}
+		}
+	}
+}
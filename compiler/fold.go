@@ -0,0 +1,206 @@
+package compiler
+
+import (
+	"jonathan/ast"
+	"jonathan/token"
+	"strconv"
+)
+
+// fold returns expression with its constant parts evaluated, or expression itself if nothing is constant.
+// It only folds what the vm computes the same way for every run, everything that could fail at runtime
+// (division by zero, operators a type doesn't support) is left for the vm to report.
+// symbols tells which names are bound to integers.
+func fold(expression ast.Expression, symbols *SymbolTable) ast.Expression {
+	switch node := expression.(type) {
+	case *ast.PrefixExpression:
+		right := fold(node.Right, symbols)
+		if folded := foldPrefix(node.Operator, right); folded != nil {
+			return folded
+		}
+		if right != node.Right {
+			return &ast.PrefixExpression{Token: node.Token, Operator: node.Operator, Right: right}
+		}
+	case *ast.InfixExpression:
+		left := fold(node.Left, symbols)
+		right := fold(node.Right, symbols)
+		if folded := foldInfix(node.Operator, left, right); folded != nil {
+			return folded
+		}
+		if simplified := reassociate(node, left, right, symbols); simplified != nil {
+			return simplified
+		}
+		if left != node.Left || right != node.Right {
+			return &ast.InfixExpression{Token: node.Token, Left: left, Operator: node.Operator, Right: right}
+		}
+	}
+	return expression
+}
+
+func foldPrefix(operator string, right ast.Expression) ast.Expression {
+	switch operator {
+	case "!":
+		// mirrors executeBangOperator: only false and null are falsy
+		switch right := right.(type) {
+		case *ast.Boolean:
+			return newBoolean(!right.Value)
+		case *ast.IntegerLiteral, *ast.FloatLiteral, *ast.StringLiteral:
+			return newBoolean(false)
+		}
+	case "-":
+		if right, ok := right.(*ast.IntegerLiteral); ok {
+			return newInteger(-right.Value)
+		}
+	}
+	return nil
+}
+
+func foldInfix(operator string, left, right ast.Expression) ast.Expression {
+	switch left := left.(type) {
+	case *ast.IntegerLiteral:
+		right, ok := right.(*ast.IntegerLiteral)
+		if !ok {
+			return nil
+		}
+		switch operator {
+		case "+":
+			return newInteger(left.Value + right.Value)
+		case "-":
+			return newInteger(left.Value - right.Value)
+		case "*":
+			return newInteger(left.Value * right.Value)
+		case "/":
+			if right.Value == 0 {
+				return nil
+			}
+			return newInteger(left.Value / right.Value)
+		case "<":
+			return newBoolean(left.Value < right.Value)
+		case ">":
+			return newBoolean(left.Value > right.Value)
+		case "==":
+			return newBoolean(left.Value == right.Value)
+		case "!=":
+			return newBoolean(left.Value != right.Value)
+		}
+	case *ast.StringLiteral:
+		right, ok := right.(*ast.StringLiteral)
+		if !ok {
+			return nil
+		}
+		switch operator {
+		case "+":
+			return &ast.StringLiteral{Token: token.Token{Type: token.STRING, Literal: left.Value + right.Value}, Value: left.Value + right.Value}
+		case "<":
+			return newBoolean(left.Value < right.Value)
+		case ">":
+			return newBoolean(left.Value > right.Value)
+		case "==":
+			return newBoolean(left.Value == right.Value)
+		case "!=":
+			return newBoolean(left.Value != right.Value)
+		}
+	case *ast.Boolean:
+		right, ok := right.(*ast.Boolean)
+		if !ok {
+			return nil
+		}
+		switch operator {
+		case "==":
+			return newBoolean(left.Value == right.Value)
+		case "!=":
+			return newBoolean(left.Value != right.Value)
+		}
+	}
+	return nil
+}
+
+// reassociate merges the constants of (x + a) + b, (x - a) - b and similar chains into x + c and (x * a) * b into x * c.
+// Integer arithmetic wraps, so the result is the same for every integer x. Float arithmetic rounds and doesn't wrap,
+// so x must be an integer for sure.
+func reassociate(node *ast.InfixExpression, left, right ast.Expression, symbols *SymbolTable) ast.Expression {
+	b, ok := right.(*ast.IntegerLiteral)
+	if !ok {
+		return nil
+	}
+	inner, ok := left.(*ast.InfixExpression)
+	if !ok {
+		return nil
+	}
+	a, ok := inner.Right.(*ast.IntegerLiteral)
+	if !ok || !isInteger(inner.Left, symbols) {
+		return nil
+	}
+	var operator string
+	var value int64
+	switch {
+	case isAdditive(inner.Operator) && isAdditive(node.Operator):
+		value = signed(inner.Operator, a.Value) + signed(node.Operator, b.Value)
+		operator = "+"
+		if value < 0 && value != -value { // keep x - 1 instead of x + -1, except for the one value that can't be negated
+			operator = "-"
+			value = -value
+		}
+	case inner.Operator == "*" && node.Operator == "*":
+		value = a.Value * b.Value
+		operator = "*"
+	default:
+		return nil
+	}
+	return &ast.InfixExpression{
+		Token:    token.Token{Type: token.Type(operator), Literal: operator},
+		Left:     inner.Left,
+		Operator: operator,
+		Right:    newInteger(value),
+	}
+}
+
+// isInteger reports whether expression evaluates to an integer on every run, if it evaluates at all
+func isInteger(expression ast.Expression, symbols *SymbolTable) bool {
+	switch node := expression.(type) {
+	case *ast.IntegerLiteral:
+		return true
+	case *ast.Identifier:
+		return symbols.isInteger(node.Value)
+	case *ast.PrefixExpression:
+		return node.Operator == "-" && isInteger(node.Right, symbols)
+	case *ast.InfixExpression:
+		switch node.Operator {
+		case "+", "-", "*", "/":
+			return isInteger(node.Left, symbols) && isInteger(node.Right, symbols)
+		}
+	}
+	return false
+}
+
+func isAdditive(operator string) bool {
+	return operator == "+" || operator == "-"
+}
+
+func signed(operator string, value int64) int64 {
+	if operator == "-" {
+		return -value
+	}
+	return value
+}
+
+func newInteger(value int64) *ast.IntegerLiteral {
+	return &ast.IntegerLiteral{Token: token.Token{Type: token.INT, Literal: strconv.FormatInt(value, 10)}, Value: value}
+}
+
+func newBoolean(value bool) *ast.Boolean {
+	if value {
+		return &ast.Boolean{Token: token.Token{Type: token.TRUE, Literal: "true"}, Value: true}
+	}
+	return &ast.Boolean{Token: token.Token{Type: token.FALSE, Literal: "false"}, Value: false}
+}
+
+// constantTruthiness reports whether a folded condition is always truthy, always falsy or not constant.
+func constantTruthiness(condition ast.Expression) (truthy bool, constant bool) {
+	switch condition := condition.(type) {
+	case *ast.Boolean:
+		return condition.Value, true
+	case *ast.IntegerLiteral, *ast.FloatLiteral, *ast.StringLiteral:
+		return true, true
+	}
+	return false, false
+}
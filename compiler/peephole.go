@@ -0,0 +1,176 @@
+package compiler
+
+import (
+	"jonathan/code"
+	"sort"
+)
+
+// peepholeInstruction is a decoded instruction. The operands of jumps keep pointing at
+// positions in the instructions the pass started with until they are encoded again.
+type peepholeInstruction struct {
+	op       code.Opcode
+	operands []int
+	position int  // position in the unoptimised instructions
+	wide     bool // a jump keeps its width, the others are encoded as narrow as their operands allow
+}
+
+// peephole rewrites wasteful instruction sequences of one compiled scope and relocates the jumps.
+// A sequence is only rewritten if no jump lands inside of it.
+func peephole(ins code.Instructions) code.Instructions {
+	decoded, err := decodeInstructions(ins)
+	if err != nil {
+		return ins
+	}
+	changed := true
+	for changed {
+		decoded, changed = peepholeRound(decoded)
+	}
+	return encodeInstructions(decoded)
+}
+
+func peepholeRound(list []peepholeInstruction) ([]peepholeInstruction, bool) {
+	targets := jumpTargets(list)
+	optimized := make([]peepholeInstruction, 0, len(list))
+	changed := false
+	// matches reports whether the instructions from i on are ops and no jump lands after the first of them
+	matches := func(i int, ops ...code.Opcode) bool {
+		if i+len(ops) > len(list) {
+			return false
+		}
+		for j, op := range ops {
+			if list[i+j].op != op || (j > 0 && targets[i+j]) {
+				return false
+			}
+		}
+		return true
+	}
+	for i := 0; i < len(list); i++ {
+		current := list[i]
+		switch {
+		case current.op == code.OpJump && resolveJump(list, current.operands[0]) == i+1:
+			// a jump to the next instruction does nothing
+		case matches(i, code.OpTrue, code.OpJumpNotTruthy):
+			// the condition always holds
+			i++
+		case matches(i, code.OpFalse, code.OpJumpNotTruthy):
+			// the condition never holds
+			optimized = append(optimized, peepholeInstruction{
+				op:       code.OpJump,
+				operands: list[i+1].operands,
+				position: current.position,
+				wide:     list[i+1].wide,
+			})
+			i++
+		case matches(i, code.OpSetLocal, code.OpGetLocal, code.OpReturnValue) &&
+			current.operands[0] == list[i+1].operands[0]:
+			// the local is gone with the frame, return the value right away
+			optimized = append(optimized, list[i+2])
+			i += 2
+		case (matches(i, code.OpSetLocal, code.OpGetLocal) || matches(i, code.OpSetGlobal, code.OpGetGlobal)) &&
+			current.operands[0] == list[i+1].operands[0]:
+			// keep a copy of the value on the stack instead of loading it again
+			optimized = append(optimized,
+				peepholeInstruction{op: code.OpDup, position: current.position},
+				peepholeInstruction{op: current.op, operands: current.operands, position: list[i+1].position})
+			i++
+		default:
+			optimized = append(optimized, current)
+			continue
+		}
+		changed = true
+	}
+	return optimized, changed
+}
+
+func isJump(op code.Opcode) bool {
+	switch op {
+	case code.OpJump, code.OpJumpNotTruthy, code.OpJumpIfNotGreater, code.OpJumpIfNotEqual:
+		return true
+	}
+	return false
+}
+
+// resolveJump returns the index of the instruction a jump to position lands on.
+// A jump to a removed instruction lands on the next remaining one, len(list) is the end of the instructions.
+func resolveJump(list []peepholeInstruction, position int) int {
+	return sort.Search(len(list), func(i int) bool { return list[i].position >= position })
+}
+
+func jumpTargets(list []peepholeInstruction) map[int]bool {
+	targets := map[int]bool{}
+	for _, ins := range list {
+		if isJump(ins.op) {
+			targets[resolveJump(list, ins.operands[0])] = true
+		}
+	}
+	return targets
+}
+
+func decodeInstructions(ins code.Instructions) ([]peepholeInstruction, error) {
+	var list []peepholeInstruction
+	for i := 0; i < len(ins); {
+		position, wide := i, false
+		if code.Opcode(ins[i]) == code.OpWide {
+			wide = true
+			i++
+		}
+		def, err := code.Lookup(ins[i])
+		if err != nil {
+			return nil, err
+		}
+		if wide {
+			def = code.Widen(def)
+		}
+		operands, read := code.ReadOperands(def, ins[i+1:])
+		list = append(list, peepholeInstruction{op: code.Opcode(ins[i]), operands: operands, position: position, wide: wide})
+		i += 1 + read
+	}
+	return list, nil
+}
+
+func encodeInstructions(list []peepholeInstruction) code.Instructions {
+	targets := map[int]int{} // the index of the instruction every jump lands on
+	for i, ins := range list {
+		if isJump(ins.op) {
+			targets[i] = resolveJump(list, ins.operands[0])
+		}
+	}
+	positions := make([]int, len(list)+1) // the new position of every instruction and the end
+	for grown := true; grown; {
+		for i, ins := range list {
+			operands := ins.operands
+			if _, ok := targets[i]; ok {
+				operands = []int{0} // a jump is as wide as it is marked, whatever its target
+			}
+			positions[i+1] = positions[i] + len(encodeInstruction(ins, operands))
+		}
+		// a narrow jump whose target moved beyond what it can hold is widened, which moves the code after it
+		grown = false
+		for i, target := range targets {
+			if !list[i].wide && !code.Fits(list[i].op, positions[target]) {
+				list[i].wide = true
+				grown = true
+			}
+		}
+	}
+	out := make(code.Instructions, 0, positions[len(list)])
+	for i, ins := range list {
+		operands := ins.operands
+		if target, ok := targets[i]; ok {
+			operands = []int{positions[target]}
+		}
+		out = append(out, encodeInstruction(ins, operands)...)
+	}
+	return out
+}
+
+func encodeInstruction(ins peepholeInstruction, operands []int) []byte {
+	if isJump(ins.op) && ins.wide {
+		return code.MakeWide(ins.op, operands...)
+	}
+	encoded, err := code.Encode(ins.op, operands...)
+	if err != nil { // the operands were decoded from an instruction, they fit
+		return code.MakeWide(ins.op, operands...)
+	}
+	return encoded
+}
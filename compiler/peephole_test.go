@@ -0,0 +1,249 @@
+package compiler
+
+import (
+	"fmt"
+	"jonathan/code"
+	"jonathan/object"
+	"strings"
+	"testing"
+)
+
+// disassemble prints the main instructions and those of every compiled function
+func disassemble(bytecode *Bytecode) string {
+	var out strings.Builder
+	out.WriteString(bytecode.Instructions.String())
+	for i, constant := range bytecode.Constants {
+		if fn, ok := constant.(*object.CompiledFunction); ok {
+			fmt.Fprintf(&out, "fn %d:\n%s", i, fn.Instructions)
+		}
+	}
+	return out.String()
+}
+
+func compileWithOptimizations(t *testing.T, input string, optimizations Optimizations) *Bytecode {
+	t.Helper()
+	compiler := NewCompiler()
+	compiler.SetOptimizations(optimizations)
+	err := compiler.Compile(parse(input))
+	if err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	return compiler.Bytecode()
+}
+
+func TestPeephole(t *testing.T) {
+	tests := []struct {
+		input  string
+		before string
+		after  string
+	}{
+		{
+			input: "if (true) { 10 }; 3333;",
+			before: `0000 OpTrue
+0001 OpJumpNotTruthy 10
+0004 OpConstant 0
+0007 OpJump 11
+0010 OpNull
+0011 OpPop
+0012 OpConstant 1
+0015 OpPop
+`,
+			after: `0000 OpConstant 0
+0003 OpJump 7
+0006 OpNull
+0007 OpPop
+0008 OpConstant 1
+0011 OpPop
+`,
+		},
+		{
+			input: "if (false) { 10 } else { 20 }",
+			before: `0000 OpFalse
+0001 OpJumpNotTruthy 10
+0004 OpConstant 0
+0007 OpJump 13
+0010 OpConstant 1
+0013 OpPop
+`,
+			after: `0000 OpJump 9
+0003 OpConstant 0
+0006 OpJump 12
+0009 OpConstant 1
+0012 OpPop
+`,
+		},
+		{
+			input: "if (1 > 2) { 10 } else { }; 5",
+			before: `0000 OpConstant 0
+0003 OpConstant 1
+0006 OpGreaterThan
+0007 OpJumpNotTruthy 16
+0010 OpConstant 2
+0013 OpJump 16
+0016 OpPop
+0017 OpConstant 3
+0020 OpPop
+`,
+			after: `0000 OpConstant 0
+0003 OpConstant 1
+0006 OpGreaterThan
+0007 OpJumpNotTruthy 13
+0010 OpConstant 2
+0013 OpPop
+0014 OpConstant 3
+0017 OpPop
+`,
+		},
+		{
+			input: "let a = 1; a",
+			before: `0000 OpConstant 0
+0003 OpSetGlobal 0
+0006 OpGetGlobal 0
+0009 OpPop
+`,
+			after: `0000 OpConstant 0
+0003 OpDup
+0004 OpSetGlobal 0
+0007 OpPop
+`,
+		},
+		{
+			input: "fn(x) { let a = x * 2; a }",
+			before: `0000 OpClosure 1 0
+0004 OpPop
+fn 1:
+0000 OpGetLocal 0
+0002 OpConstant 0
+0005 OpMul
+0006 OpSetLocal 1
+0008 OpGetLocal 1
+0010 OpReturnValue
+`,
+			after: `0000 OpClosure 1 0
+0004 OpPop
+fn 1:
+0000 OpGetLocal 0
+0002 OpConstant 0
+0005 OpMul
+0006 OpReturnValue
+`,
+		},
+		{
+			input: "fn(x) { let a = x; let b = a; if (b) { b } else { a } }",
+			before: `0000 OpClosure 0 0
+0004 OpPop
+fn 0:
+0000 OpGetLocal 0
+0002 OpSetLocal 1
+0004 OpGetLocal 1
+0006 OpSetLocal 2
+0008 OpGetLocal 2
+0010 OpJumpNotTruthy 18
+0013 OpGetLocal 2
+0015 OpJump 20
+0018 OpGetLocal 1
+0020 OpReturnValue
+`,
+			after: `0000 OpClosure 0 0
+0004 OpPop
+fn 0:
+0000 OpGetLocal 0
+0002 OpDup
+0003 OpSetLocal 1
+0005 OpDup
+0006 OpSetLocal 2
+0008 OpJumpNotTruthy 16
+0011 OpGetLocal 2
+0013 OpJump 18
+0016 OpGetLocal 1
+0018 OpReturnValue
+`,
+		},
+	}
+	for _, tt := range tests {
+		before := disassemble(compileWithOptimizations(t, tt.input, 0))
+		if before != tt.before {
+			t.Errorf("%s: wrong instructions before the pass.\nwant=\n%s\ngot=\n%s", tt.input, tt.before, before)
+		}
+		after := disassemble(compileWithOptimizations(t, tt.input, Peephole))
+		if after != tt.after {
+			t.Errorf("%s: wrong instructions after the pass.\nwant=\n%s\ngot=\n%s", tt.input, tt.after, after)
+		}
+	}
+}
+
+func TestPeepholeKeepsJumpTargets(t *testing.T) {
+	// the OpGetLocal is where the alternative starts, so it has to stay
+	ins := concatInstructions([]code.Instructions{
+		code.Make(code.OpGetLocal, 0),
+		code.Make(code.OpJumpNotTruthy, 10),
+		code.Make(code.OpConstant, 0),
+		code.Make(code.OpSetLocal, 1),
+		code.Make(code.OpGetLocal, 1),
+		code.Make(code.OpReturnValue),
+	})
+	optimized := peephole(ins)
+	if optimized.String() != ins.String() {
+		t.Errorf("jump target rewritten.\nwant=\n%s\ngot=\n%s", ins, optimized)
+	}
+}
+
+func TestPeepholeLargeFunction(t *testing.T) {
+	// fn(n) { if (false) { if (true) { n }; ... } else { n } } with a body over 64 KB, so the jump over it
+	// is wide until the passes shrink the body to less than half
+	compiler := NewCompiler()
+	compiler.SetOptimizations(AllOptimizations)
+	compiler.enterScope()
+	compiler.emit(code.OpFalse)
+	alternative := compiler.emitJump(code.OpJumpNotTruthy)
+	for i := 0; i < 5000; i++ {
+		compiler.emit(code.OpTrue)
+		consequence := compiler.emitJump(code.OpJumpNotTruthy)
+		compiler.emit(code.OpGetLocal, 0)
+		end := compiler.emitJump(code.OpJump)
+		compiler.patchJump(consequence)
+		compiler.emit(code.OpNull)
+		compiler.patchJump(end)
+		compiler.emit(code.OpPop)
+	}
+	end := compiler.emitJump(code.OpJump)
+	if before := len(compiler.currentInstructions()); before <= 65535 {
+		t.Fatalf("body too small to need wide jumps: %d bytes", before)
+	}
+	compiler.patchJump(alternative)
+	compiler.emit(code.OpGetLocal, 0)
+	compiler.patchJump(end)
+	compiler.emit(code.OpReturnValue)
+	optimized := compiler.leaveScope()
+	if compiler.err != nil {
+		t.Fatalf("compiler error: %s", compiler.err)
+	}
+
+	list, err := decodeInstructions(optimized)
+	if err != nil {
+		t.Fatalf("optimised instructions don't decode: %s", err)
+	}
+	indexes := map[int]int{}
+	for i, ins := range list {
+		indexes[ins.position] = i
+	}
+	for _, ins := range list {
+		if !isJump(ins.op) {
+			continue
+		}
+		target, ok := indexes[ins.operands[0]]
+		if !ok {
+			t.Fatalf("jump at %d lands at %d, inside of an instruction", ins.position, ins.operands[0])
+		}
+		if ins.op == code.OpJump && list[target].op != code.OpPop && target < len(list)-2 {
+			t.Fatalf("jump at %d lands on opcode %d at %d", ins.position, list[target].op, ins.operands[0])
+		}
+	}
+	first, last := list[0], list[len(list)-1]
+	if first.op != code.OpJump || indexes[first.operands[0]] != len(list)-2 {
+		t.Errorf("the jump over the body lands at %d, want %d", first.operands[0], list[len(list)-2].position)
+	}
+	if last.op != code.OpReturnValue {
+		t.Errorf("wrong last opcode %d", last.op)
+	}
+}
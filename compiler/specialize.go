@@ -0,0 +1,39 @@
+package compiler
+
+import "jonathan/code"
+
+// specialize replaces common instruction sequences of one compiled scope with the specialised opcodes
+// that do the same in one instruction. Like the peephole pass it only fuses instructions no jump lands between.
+func specialize(ins code.Instructions) code.Instructions {
+	list, err := decodeInstructions(ins)
+	if err != nil {
+		return ins
+	}
+	targets := jumpTargets(list)
+	// followedBy reports whether the instruction after i is op and no jump lands on it
+	followedBy := func(i int, op code.Opcode) bool {
+		return i+1 < len(list) && list[i+1].op == op && !targets[i+1]
+	}
+	specialized := make([]peepholeInstruction, 0, len(list))
+	for i := 0; i < len(list); i++ {
+		current := list[i]
+		switch {
+		case current.op == code.OpGetLocal && current.operands[0] < 4:
+			current = peepholeInstruction{op: code.OpGetLocal0 + code.Opcode(current.operands[0]), position: current.position}
+		case current.op == code.OpConstant && followedBy(i, code.OpAdd):
+			current = peepholeInstruction{op: code.OpAddConst, operands: current.operands, position: current.position}
+			i++
+		case current.op == code.OpConstant && followedBy(i, code.OpSub):
+			current = peepholeInstruction{op: code.OpSubConst, operands: current.operands, position: current.position}
+			i++
+		case current.op == code.OpGreaterThan && followedBy(i, code.OpJumpNotTruthy):
+			current = peepholeInstruction{op: code.OpJumpIfNotGreater, operands: list[i+1].operands, position: current.position, wide: list[i+1].wide}
+			i++
+		case current.op == code.OpEqual && followedBy(i, code.OpJumpNotTruthy):
+			current = peepholeInstruction{op: code.OpJumpIfNotEqual, operands: list[i+1].operands, position: current.position, wide: list[i+1].wide}
+			i++
+		}
+		specialized = append(specialized, current)
+	}
+	return encodeInstructions(specialized)
+}
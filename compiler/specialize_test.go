@@ -0,0 +1,65 @@
+package compiler
+
+import (
+	"jonathan/code"
+	"testing"
+)
+
+func TestSpecialize(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{
+			input: "fn(x) { if (x < 2) { x } else { x - 1 } }",
+			expected: `0000 OpClosure 2 0
+0004 OpPop
+fn 2:
+0000 OpConstant 0
+0003 OpGetLocal0
+0004 OpJumpIfNotGreater 11
+0007 OpGetLocal0
+0008 OpJump 15
+0011 OpGetLocal0
+0012 OpSubConst 1
+0015 OpReturnValue
+`,
+		},
+		{
+			input: "fn(a, b, c, d, e) { if (e == d) { c + 1 } else { b } }",
+			expected: `0000 OpClosure 1 0
+0004 OpPop
+fn 1:
+0000 OpGetLocal 4
+0002 OpGetLocal3
+0003 OpJumpIfNotEqual 13
+0006 OpGetLocal2
+0007 OpAddConst 0
+0010 OpJump 14
+0013 OpGetLocal1
+0014 OpReturnValue
+`,
+		},
+	}
+	for _, tt := range tests {
+		specialized := disassemble(compileWithOptimizations(t, tt.input, Specialize))
+		if specialized != tt.expected {
+			t.Errorf("%s: wrong instructions.\nwant=\n%s\ngot=\n%s", tt.input, tt.expected, specialized)
+		}
+	}
+}
+
+func TestSpecializeKeepsJumpTargets(t *testing.T) {
+	// the OpAdd is where the jump lands, so it can't be fused with the OpConstant before it
+	ins := concatInstructions([]code.Instructions{
+		code.Make(code.OpGetLocal, 4),
+		code.Make(code.OpJumpNotTruthy, 8),
+		code.Make(code.OpConstant, 0),
+		code.Make(code.OpAdd),
+		code.Make(code.OpReturnValue),
+	})
+	specialized := specialize(ins)
+	if specialized.String() != ins.String() {
+		t.Errorf("jump target fused.\nwant=\n%s\ngot=\n%s", ins, specialized)
+	}
+}
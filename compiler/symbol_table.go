@@ -15,6 +15,9 @@ type Symbol struct {
 	Name  string      // symbol name
 	Scope SymbolScope // symbol scope
 	Index int         // symbol index
+	// Integer is set once the let of the symbol is known to bind an integer, which is never rebound
+	// since every let defines a symbol of its own
+	Integer bool
 }
 type SymbolTable struct {
 	Outer          *SymbolTable // the out scope
@@ -74,13 +77,29 @@ func NewEnclosedSymbolTable(outer *SymbolTable) *SymbolTable {
 func (s *SymbolTable) defineFree(original Symbol) Symbol {
 	s.FreeSymbols = append(s.FreeSymbols, original)
 
-	symbol := Symbol{Name: original.Name, Index: len(s.FreeSymbols) - 1}
+	symbol := Symbol{Name: original.Name, Index: len(s.FreeSymbols) - 1, Integer: original.Integer}
 	symbol.Scope = FreeScope
 
 	s.store[original.Name] = symbol
 	return symbol
 }
 
+// markInteger records that symbol, defined in s, is bound to an integer
+func (s *SymbolTable) markInteger(symbol Symbol) {
+	symbol.Integer = true
+	s.store[symbol.Name] = symbol
+}
+
+// isInteger reports whether name is bound to an integer, without capturing it like Resolve
+func (s *SymbolTable) isInteger(name string) bool {
+	for table := s; table != nil; table = table.Outer {
+		if symbol, ok := table.store[name]; ok {
+			return symbol.Integer
+		}
+	}
+	return false
+}
+
 func (s *SymbolTable) DefineFunctionName(name string) Symbol {
 	symbol := Symbol{Name: name, Index: 0, Scope: FunctionScope}
 	s.store[name] = symbol
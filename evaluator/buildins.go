@@ -4,11 +4,5 @@ import (
 	"jonathan/object"
 )
 
-var builtins = map[string]*object.Builtin{
-	"len":   object.GetBuiltinByName("len"),
-	"first": object.GetBuiltinByName("first"),
-	"last":  object.GetBuiltinByName("last"),
-	"rest":  object.GetBuiltinByName("rest"),
-	"push":  object.GetBuiltinByName("push"),
-	"puts":  object.GetBuiltinByName("puts"),
-}
+// builtins is the registry of the evaluators created by Eval
+var builtins = object.NewBuiltinRegistry()
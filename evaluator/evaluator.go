@@ -1,15 +1,17 @@
 package evaluator
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"jonathan/ast"
 	"jonathan/object"
 )
 
 var (
-	NULL  = &object.Null{}
-	TRUE  = &object.Boolean{Value: true}
-	FALSE = &object.Boolean{Value: false}
+	NULL  = object.NULL
+	TRUE  = object.TRUE
+	FALSE = object.FALSE
 )
 
 func nativeBoolToBooleanObject(input bool) *object.Boolean {
@@ -18,49 +20,150 @@ func nativeBoolToBooleanObject(input bool) *object.Boolean {
 	}
 	return FALSE
 }
+
+// MaxCallDepth is the default limit of nested calls of script functions, Limits.MaxCallDepth replaces it.
+// It is the calls the vm has frames for by default, deeper recursion would exhaust the Go stack.
+const MaxCallDepth = 1<<16 - 1
+
+// Evaluator walks the AST. Builtins it calls receive it as their object.CallContext.
+type Evaluator struct {
+	builtins  *object.BuiltinRegistry // resolves the identifiers the environment doesn't know
+	ctx       context.Context         // cancels the current evaluation
+	limits    object.Limits
+	evaluated int64 // nodes evaluated in the current run
+	depth     int   // nesting of the function calls being evaluated
+	allocated int64 // bytes accounted in the current run
+	random    object.RandomSource
+	err       error // the limit that stopped the evaluation, it stays set so nothing evaluates after it
+}
+
+func NewEvaluator() *Evaluator {
+	return NewEvaluatorWithBuiltins(object.NewBuiltinRegistry())
+}
+
+// NewEvaluatorWithBuiltins evaluates with the given registry instead of the default builtins.
+func NewEvaluatorWithBuiltins(builtins *object.BuiltinRegistry) *Evaluator {
+	return &Evaluator{builtins: builtins, ctx: context.Background()}
+}
+
+// SetLimits bounds the evaluated nodes, call depth and allocations of the following evaluations.
+func (e *Evaluator) SetLimits(limits object.Limits) {
+	e.limits = limits
+}
+
+// EvalWithContext evaluates node until it is done or ctx is done. It returns an error wrapping
+// object.ErrTimeout when the deadline of ctx passes and object.ErrBudgetExceeded when a limit is hit.
+// Errors of the script itself are returned as *object.Error results like Eval does.
+func (e *Evaluator) EvalWithContext(ctx context.Context, node ast.Node, env *object.Environment) (object.Object, error) {
+	outerCtx := e.ctx
+	e.ctx = ctx
+	e.evaluated = 0
+	e.allocated = 0
+	e.random.Reset()
+
+	e.err = nil
+	defer func() { e.ctx = outerCtx }()
+	result := e.Eval(node, env)
+	if e.err != nil {
+		return nil, e.err
+	}
+	return result, nil
+}
+
+// Eval evaluates node with the default builtins.
 func Eval(node ast.Node, env *object.Environment) object.Object {
+	return NewEvaluatorWithBuiltins(builtins).Eval(node, env)
+}
+
+// Call applies a function or builtin to args. An error object the call results in is returned as error,
+// a limit the call hits is returned as the error EvalWithContext would return for it.
+func (e *Evaluator) Call(fn object.Object, args ...object.Object) (object.Object, error) {
+	result := e.applyFunction(fn, args)
+	if e.err != nil {
+		return nil, e.err
+	}
+	if errObj, ok := result.(*object.Error); ok {
+		return nil, errors.New(errObj.Message)
+	}
+	return result, nil
+}
+
+// TryCall is Call for the try builtin. If the call exceeds the memory limit, the evaluator drops what the call
+// allocated from its account and goes on, the error is only returned.
+func (e *Evaluator) TryCall(fn object.Object, args ...object.Object) (object.Object, error) {
+	allocated := e.allocated
+	result, err := e.Call(fn, args...)
+	if errors.Is(err, object.ErrMemoryLimitExceeded) {
+		e.allocated = allocated
+		e.err = nil
+	}
+	return result, err
+}
+
+// Allocate accounts size bytes against Limits.MaxAllocBytes. Exceeding the limit stops the evaluation.
+func (e *Evaluator) Allocate(size int64) error {
+	e.allocated += size
+	if e.limits.MaxAllocBytes > 0 && e.allocated > e.limits.MaxAllocBytes && e.err == nil {
+		e.err = object.AllocationError(e.limits.MaxAllocBytes)
+	}
+	return e.err
+}
+
+// Random returns the random numbers of the current run.
+func (e *Evaluator) Random() *object.RandomSource {
+	return &e.random
+}
+
+// Eval evaluates node. It has no error result, so a limit that stops the evaluation is returned as an error object,
+// use EvalWithContext to tell limits from errors of the script.
+func (e *Evaluator) Eval(node ast.Node, env *object.Environment) object.Object {
+	if err := e.countNode(); err != nil {
+		return newError("%s", err)
+	}
 	switch node := node.(type) {
 	// Statements
 	case *ast.Program:
-		return evalProgram(node.Statements, env)
+		return e.evalProgram(node.Statements, env)
 	case *ast.ExpressionStatement:
-		return Eval(node.Expression, env)
+		return e.Eval(node.Expression, env)
 	case *ast.IntegerLiteral:
-		return &object.Integer{Value: node.Value}
+		return object.NewInteger(node.Value)
+	case *ast.FloatLiteral:
+		return &object.Float{Value: node.Value}
 	case *ast.StringLiteral:
 		return &object.String{Value: node.Value}
 	case *ast.Boolean:
 		return nativeBoolToBooleanObject(node.Value)
 	case *ast.PrefixExpression:
-		right := Eval(node.Right, env)
+		right := e.Eval(node.Right, env)
 		if isError(right) {
 			return right
 		}
 		return evalPrefixExpression(node.Operator, right)
 	case *ast.InfixExpression:
-		left := Eval(node.Left, env)
+		left := e.Eval(node.Left, env)
 		if isError(left) {
 			return left
 		}
-		right := Eval(node.Right, env)
+		right := e.Eval(node.Right, env)
 		if isError(right) {
 			return right
 		}
-		return evalInfixExpression(node.Operator, left, right)
+		return e.evalInfixExpression(node.Operator, left, right)
 	case *ast.BlockStatement:
-		return evalBlockStatement(node, env)
+		return e.evalBlockStatement(node, env)
 	case *ast.IfExpression:
-		return evalIfExpression(node, env)
+		return e.evalIfExpression(node, env)
 	case *ast.ReturnStatement:
-		val := Eval(node.ReturnValue, env)
+		val := e.Eval(node.ReturnValue, env)
 		if isError(val) {
 			return val
 		}
 		return &object.ReturnValue{Value: val}
 	case *ast.Identifier:
-		return evalIdentifier(node, env)
+		return e.evalIdentifier(node, env)
 	case *ast.LetStatement:
-		val := Eval(node.Value, env)
+		val := e.Eval(node.Value, env)
 		if isError(val) {
 			return val
 		}
@@ -70,34 +173,42 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 		body := node.Body
 		return &object.Function{Parameters: params, Env: env, Body: body}
 	case *ast.ArrayLiteral:
-		elements := evalExpressions(node.Elements, env)
+		elements := e.evalExpressions(node.Elements, env)
 		if len(elements) == 1 && isError(elements[0]) {
 			return elements[0]
 		}
+		if err := e.Allocate(object.ArraySize(len(elements))); err != nil {
+			return newError("%s", err)
+		}
 		return &object.Array{Elements: elements}
 	case *ast.IndexExpression:
-		left := Eval(node.Left, env)
+		left := e.Eval(node.Left, env)
 		if isError(left) {
 			return left
 		}
-		index := Eval(node.Index, env)
+		index := e.Eval(node.Index, env)
 		if isError(index) {
 			return index
 		}
 		return evalIndexExpression(left, index)
+	case *ast.SliceExpression:
+		return e.evalSliceExpression(node, env)
 
 	case *ast.HashLiteral:
-		return evalHashLiteral(node, env)
+		return e.evalHashLiteral(node, env)
 	case *ast.CallExpression:
-		function := Eval(node.Function, env)
+		function := e.Eval(node.Function, env)
 		if isError(function) {
 			return function
 		}
-		args := evalExpressions(node.Arguments, env)
+		args := e.evalExpressions(node.Arguments, env)
 		if len(args) == 1 && isError(args[0]) {
 			return args[0]
 		}
-		return applyFunction(function, args)
+		if node.Tail {
+			return &tailCall{fn: function, args: args}
+		}
+		return e.applyFunction(function, args)
 	}
 
 	//  another implement for ExpressionStatement
@@ -110,10 +221,10 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 	return nil
 }
 
-func evalProgram(stmts []ast.Statement, env *object.Environment) object.Object {
+func (e *Evaluator) evalProgram(stmts []ast.Statement, env *object.Environment) object.Object {
 	var result object.Object
 	for _, statement := range stmts {
-		result = Eval(statement, env)
+		result = e.Eval(statement, env)
 
 		// return wrap value  when return statement or Error stop evaluator
 		switch result := result.(type) {
@@ -151,26 +262,32 @@ func evalBangOperatorExpression(right object.Object) object.Object {
 }
 
 func evalMinusPrefixOperatorExpression(right object.Object) object.Object {
-	if right.Type() != object.IntegerObj {
+	switch right := right.(type) {
+	case *object.Integer:
+		return object.NewInteger(-right.Value)
+	case *object.Float:
+		return &object.Float{Value: -right.Value}
+	default:
 		return newError("unknown operator: -%s", right.Type())
 	}
-	value := right.(*object.Integer).Value
-	return &object.Integer{Value: -value}
 }
 
-func evalInfixExpression(operator string, left, right object.Object) object.Object {
+func (e *Evaluator) evalInfixExpression(operator string, left, right object.Object) object.Object {
+	if leftVal, rightVal, ok := object.FloatOperands(left, right); ok {
+		return evalFloatInfixExpression(operator, left, right, leftVal, rightVal)
+	}
 	switch {
 	case left.Type() == object.IntegerObj && right.Type() == object.IntegerObj:
 		return evalIntegerInfixExpression(operator, left, right)
-	case operator == "==": // compare the pointer  TODO
-		return nativeBoolToBooleanObject(left == right)
+	case operator == "==":
+		return nativeBoolToBooleanObject(object.Equal(left, right))
 	case operator == "!=":
-		return nativeBoolToBooleanObject(left != right)
+		return nativeBoolToBooleanObject(!object.Equal(left, right))
 	case left.Type() != right.Type():
 		return newError("type mismatch: %s %s %s",
 			left.Type(), operator, right.Type())
 	case left.Type() == object.StringObj && right.Type() == object.StringObj:
-		return evalStringInfixExpression(operator, left, right)
+		return e.evalStringInfixExpression(operator, left, right)
 
 	default:
 		return newError("unknown operator: %s %s %s", left.Type(), operator, right.Type())
@@ -184,13 +301,16 @@ func evalIntegerInfixExpression(operator string,
 	rightVal := right.(*object.Integer).Value
 	switch operator {
 	case "+":
-		return &object.Integer{Value: leftVal + rightVal}
+		return object.NewInteger(leftVal + rightVal)
 	case "-":
-		return &object.Integer{Value: leftVal - rightVal}
+		return object.NewInteger(leftVal - rightVal)
 	case "*":
-		return &object.Integer{Value: leftVal * rightVal}
+		return object.NewInteger(leftVal * rightVal)
 	case "/":
-		return &object.Integer{Value: leftVal / rightVal}
+		if rightVal == 0 {
+			return newError("division by zero")
+		}
+		return object.NewInteger(leftVal / rightVal)
 	case "<":
 		return nativeBoolToBooleanObject(leftVal < rightVal)
 	case ">":
@@ -205,48 +325,73 @@ func evalIntegerInfixExpression(operator string,
 	}
 }
 
-func evalStringInfixExpression(operator string,
-	left, right object.Object,
+// evalFloatInfixExpression computes an operation on two numbers of which one at least is a float
+func evalFloatInfixExpression(operator string,
+	left, right object.Object, leftVal, rightVal float64,
 ) object.Object {
-	if operator != "+" {
-		return newError("unknown operator: %s %s %s", left.Type(), operator, right.Type())
+	switch operator {
+	case "+":
+		return &object.Float{Value: leftVal + rightVal}
+	case "-":
+		return &object.Float{Value: leftVal - rightVal}
+	case "*":
+		return &object.Float{Value: leftVal * rightVal}
+	case "/":
+		if rightVal == 0 {
+			return newError("division by zero")
+		}
+		return &object.Float{Value: leftVal / rightVal}
+	case "<":
+		return nativeBoolToBooleanObject(leftVal < rightVal)
+	case ">":
+		return nativeBoolToBooleanObject(leftVal > rightVal)
+	case "==":
+		return nativeBoolToBooleanObject(leftVal == rightVal)
+	case "!=":
+		return nativeBoolToBooleanObject(leftVal != rightVal)
+	default:
+		return newError("unknown operator: %s %s %s",
+			left.Type(), operator, right.Type())
 	}
+}
+
+func (e *Evaluator) evalStringInfixExpression(operator string,
+	left, right object.Object,
+) object.Object {
 	leftVal := left.(*object.String).Value
 	rightVal := right.(*object.String).Value
-	return &object.String{Value: leftVal + rightVal}
+	switch operator {
+	case "<":
+		return nativeBoolToBooleanObject(leftVal < rightVal)
+	case ">":
+		return nativeBoolToBooleanObject(leftVal > rightVal)
+	case "+":
+		if err := e.Allocate(object.StringSize(len(leftVal) + len(rightVal))); err != nil {
+			return newError("%s", err)
+		}
+		return &object.String{Value: leftVal + rightVal}
+	}
+	return newError("unknown operator: %s %s %s", left.Type(), operator, right.Type())
 }
 
-func evalIfExpression(ie *ast.IfExpression, env *object.Environment) object.Object {
-	condition := Eval(ie.Condition, env)
+func (e *Evaluator) evalIfExpression(ie *ast.IfExpression, env *object.Environment) object.Object {
+	condition := e.Eval(ie.Condition, env)
 	if isError(condition) {
 		return condition
 	}
-	if isTruthy(condition) {
-		return Eval(ie.Consequence, env)
+	if object.IsTruthy(condition) {
+		return e.Eval(ie.Consequence, env)
 	} else if ie.Alternative != nil {
-		return Eval(ie.Alternative, env)
+		return e.Eval(ie.Alternative, env)
 	} else {
 		return NULL
 	}
 }
 
-func isTruthy(obj object.Object) bool {
-	switch obj {
-	case NULL:
-		return false
-	case TRUE:
-		return true
-	case FALSE:
-		return false
-	default:
-		return true
-	}
-}
-
-func evalBlockStatement(block *ast.BlockStatement, env *object.Environment) object.Object {
+func (e *Evaluator) evalBlockStatement(block *ast.BlockStatement, env *object.Environment) object.Object {
 	var result object.Object
 	for _, statement := range block.Statements {
-		result = Eval(statement, env)
+		result = e.Eval(statement, env)
 		// the nested return can be tracked in outer recursion call
 		if result != nil {
 			rt := result.Type() // when the type of result is return or Error ,stop evaluator
@@ -258,14 +403,17 @@ func evalBlockStatement(block *ast.BlockStatement, env *object.Environment) obje
 	return result
 }
 
-func evalIdentifier(node *ast.Identifier, env *object.Environment) object.Object {
+func (e *Evaluator) evalIdentifier(node *ast.Identifier, env *object.Environment) object.Object {
 	//get the value in the env link list
 	if val, ok := env.Get(node.Value); ok {
 		return val
 	}
-	if builtin, ok := builtins[node.Value]; ok {
+	if builtin, ok := e.builtins.Lookup(node.Value); ok {
 		return builtin
 	}
+	if constant, ok := e.builtins.Constant(node.Value); ok {
+		return constant
+	}
 	return newError("identifier not found: " + node.Value)
 }
 
@@ -280,12 +428,12 @@ func isError(obj object.Object) bool {
 	return false
 }
 
-func evalExpressions(
+func (e *Evaluator) evalExpressions(
 	exps []ast.Expression, env *object.Environment,
 ) []object.Object {
 	var result []object.Object
-	for _, e := range exps {
-		evaluated := Eval(e, env)
+	for _, exp := range exps {
+		evaluated := e.Eval(exp, env)
 		if isError(evaluated) {
 			return []object.Object{evaluated}
 		}
@@ -300,11 +448,49 @@ func evalIndexExpression(left, index object.Object) object.Object {
 		return evalArrayIndexExpression(left, index)
 	case left.Type() == object.HashObj:
 		return evalHashIndexExpression(left, index)
+	case left.Type() == object.StringObj && index.Type() == object.IntegerObj:
+		char, ok := object.CharAt(left.(*object.String).Value, index.(*object.Integer).Value)
+		if !ok {
+			return NULL
+		}
+		return char
 	default:
 		return newError("index operator not supported: %s", left.Type())
 	}
 }
 
+// evalSliceExpression evaluates the sliced value and the bounds, a bound left out is null
+func (e *Evaluator) evalSliceExpression(node *ast.SliceExpression, env *object.Environment) object.Object {
+	left := e.Eval(node.Left, env)
+	if isError(left) {
+		return left
+	}
+	bounds := []object.Object{NULL, NULL}
+	for i, bound := range []ast.Expression{node.Start, node.End} {
+		if bound == nil {
+			continue
+		}
+		bounds[i] = e.Eval(bound, env)
+		if isError(bounds[i]) {
+			return bounds[i]
+		}
+	}
+	result, err := object.Slice(left, bounds[0], bounds[1])
+	if err != nil {
+		return newError("%s", err)
+	}
+	switch result := result.(type) {
+	case *object.Array:
+		err = e.Allocate(object.ArraySize(len(result.Elements)))
+	case *object.String:
+		err = e.Allocate(object.StringSize(len(result.Value)))
+	}
+	if err != nil {
+		return newError("%s", err)
+	}
+	return result
+}
+
 func evalArrayIndexExpression(array, index object.Object) object.Object {
 	arrayObject := array.(*object.Array)
 	idx := index.(*object.Integer).Value
@@ -317,25 +503,69 @@ func evalArrayIndexExpression(array, index object.Object) object.Object {
 
 func evalHashIndexExpression(hash, index object.Object) object.Object {
 	hashObject := hash.(*object.Hash)
-	key, ok := index.(object.Hashable)
+	key, ok := object.AsHashable(index)
 	if !ok {
 		return newError("unusable as hash key: %s", index.Type())
 	}
-	pair, ok := hashObject.Pairs[key.HashKey()]
+	value, ok := hashObject.Get(key)
 	if !ok {
 		return NULL
 	}
-	return pair.Value
+	return value
 }
 
-func applyFunction(fn object.Object, args []object.Object) object.Object {
+// countNode enforces the node budget and checks the context every object.ContextCheckInterval nodes
+func (e *Evaluator) countNode() error {
+	if e.err != nil {
+		return e.err
+	}
+	e.evaluated++
+	if e.limits.MaxInstructions > 0 && e.evaluated > e.limits.MaxInstructions {
+		e.err = object.InstructionBudgetError(e.limits.MaxInstructions)
+	} else if e.evaluated%object.ContextCheckInterval == 0 {
+		select {
+		case <-e.ctx.Done():
+			e.err = object.ContextError(e.ctx)
+		default:
+		}
+	}
+	return e.err
+}
+
+func (e *Evaluator) applyFunction(fn object.Object, args []object.Object) object.Object {
 	switch fn := fn.(type) {
 	case *object.Function:
-		extendedEnv := extendFunctionEnv(fn, args)
-		evaluated := Eval(fn.Body, extendedEnv)
-		return unwrapReturnValue(evaluated)
+		if len(args) != len(fn.Parameters) {
+			return newError("wrong number of arguments: want=%d, got=%d", len(fn.Parameters), len(args))
+		}
+		if e.limits.MaxCallDepth > 0 && e.depth >= e.limits.MaxCallDepth {
+			e.err = object.CallDepthError(e.limits.MaxCallDepth)
+			return newError("%s", e.err)
+		}
+		if e.limits.MaxCallDepth == 0 && e.depth >= MaxCallDepth {
+			e.err = fmt.Errorf("%w: more than %d nested calls", object.ErrStackOverflow, MaxCallDepth)
+			return newError("%s", e.err)
+		}
+		e.depth++
+		defer func() { e.depth-- }()
+		for {
+			extendedEnv := extendFunctionEnv(fn, args)
+			evaluated := unwrapReturnValue(e.Eval(fn.Body, extendedEnv))
+			call, ok := evaluated.(*tailCall)
+			if !ok {
+				return evaluated
+			}
+			next, ok := call.fn.(*object.Function)
+			if !ok { // builtins and errors don't need a trampoline
+				return e.applyFunction(call.fn, call.args)
+			}
+			if len(call.args) != len(next.Parameters) {
+				return newError("wrong number of arguments: want=%d, got=%d", len(next.Parameters), len(call.args))
+			}
+			fn, args = next, call.args
+		}
 	case *object.Builtin:
-		if result := fn.Fn(args...); result != nil {
+		if result := fn.Fn(e, args...); result != nil {
 			return result
 		}
 		return NULL
@@ -344,6 +574,16 @@ func applyFunction(fn object.Object, args []object.Object) object.Object {
 	}
 }
 
+// tailCall is what a call in tail position evaluates to. applyFunction makes the call once the body of
+// the calling function is done, so tail recursion runs in constant Go stack.
+type tailCall struct {
+	fn   object.Object
+	args []object.Object
+}
+
+func (tc *tailCall) Type() object.Type { return "TAIL_CALL" }
+func (tc *tailCall) Inspect() string   { return "tail call" }
+
 func extendFunctionEnv(fn *object.Function, args []object.Object,
 ) *object.Environment {
 	env := object.NewEnclosedEnvironment(fn.Env) // the fn.Evn it the outer. It's a linked list.
@@ -360,25 +600,28 @@ func unwrapReturnValue(obj object.Object) object.Object {
 	return obj
 }
 
-func evalHashLiteral(
+func (e *Evaluator) evalHashLiteral(
 	node *ast.HashLiteral, env *object.Environment,
 ) object.Object {
-	pairs := make(map[object.HashKey]object.HashPair)
-	for keyNode, valueNode := range node.Pairs {
-		key := Eval(keyNode, env)
+	if err := e.Allocate(object.HashSize(len(node.Pairs))); err != nil {
+		return newError("%s", err)
+	}
+	hash := object.NewHash(len(node.Pairs))
+	for _, keyNode := range node.Keys {
+		valueNode := node.Pairs[keyNode]
+		key := e.Eval(keyNode, env)
 		if isError(key) {
 			return key
 		}
-		hashKey, ok := key.(object.Hashable)
+		hashKey, ok := object.AsHashable(key)
 		if !ok {
 			return newError("unusable as hash key: %s", key.Type())
 		}
-		value := Eval(valueNode, env)
+		value := e.Eval(valueNode, env)
 		if isError(value) {
 			return value
 		}
-		hashed := hashKey.HashKey()
-		pairs[hashed] = object.HashPair{Key: key, Value: value}
+		hash.Set(hashKey, value)
 	}
-	return &object.Hash{Pairs: pairs}
+	return hash
 }
@@ -1,10 +1,14 @@
 package evaluator
 
 import (
+	"context"
+	"errors"
 	"jonathan/lexer"
 	"jonathan/object"
 	"jonathan/parser"
+	"math"
 	"testing"
+	"time"
 )
 
 func TestEvalIntegerExpression(t *testing.T) {
@@ -39,6 +43,82 @@ func testEval(input string) object.Object {
 	env := object.NewEnvironment()
 	return Eval(program, env)
 }
+
+type evalTestCase struct {
+	input    string
+	expected interface{}
+}
+
+// runEvalTests evaluates the input of every case and checks the result with testExpectedObject
+func runEvalTests(t *testing.T, tests []evalTestCase) {
+	t.Helper()
+	for _, tt := range tests {
+		testExpectedObject(t, tt.input, tt.expected, testEval(tt.input))
+	}
+}
+
+// testExpectedObject checks actual against expected: an int, float64, bool, string or nil expects that value,
+// an []int64 an array of these integers and an *object.Error an error with its message
+func testExpectedObject(t *testing.T, input string, expected interface{}, actual object.Object) {
+	t.Helper()
+	ok := true
+	defer func() {
+		if !ok {
+			t.Logf("input: %s", input)
+		}
+	}()
+	switch expected := expected.(type) {
+	case int:
+		ok = testIntegerObject(t, actual, int64(expected))
+	case float64:
+		float, isFloat := actual.(*object.Float)
+		if !isFloat {
+			t.Errorf("%s: object is not Float. got=%T (%+v)", input, actual, actual)
+			return
+		}
+		if float.Value != expected {
+			t.Errorf("%s: wrong float. want=%g, got=%g", input, expected, float.Value)
+		}
+	case bool:
+		ok = testBooleanObject(t, actual, expected)
+	case nil:
+		ok = testNullObject(t, actual)
+	case string:
+		str, isString := actual.(*object.String)
+		if !isString {
+			t.Errorf("%s: object is not String. got=%T (%+v)", input, actual, actual)
+			return
+		}
+		if str.Value != expected {
+			t.Errorf("%s: wrong string. want=%q, got=%q", input, expected, str.Value)
+		}
+	case []int64:
+		array, isArray := actual.(*object.Array)
+		if !isArray {
+			t.Errorf("%s: object is not Array. got=%T (%+v)", input, actual, actual)
+			return
+		}
+		if len(array.Elements) != len(expected) {
+			t.Errorf("%s: wrong number of elements. want=%d, got=%d", input, len(expected), len(array.Elements))
+			return
+		}
+		for i, value := range expected {
+			ok = testIntegerObject(t, array.Elements[i], value) && ok
+		}
+	case *object.Error:
+		errObj, isErrorObject := actual.(*object.Error)
+		if !isErrorObject {
+			t.Errorf("%s: object is not Error. got=%T (%+v)", input, actual, actual)
+			return
+		}
+		if errObj.Message != expected.Message {
+			t.Errorf("%s: wrong error message. want=%q, got=%q", input, expected.Message, errObj.Message)
+		}
+	default:
+		t.Errorf("%s: can't check a result against %T", input, expected)
+	}
+}
+
 func testIntegerObject(t *testing.T, obj object.Object, expected int64) bool {
 	result, ok := obj.(*object.Integer)
 
@@ -78,6 +158,18 @@ func TestEvalBooleanExpression(t *testing.T) {
 		{"(1 < 2) == false", false},
 		{"(1 > 2) == true", false},
 		{"(1 > 2) == false", true},
+		{`"a" + "b" == "ab"`, true},
+		{`"a" != "b"`, true},
+		{"[1, 2] == [1, 2]", true},
+		{"[1, 2] == [2, 1]", false},
+		{"[1, [2, 3]] == [1, [2, 3]]", true},
+		{"[1, 2] != [1, 2, 3]", true},
+		{`{"a": 1, "b": [2]} == {"b": [2], "a": 1}`, true},
+		{`{"a": 1} == {"a": 2}`, false},
+		{`{"a": 1} == {"b": 1}`, false},
+		{"[1] == 1", false},
+		{"let f = fn() { 1 }; f == f", true},
+		{"fn() { 1 } == fn() { 1 }", false},
 	}
 	for _, tt := range tests {
 		evaluated := testEval(tt.input)
@@ -215,6 +307,18 @@ func TestErrorHandling(t *testing.T) {
 			"foobar",
 			"identifier not found: foobar",
 		},
+		{
+			"1 / (2 - 2)",
+			"division by zero",
+		},
+		{
+			"PI / 0",
+			"division by zero",
+		},
+		{
+			"PI + true",
+			"type mismatch: FLOAT + BOOLEAN",
+		},
 		{
 			`"Hello" - "World"`,
 			"unknown operator: STRING - STRING",
@@ -222,6 +326,9 @@ func TestErrorHandling(t *testing.T) {
 		{`{"name": "Monkey"}[fn(x) { x }];`,
 			"unusable as hash key: FUNCTION",
 		},
+		{`{[1, fn(x) { x }]: 1}`,
+			"unusable as hash key: ARRAY",
+		},
 	}
 	for _, tt := range tests {
 		evaluated := testEval(tt.input)
@@ -434,16 +541,19 @@ func TestHashLiterals(t *testing.T) {
 	if !ok {
 		t.Fatalf("Eval didn't return Hash. got=%T (%+v)", evaluated, evaluated)
 	}
-	expected := map[object.HashKey]int64{(&object.String{Value: "one"}).HashKey(): 1, (&object.String{Value: "two"}).HashKey(): 2, (&object.String{Value: "three"}).HashKey(): 3, (&object.Integer{Value: 4}).HashKey(): 4, TRUE.HashKey(): 5, FALSE.HashKey(): 6}
-	if len(result.Pairs) != len(expected) {
-		t.Fatalf("Hash has wrong num of pairs. got=%d", len(result.Pairs))
-	}
-	for expectedKey, expectedValue := range expected {
-		pair, ok := result.Pairs[expectedKey]
-		if !ok {
-			t.Errorf("no pair for given key in Pairs")
+	// the pairs keep the order of the source
+	expected := []struct {
+		key   string
+		value int64
+	}{{"one", 1}, {"two", 2}, {"three", 3}, {"4", 4}, {"true", 5}, {"false", 6}}
+	if result.Len() != len(expected) {
+		t.Fatalf("Hash has wrong num of pairs. got=%d", result.Len())
+	}
+	for i, pair := range result.Pairs() {
+		if pair.Key.Inspect() != expected[i].key {
+			t.Errorf("pair %d has wrong key. want=%s, got=%s", i, expected[i].key, pair.Key.Inspect())
 		}
-		testIntegerObject(t, pair.Value, expectedValue)
+		testIntegerObject(t, pair.Value, expected[i].value)
 	}
 }
 
@@ -473,6 +583,18 @@ func TestHashIndexExpressions(t *testing.T) {
 		}, {
 			`{false: 5}[false]`,
 			5,
+		}, {
+			`{[1, 2]: 5}[[1, 2]]`,
+			5,
+		}, {
+			`{[1, 2]: 5}[[2, 1]]`,
+			nil,
+		}, {
+			`{[1, [true, "a"]]: 5}[[1, [true, "a"]]]`,
+			5,
+		}, {
+			`{if (false) { 1 }: 5}[if (false) { 1 }]`,
+			5,
 		}}
 	for _, tt := range tests {
 		evaluated := testEval(tt.input)
@@ -484,3 +606,343 @@ func TestHashIndexExpressions(t *testing.T) {
 		}
 	}
 }
+
+func TestBuiltinsCallingFunctions(t *testing.T) {
+	builtins := object.NewBuiltinRegistry()
+	_, err := builtins.Register("twice", 2, func(ctx object.CallContext, args ...object.Object) object.Object {
+		once, err := ctx.Call(args[0], args[1])
+		if err != nil {
+			return &object.Error{Message: err.Error()}
+		}
+		twice, err := ctx.Call(args[0], once)
+		if err != nil {
+			return &object.Error{Message: err.Error()}
+		}
+		return twice
+	})
+	if err != nil {
+		t.Fatalf("register failed: %s", err)
+	}
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{`twice(fn(x) { x * 2 }, 3)`, 12},
+		{`let offset = 10; twice(fn(x) { x + offset }, 1)`, 21},
+		{`let f = fn(y) { twice(fn(x) { x + y }, 0) }; f(5) + 1`, 11},
+		{`twice(fn() { 1 }, 3)`, "wrong number of arguments: want=0, got=1"},
+	}
+	for _, tt := range tests {
+		l := lexer.NewLexer(tt.input)
+		p := parser.NewParser(l)
+		evaluated := NewEvaluatorWithBuiltins(builtins).Eval(p.ParseProgram(), object.NewEnvironment())
+		switch expected := tt.expected.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case string:
+			errObj, ok := evaluated.(*object.Error)
+			if !ok {
+				t.Errorf("object is not Error. got=%T (%+v)", evaluated, evaluated)
+				continue
+			}
+			if errObj.Message != expected {
+				t.Errorf("wrong error message. expected=%q, got=%q", expected, errObj.Message)
+			}
+		}
+	}
+}
+
+func TestCallFromHost(t *testing.T) {
+	e := NewEvaluator()
+	env := object.NewEnvironment()
+	l := lexer.NewLexer(`let base = 100; let add = fn(a, b) { a + b + base }; add`)
+	p := parser.NewParser(l)
+	add := e.Eval(p.ParseProgram(), env)
+	result, err := e.Call(add, &object.Integer{Value: 1}, &object.Integer{Value: 2})
+	if err != nil {
+		t.Fatalf("call failed: %s", err)
+	}
+	testIntegerObject(t, result, 103)
+	_, err = e.Call(add, &object.Integer{Value: 1})
+	if err == nil || err.Error() != "wrong number of arguments: want=2, got=1" {
+		t.Fatalf("wrong call error. got=%v", err)
+	}
+
+	// a limit the call hits is reported like EvalWithContext reports it
+	e.SetLimits(object.Limits{MaxAllocBytes: 100})
+	l = lexer.NewLexer(`fn(n) { [n, n, n, n, n, n, n, n] }`)
+	build := e.Eval(parser.NewParser(l).ParseProgram(), env)
+	_, err = e.Call(build, &object.Integer{Value: 1})
+	if !errors.Is(err, object.ErrMemoryLimitExceeded) {
+		t.Fatalf("wrong call error. want=%v, got=%v", object.ErrMemoryLimitExceeded, err)
+	}
+}
+
+func TestExecutionLimits(t *testing.T) {
+	tests := []struct {
+		input    string
+		limits   object.Limits
+		expected error
+	}{
+		{`let f = fn(x) { 1 + f(x + 1) }; f(0)`, object.Limits{MaxCallDepth: 100}, object.ErrBudgetExceeded},
+		// without a limit the calls nest as deep as the vm has frames for
+		{`let f = fn(n) { 1 + f(n) }; f(0)`, object.Limits{}, object.ErrStackOverflow},
+		{`let f = fn(n) { if (n > 0) { 1 + f(n - 1) } else { 0 } }; f(60000)`, object.Limits{}, nil},
+		{`let f = fn(x) { f(x + 1) }; f(0)`, object.Limits{MaxInstructions: 100000}, object.ErrBudgetExceeded},
+		{`let f = fn(x) { if (x > 0) { f(x - 1) } else { 0 } }; f(100)`, object.Limits{MaxCallDepth: 200}, nil},
+		{`let f = fn(x) { if (x > 0) { f(x - 1) } else { 0 } }; f(100)`, object.Limits{MaxInstructions: 500}, object.ErrBudgetExceeded},
+		{`1 + 2`, object.Limits{MaxInstructions: 500}, nil},
+		{`let grow = fn(arr, n) { if (n > 0) { grow(push(arr, n), n - 1) } else { arr } }; len(grow([], 500))`, object.Limits{MaxAllocBytes: 100000}, object.ErrMemoryLimitExceeded},
+		{`let grow = fn(arr, n) { if (n > 0) { grow(push(arr, n), n - 1) } else { arr } }; len(grow([], 500))`, object.Limits{MaxAllocBytes: 10000000}, nil},
+		{`let double = fn(s, n) { if (n > 0) { double(s + s, n - 1) } else { len(s) } }; double("ab", 40)`, object.Limits{MaxAllocBytes: 1 << 20}, object.ErrMemoryLimitExceeded},
+		{`let shrink = fn(arr) { if (len(arr) > 0) { shrink(rest(arr)) } else { 0 } }; shrink([1, 2, 3, 4, 5, 6, 7, 8])`, object.Limits{MaxAllocBytes: 300}, object.ErrMemoryLimitExceeded},
+		{`{"a": 1, "b": 2, "c": 3}`, object.Limits{MaxAllocBytes: 100}, object.ErrMemoryLimitExceeded},
+		{`{"a": 1, "b": 2, "c": 3}`, object.Limits{MaxAllocBytes: 1000}, nil},
+	}
+	for _, tt := range tests {
+		e := NewEvaluator()
+		e.SetLimits(tt.limits)
+		program := parser.NewParser(lexer.NewLexer(tt.input)).ParseProgram()
+		_, err := e.EvalWithContext(context.Background(), program, object.NewEnvironment())
+		if tt.expected == nil && err != nil {
+			t.Errorf("%s: unexpected error: %s", tt.input, err)
+		}
+		if tt.expected != nil && !errors.Is(err, tt.expected) {
+			t.Errorf("%s: wrong error. want=%v, got=%v", tt.input, tt.expected, err)
+		}
+	}
+}
+
+func TestTryMemoryLimit(t *testing.T) {
+	grow := `let grow = fn(s) { grow(s + s) }; `
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{grow + `try(fn() { grow("ab") }, fn(err) { err })`, "memory limit exceeded: more than 1048576 bytes allocated"},
+		// what the failed call allocated is forgotten, the script can go on allocating
+		{grow + `let caught = try(fn() { grow("ab") }, fn(err) { "caught" }); len(repeat(caught, 1000))`, 6000},
+		{grow + `try(fn() { [1, 2, 3] }, fn(err) { [] })`, []int64{1, 2, 3}},
+		// the handler and other limits aren't caught
+		{grow + `try(fn() { grow("ab") }, fn(err) { grow(err) })`, object.ErrMemoryLimitExceeded},
+		{`let f = fn(x) { f(x + 1) }; try(fn() { f(0) }, fn(err) { 0 })`, object.ErrBudgetExceeded},
+	}
+	for _, tt := range tests {
+		e := NewEvaluator()
+		e.SetLimits(object.Limits{MaxAllocBytes: 1 << 20, MaxInstructions: 100000})
+		program := parser.NewParser(lexer.NewLexer(tt.input)).ParseProgram()
+		result, err := e.EvalWithContext(context.Background(), program, object.NewEnvironment())
+		if expected, ok := tt.expected.(error); ok {
+			if !errors.Is(err, expected) {
+				t.Errorf("%s: wrong error. want=%v, got=%v", tt.input, expected, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %s", tt.input, err)
+		}
+		testExpectedObject(t, tt.input, tt.expected, result)
+	}
+}
+
+func TestEvalWithContext(t *testing.T) {
+	fibonacci := `let fib = fn(x) { if (x < 2) { x } else { fib(x - 1) + fib(x - 2) } }; fib(35)`
+	program := parser.NewParser(lexer.NewLexer(fibonacci)).ParseProgram()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err := NewEvaluator().EvalWithContext(ctx, program, object.NewEnvironment())
+	if !errors.Is(err, object.ErrTimeout) {
+		t.Errorf("expected timeout. got=%v", err)
+	}
+
+	ctx, cancel = context.WithCancel(context.Background())
+	cancel()
+	_, err = NewEvaluator().EvalWithContext(ctx, program, object.NewEnvironment())
+	if !errors.Is(err, context.Canceled) || errors.Is(err, object.ErrTimeout) {
+		t.Errorf("expected cancellation. got=%v", err)
+	}
+}
+
+func TestTailCalls(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected int64
+	}{
+		{"let loop = fn(n, acc) { if (n == 0) { acc } else { loop(n - 1, acc + 1) } }; loop(100000, 0)", 100000},
+		{"let loop = fn(n) { if (n == 0) { return 0 }; return loop(n - 1) }; loop(5000)", 0},
+		{`
+		let build = fn(n, arr) { if (n == 0) { arr } else { build(n - 1, push(arr, n)) } };
+		let sum = fn(arr, acc) { if (len(arr) == 0) { return acc }; sum(rest(arr), acc + first(arr)) };
+		sum(build(2000, []), 0)`, 2001000},
+		{"let count = fn(a) { len(a) }; count([1, 2, 3])", 3},
+		{"let adder = fn(x) { fn(n, acc) { if (n == 0) { acc } else { adder(x)(n - 1, acc + x) } } }; adder(2)(3000, 0)", 6000},
+	}
+	for _, tt := range tests {
+		testIntegerObject(t, testEval(tt.input), tt.expected)
+	}
+	testBooleanObject(t, testEval(`
+		let isEven = fn(n) { if (n == 0) { true } else { isOdd(n - 1) } };
+		let isOdd = fn(n) { if (n == 0) { false } else { isEven(n - 1) } };
+		isEven(10001)`), false)
+}
+
+func TestStringsAndSlices(t *testing.T) {
+	tests := []evalTestCase{
+		{`"a" < "b"`, true},
+		{`"ab" > "a"`, true},
+		{`"héllo"[1]`, "é"},
+		{`"abc"[3]`, nil},
+		{`"héllo"[1:3]`, "él"},
+		{`"abc"[-2:]`, "bc"},
+		{`"abc"[2:1]`, ""},
+		{"[1, 2, 3, 4][1:3]", []int64{2, 3}},
+		{"[1, 2, 3, 4][:-3]", []int64{1}},
+		{`[1, 2]["a":]`, &object.Error{Message: "slice bound must be INTEGER, got STRING"}},
+		{`5[1:]`, &object.Error{Message: "slice operator not supported: INTEGER"}},
+	}
+	runEvalTests(t, tests)
+}
+
+func TestCollectionBuiltins(t *testing.T) {
+	tests := []evalTestCase{
+		{`keys({"a": 1, "b": 2}) == ["a", "b"]`, true},
+		{`values({"a": 1, "b": 2}) == [1, 2]`, true},
+		{`entries({"a": 1}) == [["a", 1]]`, true},
+		{`has({"a": 1}, "a")`, true},
+		{`delete({"a": 1, "b": 2}, "a") == {"b": 2}`, true},
+		{`merge({"a": 1}, {"a": 2}) == {"a": 2}`, true},
+		{`slice([1, 2, 3], 1) == [2, 3]`, true},
+		{`concat([1], [2]) == [1, 2]`, true},
+		{`reverse([1, 2]) == [2, 1]`, true},
+		{`index_of([1, 2], 2)`, 1},
+		{`contains([1, 2], 3)`, false},
+		{`join(["a", "b"], "") == "ab"`, true},
+		{`range(1, 4) == [1, 2, 3]`, true},
+		{`zip([1], [2]) == [[1, 2]]`, true},
+		{`flatten([[1], 2]) == [1, 2]`, true},
+		{`unique([1, 1, 2]) == [1, 2]`, true},
+		{`unique([1, 1.0])`, []int64{1}},
+		{`unique([1.0, 1]) == [1.0]`, true},
+		{`values([])`, &object.Error{Message: "argument to `values` must be HASH, got ARRAY"}},
+		{`index_of([1])`, &object.Error{Message: "wrong number of arguments. got=1, want=2"}},
+	}
+	runEvalTests(t, tests)
+}
+
+func TestHigherOrderBuiltins(t *testing.T) {
+	tests := []evalTestCase{
+		{`map([1, 2, 3], fn(x) { x * 2 }) == [2, 4, 6]`, true},
+		{`filter([1, 2, 3, 4], fn(x) { x > 2 }) == [3, 4]`, true},
+		{`filter([0, 1, 2], fn(x) { x }) == [0, 1, 2]`, true},
+		{`reduce([1, 2, 3, 4], 0, fn(acc, x) { acc + x })`, 10},
+		{`each([1], fn(x) { x }) == if (false) { 1 }`, true},
+		{`sort([3, 1, 2], fn(a, b) { a > b }) == [3, 2, 1]`, true},
+		{`sort_by(["bb", "a"], len) == ["a", "bb"]`, true},
+		{`map([1], len)`, &object.Error{Message: "argument to `len` not supported, got INTEGER"}},
+		{`sort([1, "a"])`, &object.Error{Message: "`sort` can't compare STRING and INTEGER"}},
+	}
+	runEvalTests(t, tests)
+}
+
+func TestStringBuiltins(t *testing.T) {
+	tests := []evalTestCase{
+		{`len("héllo")`, 5},
+		{`split("a,b", ",") == ["a", "b"]`, true},
+		{`upper("abc") == "ABC"`, true},
+		{`index_of("héllo", "l")`, 2},
+		{`format("%s=%d", "x", 1) == "x=1"`, true},
+		{`pad_left("1", 2, "0") == "01"`, true},
+		{`chr(ord("a")) == "a"`, true},
+		{`trim(1)`, &object.Error{Message: "argument to `trim` must be STRING, got INTEGER"}},
+	}
+	runEvalTests(t, tests)
+}
+
+func TestRandomNumbersPerRun(t *testing.T) {
+	e := NewEvaluator()
+	run := func(input string) object.Object {
+		program := parser.NewParser(lexer.NewLexer(input)).ParseProgram()
+		result, err := e.EvalWithContext(context.Background(), program, object.NewEnvironment())
+		if err != nil {
+			t.Fatalf("eval error: %s", err)
+		}
+		return result
+	}
+	first := run("random_int(1, 1000000)")
+	run("random_seed(42); random_int(1, 1000000)")
+	// every run of the evaluator starts from the default seed again
+	if again := run("random_int(1, 1000000)"); !object.Equal(first, again) {
+		t.Errorf("unseeded runs got different numbers: %s and %s", first.Inspect(), again.Inspect())
+	}
+}
+
+func TestFloatArithmetic(t *testing.T) {
+	tests := []evalTestCase{
+		{"PI * 2", math.Pi * 2},
+		{"sqrt(4) + 1", 3.0},
+		{"1 + sqrt(4)", 3.0},
+		{"10 - sqrt(4)", 8.0},
+		{"1 / sqrt(4)", 0.5},
+		{"-E", -math.E},
+		{"-sqrt(4) * 3", -6.0},
+		{"sqrt(2) > 1", true},
+		{"sqrt(2) < 1", false},
+		{"PI < E", false},
+		{"sqrt(4) == 2", true},
+		{"sqrt(4) != 2", false},
+		{"let f = fn(x) { x * 4611686018427387904 * 4 }; f(PI)", math.Pi * 4611686018427387904 * 4},
+		{"2.5", 2.5},
+		{"-0.5 * 3", -1.5},
+		{"1 < 1.5", true},
+		{"0.1 + 0.2 == 0.3", false},
+		{"round(2.5)", 3},
+		{"floor(-1.5)", -2},
+		{"1.5 / 0", &object.Error{Message: "division by zero"}},
+	}
+	runEvalTests(t, tests)
+}
+
+func TestMathBuiltins(t *testing.T) {
+	tests := []evalTestCase{
+		{`abs(-3)`, 3},
+		{`max(1, 3, 2)`, 3},
+		{`pow(2, 10)`, 1024},
+		{`sqrt(16) == 4`, true},
+		{`floor(PI)`, 3},
+		{`round(E)`, 3},
+		{`clamp(5, 1, 3)`, 3},
+		{`gcd(12, 18)`, 6},
+		{`cos(0) == 1`, true},
+		{`random_seed(3); let a = random_int(1, 6); random_seed(3); a == random_int(1, 6)`, true},
+		{`let E = 1; E`, 1},
+		{`sqrt("a")`, &object.Error{Message: "argument to `sqrt` must be INTEGER or FLOAT, got STRING"}},
+	}
+	runEvalTests(t, tests)
+}
+
+func TestJSONBuiltins(t *testing.T) {
+	tests := []evalTestCase{
+		{`json_stringify({"b": [1, true], "a": "x"}) == format("{%sa%s:%sx%s,%sb%s:[1,true]}", chr(34), chr(34), chr(34), chr(34), chr(34), chr(34))`, true},
+		{`json_parse(json_stringify({"b": 1, "a": [true, "x"]})) == {"b": 1, "a": [true, "x"]}`, true},
+		{`json_parse("[1, 2]")[1]`, 2},
+		{`json_stringify(fn(x) { x })`, &object.Error{Message: "can't convert FUNCTION to JSON"}},
+		{`json_parse("[")`, &object.Error{Message: "invalid JSON: unexpected end of JSON input"}},
+	}
+	runEvalTests(t, tests)
+}
+
+func TestTypeBuiltins(t *testing.T) {
+	tests := []evalTestCase{
+		{`type(fn(x) { x }) == "FUNCTION"`, true},
+		{`type({}) == "HASH"`, true},
+		{`str([1, 2]) == "[1, 2]"`, true},
+		{`int("-12")`, -12},
+		{`bool(0)`, true},
+		{`bool(false)`, false},
+		{`arity(fn(a, b) { a })`, 2},
+		{`arity(len)`, 1},
+		{`int("x")`, &object.Error{Message: "can't convert \"x\" to INTEGER"}},
+	}
+	runEvalTests(t, tests)
+}
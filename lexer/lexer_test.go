@@ -27,6 +27,8 @@ func TestNextToken(t *testing.T) {
    			"foo bar"
 			[1, 2];
 			{"foo": "bar"}
+			1.5 < 10.25;
+			3.x
 			`
 	tests := []struct {
 		expectedType    token.Type
@@ -120,6 +122,14 @@ func TestNextToken(t *testing.T) {
 		{token.COLON, ":"},
 		{token.STRING, "bar"},
 		{token.RBRACE, "}"},
+		{token.FLOAT, "1.5"},
+		{token.LT, "<"},
+		{token.FLOAT, "10.25"},
+		{token.SEMICOLON, ";"},
+		// a dot without digits after it doesn't make a float
+		{token.INT, "3"},
+		{token.ILLEGAL, "."},
+		{token.IDENT, "x"},
 		{token.EOF, ""},
 	}
 
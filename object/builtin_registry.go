@@ -0,0 +1,146 @@
+package object
+
+import (
+	"fmt"
+	"reflect"
+)
+
+// MaxBuiltins is the number of builtins one registry can hold.
+// OpGetBuiltin addresses a builtin with a two-byte operand when it is prefixed with OpWide.
+const MaxBuiltins = 65536
+
+// BuiltinRegistry holds the builtins of one runtime.
+// The compiler resolves builtin names to their index in the registry and the vm loads them by that index,
+// so a compiler and the vm running its bytecode have to share the same registry.
+// Constants are resolved by name when a script is compiled and become constants of its bytecode.
+type BuiltinRegistry struct {
+	definitions []BuiltinDefinition
+	indexes     map[string]int
+	constants   map[string]Object
+}
+
+// NewBuiltinRegistry returns a registry holding a copy of the default Builtins and Constants.
+// Registering functions in it doesn't affect any other registry.
+func NewBuiltinRegistry() *BuiltinRegistry {
+	r := &BuiltinRegistry{
+		definitions: make([]BuiltinDefinition, 0, len(Builtins)),
+		indexes:     make(map[string]int, len(Builtins)),
+		constants:   make(map[string]Object, len(Constants)),
+	}
+	for _, def := range Builtins {
+		r.indexes[def.Name] = len(r.definitions)
+		r.definitions = append(r.definitions, def)
+	}
+	for name, value := range Constants {
+		r.constants[name] = value
+	}
+	return r
+}
+
+// Register adds a Go function under name. If arity isn't VariadicArity
+// the number of arguments is checked before fn is called.
+// It returns the index of the builtin.
+func (r *BuiltinRegistry) Register(name string, arity int, fn BuiltinFunction) (int, error) {
+	if fn == nil {
+		return 0, fmt.Errorf("builtin %s has no function", name)
+	}
+	if arity < VariadicArity {
+		return 0, fmt.Errorf("builtin %s has invalid arity %d", name, arity)
+	}
+	builtin := &Builtin{Fn: fn, Arity: arity}
+	if arity != VariadicArity {
+		builtin.Fn = func(ctx CallContext, args ...Object) Object {
+			if len(args) != arity {
+				return newError("wrong number of arguments. got=%d, want=%d", len(args), arity)
+			}
+			return fn(ctx, args...)
+		}
+	}
+	return r.RegisterBuiltin(name, builtin)
+}
+
+// RegisterFunc adds a Go function whose arguments and results are converted with ToGo and FromGo.
+// The arity of the builtin is the number of parameters of fn, not counting a leading CallContext.
+func (r *BuiltinRegistry) RegisterFunc(name string, fn any) (int, error) {
+	if reflect.TypeOf(fn) == nil || reflect.TypeOf(fn).Kind() != reflect.Func {
+		return 0, fmt.Errorf("builtin %s: %T is not a function", name, fn)
+	}
+	obj, err := FromGo(fn)
+	if err != nil {
+		return 0, fmt.Errorf("builtin %s: %w", name, err)
+	}
+	builtin, ok := obj.(*Builtin)
+	if !ok {
+		return 0, fmt.Errorf("builtin %s has no function", name)
+	}
+	return r.RegisterBuiltin(name, builtin)
+}
+
+// RegisterBuiltin adds builtin under name. A builtin registered with the name
+// of an existing one replaces it and keeps its index.
+func (r *BuiltinRegistry) RegisterBuiltin(name string, builtin *Builtin) (int, error) {
+	if name == "" {
+		return 0, fmt.Errorf("builtin name must not be empty")
+	}
+	if builtin == nil || builtin.Fn == nil {
+		return 0, fmt.Errorf("builtin %s has no function", name)
+	}
+	if index, ok := r.indexes[name]; ok {
+		r.definitions[index] = BuiltinDefinition{Name: name, Builtin: builtin}
+		return index, nil
+	}
+	if len(r.definitions) >= MaxBuiltins {
+		return 0, fmt.Errorf("too many builtins: cannot register %s, the limit is %d", name, MaxBuiltins)
+	}
+	index := len(r.definitions)
+	r.definitions = append(r.definitions, BuiltinDefinition{Name: name, Builtin: builtin})
+	r.indexes[name] = index
+	return index, nil
+}
+
+// RegisterConstant adds value under name, scripts read it like a variable.
+// Builtins and variables of the script with the same name hide the constant.
+func (r *BuiltinRegistry) RegisterConstant(name string, value Object) error {
+	if name == "" {
+		return fmt.Errorf("constant name must not be empty")
+	}
+	if value == nil {
+		return fmt.Errorf("constant %s has no value", name)
+	}
+	r.constants[name] = value
+	return nil
+}
+
+// Constant returns the constant registered under name.
+func (r *BuiltinRegistry) Constant(name string) (Object, bool) {
+	value, ok := r.constants[name]
+	return value, ok
+}
+
+// Lookup returns the builtin registered under name.
+func (r *BuiltinRegistry) Lookup(name string) (*Builtin, bool) {
+	index, ok := r.indexes[name]
+	if !ok {
+		return nil, false
+	}
+	return r.definitions[index].Builtin, true
+}
+
+// Get returns the builtin at index, or nil if there is none.
+func (r *BuiltinRegistry) Get(index int) *Builtin {
+	if index < 0 || index >= len(r.definitions) {
+		return nil
+	}
+	return r.definitions[index].Builtin
+}
+
+// Definitions returns the registered builtins in index order.
+func (r *BuiltinRegistry) Definitions() []BuiltinDefinition {
+	definitions := make([]BuiltinDefinition, len(r.definitions))
+	copy(definitions, r.definitions)
+	return definitions
+}
+
+func (r *BuiltinRegistry) Len() int {
+	return len(r.definitions)
+}
@@ -0,0 +1,118 @@
+package object
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestBuiltinRegistryStartsWithDefaults(t *testing.T) {
+	r := NewBuiltinRegistry()
+	if r.Len() != len(Builtins) {
+		t.Fatalf("wrong number of builtins. want=%d, got=%d", len(Builtins), r.Len())
+	}
+	for i, def := range Builtins {
+		if r.Get(i) != def.Builtin {
+			t.Errorf("builtin %s not at index %d", def.Name, i)
+		}
+		builtin, ok := r.Lookup(def.Name)
+		if !ok || builtin != def.Builtin {
+			t.Errorf("builtin %s not found by name", def.Name)
+		}
+	}
+	if r.Get(-1) != nil || r.Get(r.Len()) != nil {
+		t.Errorf("Get out of range should return nil")
+	}
+}
+
+func TestBuiltinRegistryRegister(t *testing.T) {
+	r := NewBuiltinRegistry()
+	other := NewBuiltinRegistry()
+	index, err := r.Register("double", 1, func(ctx CallContext, args ...Object) Object {
+		return &Integer{Value: args[0].(*Integer).Value * 2}
+	})
+	if err != nil {
+		t.Fatalf("register failed: %s", err)
+	}
+	if index != len(Builtins) {
+		t.Errorf("wrong index. want=%d, got=%d", len(Builtins), index)
+	}
+	if _, ok := other.Lookup("double"); ok {
+		t.Errorf("registering in one registry leaked into another")
+	}
+	double, ok := r.Lookup("double")
+	if !ok {
+		t.Fatalf("double not registered")
+	}
+	if double.Arity != 1 {
+		t.Errorf("wrong arity. want=1, got=%d", double.Arity)
+	}
+	result := double.Fn(nil, &Integer{Value: 21})
+	if integer, ok := result.(*Integer); !ok || integer.Value != 42 {
+		t.Errorf("wrong result. got=%+v", result)
+	}
+	result = double.Fn(nil)
+	errObj, ok := result.(*Error)
+	if !ok {
+		t.Fatalf("arity not checked. got=%T (%+v)", result, result)
+	}
+	if errObj.Message != "wrong number of arguments. got=0, want=1" {
+		t.Errorf("wrong error message. got=%q", errObj.Message)
+	}
+}
+
+func TestBuiltinRegistryReplace(t *testing.T) {
+	r := NewBuiltinRegistry()
+	defaultPuts := Builtins[1].Builtin
+	quiet := func(ctx CallContext, args ...Object) Object { return nil }
+	index, err := r.Register("puts", VariadicArity, quiet)
+	if err != nil {
+		t.Fatalf("register failed: %s", err)
+	}
+	if index != 1 {
+		t.Errorf("replaced builtin moved. want index=1, got=%d", index)
+	}
+	if r.Len() != len(Builtins) {
+		t.Errorf("replacing a builtin changed the registry size")
+	}
+	if r.Get(1) == defaultPuts {
+		t.Errorf("puts was not replaced")
+	}
+	if Builtins[1].Builtin != defaultPuts {
+		t.Errorf("default builtins were modified")
+	}
+}
+
+func TestBuiltinRegistryLimit(t *testing.T) {
+	r := NewBuiltinRegistry()
+	noop := func(ctx CallContext, args ...Object) Object { return nil }
+	for i := r.Len(); i < MaxBuiltins; i++ {
+		_, err := r.Register(fmt.Sprintf("host%d", i), 0, noop)
+		if err != nil {
+			t.Fatalf("register %d failed: %s", i, err)
+		}
+	}
+	_, err := r.Register("one_too_many", 0, noop)
+	if err == nil {
+		t.Fatalf("expected an error when exceeding %d builtins", MaxBuiltins)
+	}
+}
+
+func TestBuiltinRegistryConstants(t *testing.T) {
+	r := NewBuiltinRegistry()
+	other := NewBuiltinRegistry()
+	if pi, ok := r.Constant("PI"); !ok || pi != Constants["PI"] {
+		t.Errorf("PI not found. got=%v", pi)
+	}
+	if err := r.RegisterConstant("ANSWER", NewInteger(42)); err != nil {
+		t.Fatalf("register failed: %s", err)
+	}
+	if answer, ok := r.Constant("ANSWER"); !ok || !Equal(answer, NewInteger(42)) {
+		t.Errorf("wrong constant. got=%v", answer)
+	}
+	if _, ok := other.Constant("ANSWER"); ok {
+		t.Errorf("registering in one registry leaked into another")
+	}
+	if err := r.RegisterConstant("NOTHING", nil); err == nil {
+		t.Errorf("expected an error for a constant without a value")
+	}
+}
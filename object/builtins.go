@@ -1,24 +1,36 @@
 package object
 
-import "fmt"
+import (
+	"fmt"
+	"math"
+	"strings"
+	"unicode/utf8"
+)
 
-// Builtins is a part of Object. it's used in vm directly.
-// but we have to store the builtin func name and the index of builtin func in the compiler symbol table.
-var Builtins = []struct {
+// VariadicArity marks a builtin that accepts any number of arguments.
+const VariadicArity = -1
+
+// BuiltinDefinition binds a builtin to the name scripts call it by.
+type BuiltinDefinition struct {
 	Name    string
 	Builtin *Builtin
-}{
+}
+
+// Builtins is the default builtin set. Every BuiltinRegistry starts as a copy of it,
+// so the index of a definition here is also its index in a fresh registry.
+var Builtins = []BuiltinDefinition{
 	{"len",
 		&Builtin{
-			Fn: func(args ...Object) Object {
+			Arity: 1,
+			Fn: func(ctx CallContext, args ...Object) Object {
 				if len(args) != 1 {
 					return newError("wrong number of arguments. got=%d, want=1", len(args))
 				}
 				switch arg := args[0].(type) {
 				case *Array:
-					return &Integer{Value: int64(len(arg.Elements))}
+					return NewInteger(int64(len(arg.Elements)))
 				case *String:
-					return &Integer{Value: int64(len(arg.Value))}
+					return NewInteger(int64(utf8.RuneCountInString(arg.Value)))
 				default:
 					return newError("argument to `len` not supported, got %s", args[0].Type())
 				}
@@ -27,7 +39,8 @@ var Builtins = []struct {
 	},
 	{"puts",
 		&Builtin{
-			Fn: func(args ...Object) Object {
+			Arity: VariadicArity,
+			Fn: func(ctx CallContext, args ...Object) Object {
 				for _, arg := range args {
 					fmt.Println(arg.Inspect())
 				}
@@ -37,7 +50,8 @@ var Builtins = []struct {
 	},
 	{"first",
 		&Builtin{
-			Fn: func(args ...Object) Object {
+			Arity: 1,
+			Fn: func(ctx CallContext, args ...Object) Object {
 				if len(args) != 1 {
 					return newError("wrong number of arguments. got=%d, want=1",
 						len(args))
@@ -55,7 +69,8 @@ var Builtins = []struct {
 	},
 	{"last",
 		&Builtin{
-			Fn: func(args ...Object) Object {
+			Arity: 1,
+			Fn: func(ctx CallContext, args ...Object) Object {
 				if len(args) != 1 {
 					return newError("wrong number of arguments. got=%d, want=1", len(args))
 				}
@@ -73,7 +88,8 @@ var Builtins = []struct {
 	},
 	{"rest",
 		&Builtin{
-			Fn: func(args ...Object) Object {
+			Arity: 1,
+			Fn: func(ctx CallContext, args ...Object) Object {
 				if len(args) != 1 {
 					return newError("wrong number of arguments. got=%d, want=1",
 						len(args))
@@ -84,6 +100,9 @@ var Builtins = []struct {
 				arr := args[0].(*Array)
 				length := len(arr.Elements)
 				if length > 0 {
+					if err := allocate(ctx, ArraySize(length-1)); err != nil {
+						return err
+					}
 					newElements := make([]Object, length-1)
 					copy(newElements, arr.Elements[1:length])
 					return &Array{Elements: newElements}
@@ -94,7 +113,8 @@ var Builtins = []struct {
 	},
 	{"push",
 		&Builtin{
-			Fn: func(args ...Object) Object {
+			Arity: 2,
+			Fn: func(ctx CallContext, args ...Object) Object {
 				if len(args) != 2 {
 					return newError("wrong number of arguments. got=%d, want=2",
 						len(args))
@@ -104,6 +124,9 @@ var Builtins = []struct {
 				}
 				arr := args[0].(*Array)
 				length := len(arr.Elements)
+				if err := allocate(ctx, ArraySize(length+1)); err != nil {
+					return err
+				}
 				newElements := make([]Object, length+1)
 				copy(newElements, arr.Elements)
 				newElements[length] = args[1]
@@ -111,6 +134,76 @@ var Builtins = []struct {
 			},
 		},
 	},
+	// hashes
+	{"keys", &Builtin{Arity: 1, Fn: builtinKeys}},
+	{"values", &Builtin{Arity: 1, Fn: builtinValues}},
+	{"has", &Builtin{Arity: 2, Fn: builtinHas}},
+	{"delete", &Builtin{Arity: 2, Fn: builtinDelete}},
+	{"merge", &Builtin{Arity: VariadicArity, Fn: builtinMerge}},
+	{"entries", &Builtin{Arity: 1, Fn: builtinEntries}},
+	// arrays
+	{"slice", &Builtin{Arity: VariadicArity, Fn: builtinSlice}},
+	{"concat", &Builtin{Arity: VariadicArity, Fn: builtinConcat}},
+	{"reverse", &Builtin{Arity: 1, Fn: builtinReverse}},
+	{"index_of", &Builtin{Arity: 2, Fn: builtinIndexOf}},
+	{"contains", &Builtin{Arity: 2, Fn: builtinContains}},
+	{"join", &Builtin{Arity: 2, Fn: builtinJoin}},
+	{"range", &Builtin{Arity: VariadicArity, Fn: builtinRange}},
+	{"zip", &Builtin{Arity: VariadicArity, Fn: builtinZip}},
+	{"flatten", &Builtin{Arity: 1, Fn: builtinFlatten}},
+	{"unique", &Builtin{Arity: 1, Fn: builtinUnique}},
+	// functions calling back into the script
+	{"map", &Builtin{Arity: 2, Fn: builtinMap}},
+	{"filter", &Builtin{Arity: 2, Fn: builtinFilter}},
+	{"reduce", &Builtin{Arity: 3, Fn: builtinReduce}},
+	{"each", &Builtin{Arity: 2, Fn: builtinEach}},
+	{"sort", &Builtin{Arity: VariadicArity, Fn: builtinSort}},
+	{"sort_by", &Builtin{Arity: 2, Fn: builtinSortBy}},
+	{"try", &Builtin{Arity: 2, Fn: builtinTry}},
+	// strings, join, contains and index_of above take strings as well
+	{"split", &Builtin{Arity: 2, Fn: builtinSplit}},
+	{"trim", &Builtin{Arity: 1, Fn: stringFunction("trim", strings.TrimSpace)}},
+	{"upper", &Builtin{Arity: 1, Fn: stringFunction("upper", strings.ToUpper)}},
+	{"lower", &Builtin{Arity: 1, Fn: stringFunction("lower", strings.ToLower)}},
+	{"replace", &Builtin{Arity: 3, Fn: builtinReplace}},
+	{"starts_with", &Builtin{Arity: 2, Fn: stringPredicate("starts_with", strings.HasPrefix)}},
+	{"ends_with", &Builtin{Arity: 2, Fn: stringPredicate("ends_with", strings.HasSuffix)}},
+	{"repeat", &Builtin{Arity: 2, Fn: builtinRepeat}},
+	{"chars", &Builtin{Arity: 1, Fn: builtinChars}},
+	{"ord", &Builtin{Arity: 1, Fn: builtinOrd}},
+	{"chr", &Builtin{Arity: 1, Fn: builtinChr}},
+	{"pad_left", &Builtin{Arity: VariadicArity, Fn: padFunction("pad_left", true)}},
+	{"pad_right", &Builtin{Arity: VariadicArity, Fn: padFunction("pad_right", false)}},
+	{"format", &Builtin{Arity: VariadicArity, Fn: builtinFormat}},
+	// math, PI and E are in Constants
+	{"abs", &Builtin{Arity: 1, Fn: builtinAbs}},
+	{"min", &Builtin{Arity: VariadicArity, Fn: extremeFunction("min", -1)}},
+	{"max", &Builtin{Arity: VariadicArity, Fn: extremeFunction("max", 1)}},
+	{"pow", &Builtin{Arity: 2, Fn: builtinPow}},
+	{"sqrt", &Builtin{Arity: 1, Fn: builtinSqrt}},
+	{"floor", &Builtin{Arity: 1, Fn: roundFunction("floor", math.Floor)}},
+	{"ceil", &Builtin{Arity: 1, Fn: roundFunction("ceil", math.Ceil)}},
+	{"round", &Builtin{Arity: 1, Fn: roundFunction("round", math.Round)}},
+	{"clamp", &Builtin{Arity: 3, Fn: builtinClamp}},
+	{"gcd", &Builtin{Arity: 2, Fn: builtinGcd}},
+	{"sin", &Builtin{Arity: 1, Fn: floatFunction("sin", math.Sin)}},
+	{"cos", &Builtin{Arity: 1, Fn: floatFunction("cos", math.Cos)}},
+	{"tan", &Builtin{Arity: 1, Fn: floatFunction("tan", math.Tan)}},
+	{"asin", &Builtin{Arity: 1, Fn: floatFunction("asin", math.Asin)}},
+	{"acos", &Builtin{Arity: 1, Fn: floatFunction("acos", math.Acos)}},
+	{"atan", &Builtin{Arity: VariadicArity, Fn: builtinAtan}},
+	{"random", &Builtin{Arity: 0, Fn: builtinRandom}},
+	{"random_int", &Builtin{Arity: 2, Fn: builtinRandomInt}},
+	{"random_seed", &Builtin{Arity: 1, Fn: builtinRandomSeed}},
+	// json
+	{"json_parse", &Builtin{Arity: 1, Fn: builtinJSONParse}},
+	{"json_stringify", &Builtin{Arity: VariadicArity, Fn: builtinJSONStringify}},
+	// types
+	{"type", &Builtin{Arity: 1, Fn: builtinType}},
+	{"str", &Builtin{Arity: 1, Fn: builtinStr}},
+	{"int", &Builtin{Arity: 1, Fn: builtinInt}},
+	{"bool", &Builtin{Arity: 1, Fn: builtinBool}},
+	{"arity", &Builtin{Arity: 1, Fn: builtinArity}},
 }
 
 func newError(format string, a ...interface{}) *Error {
@@ -0,0 +1,464 @@
+package object
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+// The builtins for hashes and arrays. Like push they never modify their arguments,
+// arrays and hashes can be hash keys, so anything that changes one returns a new one.
+
+// maxRangeLength bounds the arrays range builds even without a memory limit, the longest takes some hundred megabytes
+const maxRangeLength = 1 << 24
+
+// checkArity returns the error of a builtin called with another number of arguments than it takes, nil otherwise
+func checkArity(args []Object, min, max int) *Error {
+	if len(args) < min || len(args) > max {
+		if min == max {
+			return newError("wrong number of arguments. got=%d, want=%d", len(args), min)
+		}
+		return newError("wrong number of arguments. got=%d, want=%d to %d", len(args), min, max)
+	}
+	return nil
+}
+
+func hashArgument(name string, arg Object) (*Hash, *Error) {
+	hash, ok := arg.(*Hash)
+	if !ok {
+		return nil, newError("argument to `%s` must be HASH, got %s", name, arg.Type())
+	}
+	return hash, nil
+}
+
+func arrayArgument(name string, arg Object) (*Array, *Error) {
+	array, ok := arg.(*Array)
+	if !ok {
+		return nil, newError("argument to `%s` must be ARRAY, got %s", name, arg.Type())
+	}
+	return array, nil
+}
+
+func integerArgument(name string, arg Object) (int64, *Error) {
+	integer, ok := arg.(*Integer)
+	if !ok {
+		return 0, newError("argument to `%s` must be INTEGER, got %s", name, arg.Type())
+	}
+	return integer.Value, nil
+}
+
+func keyArgument(arg Object) (Hashable, *Error) {
+	key, ok := AsHashable(arg)
+	if !ok {
+		return nil, newError("unusable as hash key: %s", arg.Type())
+	}
+	return key, nil
+}
+
+// newArray accounts and returns an array of elements
+func newArray(ctx CallContext, elements []Object) Object {
+	if err := allocate(ctx, ArraySize(len(elements))); err != nil {
+		return err
+	}
+	return &Array{Elements: elements}
+}
+
+// keys(hash) returns the keys of hash in order
+func builtinKeys(ctx CallContext, args ...Object) Object {
+	if err := checkArity(args, 1, 1); err != nil {
+		return err
+	}
+	hash, err := hashArgument("keys", args[0])
+	if err != nil {
+		return err
+	}
+	keys := make([]Object, 0, hash.Len())
+	for _, pair := range hash.Pairs() {
+		keys = append(keys, pair.Key)
+	}
+	return newArray(ctx, keys)
+}
+
+// values(hash) returns the values of hash in the order of their keys
+func builtinValues(ctx CallContext, args ...Object) Object {
+	if err := checkArity(args, 1, 1); err != nil {
+		return err
+	}
+	hash, err := hashArgument("values", args[0])
+	if err != nil {
+		return err
+	}
+	values := make([]Object, 0, hash.Len())
+	for _, pair := range hash.Pairs() {
+		values = append(values, pair.Value)
+	}
+	return newArray(ctx, values)
+}
+
+// entries(hash) returns the pairs of hash as [key, value] arrays
+func builtinEntries(ctx CallContext, args ...Object) Object {
+	if err := checkArity(args, 1, 1); err != nil {
+		return err
+	}
+	hash, err := hashArgument("entries", args[0])
+	if err != nil {
+		return err
+	}
+	if err := allocate(ctx, ArraySize(hash.Len())+int64(hash.Len())*ArraySize(2)); err != nil {
+		return err
+	}
+	entries := make([]Object, 0, hash.Len())
+	for _, pair := range hash.Pairs() {
+		entries = append(entries, &Array{Elements: []Object{pair.Key, pair.Value}})
+	}
+	return &Array{Elements: entries}
+}
+
+// has(hash, key) reports whether hash contains key
+func builtinHas(ctx CallContext, args ...Object) Object {
+	if err := checkArity(args, 2, 2); err != nil {
+		return err
+	}
+	hash, err := hashArgument("has", args[0])
+	if err != nil {
+		return err
+	}
+	key, err := keyArgument(args[1])
+	if err != nil {
+		return err
+	}
+	_, ok := hash.Get(key)
+	return nativeBool(ok)
+}
+
+// delete(hash, key) returns a copy of hash without key
+func builtinDelete(ctx CallContext, args ...Object) Object {
+	if err := checkArity(args, 2, 2); err != nil {
+		return err
+	}
+	hash, err := hashArgument("delete", args[0])
+	if err != nil {
+		return err
+	}
+	key, err := keyArgument(args[1])
+	if err != nil {
+		return err
+	}
+	if err := allocate(ctx, HashSize(hash.Len())); err != nil {
+		return err
+	}
+	result := NewHash(hash.Len())
+	for _, pair := range hash.Pairs() {
+		if !Equal(pair.Key, key) {
+			result.Set(pair.Key.(Hashable), pair.Value)
+		}
+	}
+	return result
+}
+
+// merge(hash, ...) returns a hash of the pairs of all its arguments, the later hashes win for keys they share
+func builtinMerge(ctx CallContext, args ...Object) Object {
+	if len(args) == 0 {
+		return newError("wrong number of arguments. got=0, want at least 1")
+	}
+	size := 0
+	for _, arg := range args {
+		hash, err := hashArgument("merge", arg)
+		if err != nil {
+			return err
+		}
+		size += hash.Len()
+	}
+	if err := allocate(ctx, HashSize(size)); err != nil {
+		return err
+	}
+	result := NewHash(size)
+	for _, arg := range args {
+		for _, pair := range arg.(*Hash).Pairs() {
+			result.Set(pair.Key.(Hashable), pair.Value)
+		}
+	}
+	return result
+}
+
+// slice(array, start, end) is array[start:end], end may be left out
+func builtinSlice(ctx CallContext, args ...Object) Object {
+	if err := checkArity(args, 2, 3); err != nil {
+		return err
+	}
+	if args[0].Type() != ArrayObj && args[0].Type() != StringObj {
+		return newError("argument to `slice` must be ARRAY or STRING, got %s", args[0].Type())
+	}
+	end := Object(NULL)
+	if len(args) == 3 {
+		end = args[2]
+	}
+	result, sliceErr := Slice(args[0], args[1], end)
+	if sliceErr != nil {
+		return newError("%s", sliceErr)
+	}
+	switch result := result.(type) {
+	case *Array:
+		if err := allocate(ctx, ArraySize(len(result.Elements))); err != nil {
+			return err
+		}
+	case *String:
+		if err := allocate(ctx, StringSize(len(result.Value))); err != nil {
+			return err
+		}
+	}
+	return result
+}
+
+// concat(array, ...) returns the elements of all its arguments in one array
+func builtinConcat(ctx CallContext, args ...Object) Object {
+	if len(args) == 0 {
+		return newError("wrong number of arguments. got=0, want at least 1")
+	}
+	length := 0
+	for _, arg := range args {
+		array, err := arrayArgument("concat", arg)
+		if err != nil {
+			return err
+		}
+		length += len(array.Elements)
+	}
+	elements := make([]Object, 0, length)
+	for _, arg := range args {
+		elements = append(elements, arg.(*Array).Elements...)
+	}
+	return newArray(ctx, elements)
+}
+
+// reverse(array) returns the elements of array in reverse order
+func builtinReverse(ctx CallContext, args ...Object) Object {
+	if err := checkArity(args, 1, 1); err != nil {
+		return err
+	}
+	array, err := arrayArgument("reverse", args[0])
+	if err != nil {
+		return err
+	}
+	length := len(array.Elements)
+	elements := make([]Object, length)
+	for i, e := range array.Elements {
+		elements[length-1-i] = e
+	}
+	return newArray(ctx, elements)
+}
+
+// index_of(array, value) returns the index of the first element equal to value, -1 if there is none.
+// index_of(s, substring) returns the index of the first character of substring in s.
+func builtinIndexOf(ctx CallContext, args ...Object) Object {
+	if err := checkArity(args, 2, 2); err != nil {
+		return err
+	}
+	if str, ok := args[0].(*String); ok {
+		substring, err := stringArgument("index_of", args[1])
+		if err != nil {
+			return err
+		}
+		i := strings.Index(str.Value, substring)
+		if i < 0 {
+			return NewInteger(-1)
+		}
+		return NewInteger(int64(utf8.RuneCountInString(str.Value[:i])))
+	}
+	array, err := arrayArgument("index_of", args[0])
+	if err != nil {
+		return err
+	}
+	for i, e := range array.Elements {
+		if Equal(e, args[1]) {
+			return NewInteger(int64(i))
+		}
+	}
+	return NewInteger(-1)
+}
+
+// contains(array, value) reports whether an element of array is equal to value,
+// contains(s, substring) whether substring is part of s
+func builtinContains(ctx CallContext, args ...Object) Object {
+	if err := checkArity(args, 2, 2); err != nil {
+		return err
+	}
+	if str, ok := args[0].(*String); ok {
+		substring, err := stringArgument("contains", args[1])
+		if err != nil {
+			return err
+		}
+		return nativeBool(strings.Contains(str.Value, substring))
+	}
+	array, err := arrayArgument("contains", args[0])
+	if err != nil {
+		return err
+	}
+	for _, e := range array.Elements {
+		if Equal(e, args[1]) {
+			return TRUE
+		}
+	}
+	return FALSE
+}
+
+// join(array, separator) returns the elements of array as they print, separated by separator
+func builtinJoin(ctx CallContext, args ...Object) Object {
+	if err := checkArity(args, 2, 2); err != nil {
+		return err
+	}
+	array, err := arrayArgument("join", args[0])
+	if err != nil {
+		return err
+	}
+	separator, ok := args[1].(*String)
+	if !ok {
+		return newError("argument to `join` must be STRING, got %s", args[1].Type())
+	}
+	parts := make([]string, len(array.Elements))
+	for i, e := range array.Elements {
+		parts[i] = e.Inspect()
+	}
+	joined := strings.Join(parts, separator.Value)
+	if err := allocate(ctx, StringSize(len(joined))); err != nil {
+		return err
+	}
+	return &String{Value: joined}
+}
+
+// range(end), range(start, end) and range(start, end, step) return the integers from start, 0 if it's
+// left out, up to but not including end, step apart. step is 1 if it's left out and may be negative.
+func builtinRange(ctx CallContext, args ...Object) Object {
+	if err := checkArity(args, 1, 3); err != nil {
+		return err
+	}
+	bounds := []int64{0, 0, 1}
+	if len(args) == 1 {
+		args = []Object{NewInteger(0), args[0]}
+	}
+	for i, arg := range args {
+		value, err := integerArgument("range", arg)
+		if err != nil {
+			return err
+		}
+		bounds[i] = value
+	}
+	start, end, step := bounds[0], bounds[1], bounds[2]
+	if step == 0 {
+		return newError("step of `range` must not be 0")
+	}
+	var length uint64
+	switch {
+	case step > 0 && end > start:
+		length = (uint64(end-start) + uint64(step) - 1) / uint64(step)
+	case step < 0 && start > end:
+		length = (uint64(start-end) + uint64(-step) - 1) / uint64(-step)
+	}
+	if length > maxRangeLength {
+		return newError("range of %d integers is too long", length)
+	}
+	if err := allocate(ctx, ArraySize(int(length))); err != nil {
+		return err
+	}
+	elements := make([]Object, length)
+	for i := range elements {
+		elements[i] = NewInteger(start + int64(i)*step)
+	}
+	return &Array{Elements: elements}
+}
+
+// zip(array, ...) returns arrays of the elements at the same index of its arguments, as many as the shortest has
+func builtinZip(ctx CallContext, args ...Object) Object {
+	if len(args) == 0 {
+		return newError("wrong number of arguments. got=0, want at least 1")
+	}
+	length := -1
+	for _, arg := range args {
+		array, err := arrayArgument("zip", arg)
+		if err != nil {
+			return err
+		}
+		if length == -1 || len(array.Elements) < length {
+			length = len(array.Elements)
+		}
+	}
+	if err := allocate(ctx, ArraySize(length)+int64(length)*ArraySize(len(args))); err != nil {
+		return err
+	}
+	tuples := make([]Object, length)
+	for i := range tuples {
+		tuple := make([]Object, len(args))
+		for j, arg := range args {
+			tuple[j] = arg.(*Array).Elements[i]
+		}
+		tuples[i] = &Array{Elements: tuple}
+	}
+	return &Array{Elements: tuples}
+}
+
+// flatten(array) replaces the arrays among the elements of array with their elements, one level deep
+func builtinFlatten(ctx CallContext, args ...Object) Object {
+	if err := checkArity(args, 1, 1); err != nil {
+		return err
+	}
+	array, err := arrayArgument("flatten", args[0])
+	if err != nil {
+		return err
+	}
+	var elements []Object
+	for _, e := range array.Elements {
+		if inner, ok := e.(*Array); ok {
+			elements = append(elements, inner.Elements...)
+		} else {
+			elements = append(elements, e)
+		}
+	}
+	if elements == nil {
+		elements = []Object{}
+	}
+	return newArray(ctx, elements)
+}
+
+// unique(array) returns the elements of array without those equal to an earlier one
+func builtinUnique(ctx CallContext, args ...Object) Object {
+	if err := checkArity(args, 1, 1); err != nil {
+		return err
+	}
+	array, err := arrayArgument("unique", args[0])
+	if err != nil {
+		return err
+	}
+	seen := NewHash(len(array.Elements)) // the hashable elements, the others are compared one by one
+	var unhashable []Object              // the kept elements that aren't hashable, a hashable one may equal them
+	elements := make([]Object, 0, len(array.Elements))
+	for _, e := range array.Elements {
+		if key, ok := AsHashable(e); ok {
+			if _, found := seen.Get(key); found || containsEqual(unhashable, e) {
+				continue
+			}
+			seen.Set(key, TRUE)
+			elements = append(elements, e)
+			continue
+		}
+		if !containsEqual(elements, e) {
+			unhashable = append(unhashable, e)
+			elements = append(elements, e)
+		}
+	}
+	return newArray(ctx, elements)
+}
+
+// containsEqual reports whether one of objects is equal to obj
+func containsEqual(objects []Object, obj Object) bool {
+	for _, o := range objects {
+		if Equal(o, obj) {
+			return true
+		}
+	}
+	return false
+}
+
+func nativeBool(value bool) *Boolean {
+	if value {
+		return TRUE
+	}
+	return FALSE
+}
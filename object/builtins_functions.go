@@ -0,0 +1,285 @@
+package object
+
+import (
+	"errors"
+	"sort"
+)
+
+// The builtins that call back into functions of the script. They run the functions on the engine
+// that called them, so they need a CallContext. If a function fails the engine stops the script,
+// the error the builtin returns is only seen by host code calling the builtin.
+
+// callArgument calls fn with args on the engine of ctx
+func callArgument(ctx CallContext, name string, fn Object, args ...Object) (Object, *Error) {
+	if ctx == nil {
+		return nil, newError("`%s` can't call functions without an engine", name)
+	}
+	result, err := ctx.Call(fn, args...)
+	if err != nil {
+		return nil, newError("%s", err)
+	}
+	if errObj, ok := result.(*Error); ok { // a builtin failed, the evaluator would have reported it as an error too
+		return nil, errObj
+	}
+	return result, nil
+}
+
+func functionArgument(name string, arg Object) *Error {
+	switch arg.(type) {
+	case *Function, *Closure, *Builtin:
+		return nil
+	}
+	return newError("argument to `%s` must be a function, got %s", name, arg.Type())
+}
+
+// map(array, fn) returns the results of fn for every element of array
+func builtinMap(ctx CallContext, args ...Object) Object {
+	if err := checkArity(args, 2, 2); err != nil {
+		return err
+	}
+	array, err := arrayArgument("map", args[0])
+	if err != nil {
+		return err
+	}
+	if err := functionArgument("map", args[1]); err != nil {
+		return err
+	}
+	results := make([]Object, len(array.Elements))
+	for i, e := range array.Elements {
+		results[i], err = callArgument(ctx, "map", args[1], e)
+		if err != nil {
+			return err
+		}
+	}
+	return newArray(ctx, results)
+}
+
+// filter(array, fn) returns the elements of array fn returns a truthy value for, like a condition would take it
+func builtinFilter(ctx CallContext, args ...Object) Object {
+	if err := checkArity(args, 2, 2); err != nil {
+		return err
+	}
+	array, err := arrayArgument("filter", args[0])
+	if err != nil {
+		return err
+	}
+	if err := functionArgument("filter", args[1]); err != nil {
+		return err
+	}
+	elements := []Object{}
+	for _, e := range array.Elements {
+		keep, err := callArgument(ctx, "filter", args[1], e)
+		if err != nil {
+			return err
+		}
+		if IsTruthy(keep) {
+			elements = append(elements, e)
+		}
+	}
+	return newArray(ctx, elements)
+}
+
+// reduce(array, initial, fn) folds array into one value: fn is called with the value so far,
+// initial for the first element, and the element, its result is the value for the next element
+func builtinReduce(ctx CallContext, args ...Object) Object {
+	if err := checkArity(args, 3, 3); err != nil {
+		return err
+	}
+	array, err := arrayArgument("reduce", args[0])
+	if err != nil {
+		return err
+	}
+	if err := functionArgument("reduce", args[2]); err != nil {
+		return err
+	}
+	accumulator := args[1]
+	for _, e := range array.Elements {
+		accumulator, err = callArgument(ctx, "reduce", args[2], accumulator, e)
+		if err != nil {
+			return err
+		}
+	}
+	return accumulator
+}
+
+// each(array, fn) calls fn for every element of array and returns null
+func builtinEach(ctx CallContext, args ...Object) Object {
+	if err := checkArity(args, 2, 2); err != nil {
+		return err
+	}
+	array, err := arrayArgument("each", args[0])
+	if err != nil {
+		return err
+	}
+	if err := functionArgument("each", args[1]); err != nil {
+		return err
+	}
+	for _, e := range array.Elements {
+		if _, err := callArgument(ctx, "each", args[1], e); err != nil {
+			return err
+		}
+	}
+	return NULL
+}
+
+// sort(array) returns the elements of array in ascending order, they have to be all numbers or all strings.
+// sort(array, fn) orders them by fn instead, fn(a, b) returns true if a comes before b.
+// The sort is stable, equal elements keep their order.
+func builtinSort(ctx CallContext, args ...Object) Object {
+	if err := checkArity(args, 1, 2); err != nil {
+		return err
+	}
+	array, err := arrayArgument("sort", args[0])
+	if err != nil {
+		return err
+	}
+	var less func(a, b Object) (bool, *Error)
+	if len(args) == 2 {
+		if err := functionArgument("sort", args[1]); err != nil {
+			return err
+		}
+		less = func(a, b Object) (bool, *Error) {
+			result, err := callArgument(ctx, "sort", args[1], a, b)
+			if err != nil {
+				return false, err
+			}
+			if result.Type() != BooleanObj {
+				return false, newError("function of `sort` must return BOOLEAN, got %s", result.Type())
+			}
+			return result == TRUE, nil
+		}
+	} else {
+		less = func(a, b Object) (bool, *Error) {
+			order, err := compareNatural("sort", a, b)
+			return order < 0, err
+		}
+	}
+	elements := make([]Object, len(array.Elements))
+	copy(elements, array.Elements)
+	if err := stableSort(elements, less); err != nil {
+		return err
+	}
+	return newArray(ctx, elements)
+}
+
+// sort_by(array, fn) returns the elements of array ordered by the results of fn for them,
+// which have to be all numbers or all strings. The sort is stable and calls fn once per element.
+func builtinSortBy(ctx CallContext, args ...Object) Object {
+	if err := checkArity(args, 2, 2); err != nil {
+		return err
+	}
+	array, err := arrayArgument("sort_by", args[0])
+	if err != nil {
+		return err
+	}
+	if err := functionArgument("sort_by", args[1]); err != nil {
+		return err
+	}
+	type keyed struct {
+		key, element Object
+	}
+	pairs := make([]keyed, len(array.Elements))
+	for i, e := range array.Elements {
+		key, err := callArgument(ctx, "sort_by", args[1], e)
+		if err != nil {
+			return err
+		}
+		pairs[i] = keyed{key, e}
+	}
+	var sortErr *Error
+	sort.SliceStable(pairs, func(i, j int) bool {
+		if sortErr != nil {
+			return false
+		}
+		order, err := compareNatural("sort_by", pairs[i].key, pairs[j].key)
+		sortErr = err
+		return order < 0
+	})
+	if sortErr != nil {
+		return sortErr
+	}
+	elements := make([]Object, len(pairs))
+	for i, p := range pairs {
+		elements[i] = p.element
+	}
+	return newArray(ctx, elements)
+}
+
+// stableSort sorts elements with less, it stops comparing at the first error and returns it
+func stableSort(elements []Object, less func(a, b Object) (bool, *Error)) *Error {
+	var sortErr *Error
+	sort.SliceStable(elements, func(i, j int) bool {
+		if sortErr != nil {
+			return false
+		}
+		result, err := less(elements[i], elements[j])
+		sortErr = err
+		return result
+	})
+	return sortErr
+}
+
+// compareNatural returns -1, 0 or 1 as a is less than, equal to or greater than b. Numbers compare
+// with numbers and strings with strings, name is the builtin whose error it is for anything else.
+func compareNatural(name string, a, b Object) (int, *Error) {
+	switch a := a.(type) {
+	case *Integer:
+		switch b := b.(type) {
+		case *Integer:
+			return compareOrdered(a.Value, b.Value), nil
+		case *Float:
+			return compareOrdered(float64(a.Value), b.Value), nil
+		}
+	case *Float:
+		switch b := b.(type) {
+		case *Float:
+			return compareOrdered(a.Value, b.Value), nil
+		case *Integer:
+			return compareOrdered(a.Value, float64(b.Value)), nil
+		}
+	case *String:
+		if b, ok := b.(*String); ok {
+			return compareOrdered(a.Value, b.Value), nil
+		}
+	}
+	return 0, newError("`%s` can't compare %s and %s", name, a.Type(), b.Type())
+}
+
+func compareOrdered[T int64 | float64 | string](a, b T) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+// try(fn, handler) returns the result of calling fn. If the call exceeds the memory limit, the engine forgets
+// what the call allocated and try returns the result of calling handler with the message of the error instead.
+// Other errors stop the script as they do without try.
+func builtinTry(ctx CallContext, args ...Object) Object {
+	if err := checkArity(args, 2, 2); err != nil {
+		return err
+	}
+	for _, arg := range args {
+		if err := functionArgument("try", arg); err != nil {
+			return err
+		}
+	}
+	if ctx == nil {
+		return newError("`try` can't call functions without an engine")
+	}
+	result, err := ctx.TryCall(args[0])
+	if errors.Is(err, ErrMemoryLimitExceeded) {
+		result, errObj := callArgument(ctx, "try", args[1], &String{Value: err.Error()})
+		if errObj != nil {
+			return errObj
+		}
+		return result
+	}
+	if err != nil {
+		return newError("%s", err)
+	}
+	return result
+}
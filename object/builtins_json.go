@@ -0,0 +1,317 @@
+package object
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// The builtins for JSON. json_parse keeps the keys of objects in the order of the document, json_stringify
+// writes them sorted, so parsing and stringifying a document may change the order of its keys as well as
+// its whitespace.
+
+// maxJSONDepth bounds how deep arrays and objects may nest in json_parse and json_stringify
+const maxJSONDepth = 1000
+
+// json_parse(s) returns the value of the JSON document s. Objects become hashes with string keys
+// in the order of the document, numbers integers if they are whole and fit into one, floats otherwise.
+func builtinJSONParse(ctx CallContext, args ...Object) Object {
+	if err := checkArity(args, 1, 1); err != nil {
+		return err
+	}
+	str, err := stringArgument("json_parse", args[0])
+	if err != nil {
+		return err
+	}
+	d := &jsonDecoder{ctx: ctx, dec: json.NewDecoder(strings.NewReader(str))}
+	d.dec.UseNumber()
+	value, err := d.value()
+	if err != nil {
+		return err
+	}
+	if _, tokenErr := d.dec.Token(); tokenErr != io.EOF {
+		return newError("invalid JSON: unexpected data after the value")
+	}
+	return value
+}
+
+type jsonDecoder struct {
+	ctx   CallContext
+	dec   *json.Decoder
+	depth int
+}
+
+func (d *jsonDecoder) token() (json.Token, *Error) {
+	token, err := d.dec.Token()
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return nil, newError("invalid JSON: unexpected end of JSON input")
+	}
+	if err != nil {
+		return nil, newError("invalid JSON: %s", err)
+	}
+	return token, nil
+}
+
+func (d *jsonDecoder) value() (Object, *Error) {
+	token, err := d.token()
+	if err != nil {
+		return nil, err
+	}
+	switch token := token.(type) {
+	case json.Delim:
+		if d.depth == maxJSONDepth {
+			return nil, newError("invalid JSON: nested deeper than %d levels", maxJSONDepth)
+		}
+		d.depth++
+		defer func() { d.depth-- }()
+		if token == '[' {
+			return d.array()
+		}
+		return d.object()
+	case string:
+		if err := allocate(d.ctx, StringSize(len(token))); err != nil {
+			return nil, err
+		}
+		return &String{Value: token}, nil
+	case json.Number:
+		if integer, err := strconv.ParseInt(string(token), 10, 64); err == nil {
+			return NewInteger(integer), nil
+		}
+		float, err := strconv.ParseFloat(string(token), 64)
+		if err != nil {
+			return nil, newError("invalid JSON: number %s is out of range", token)
+		}
+		return &Float{Value: float}, nil
+	case bool:
+		return nativeBool(token), nil
+	}
+	return NULL, nil
+}
+
+// array reads the elements of an array whose [ was read already
+func (d *jsonDecoder) array() (Object, *Error) {
+	elements := []Object{}
+	for d.dec.More() {
+		element, err := d.value()
+		if err != nil {
+			return nil, err
+		}
+		elements = append(elements, element)
+	}
+	if _, err := d.token(); err != nil {
+		return nil, err
+	}
+	if err := allocate(d.ctx, ArraySize(len(elements))); err != nil {
+		return nil, err
+	}
+	return &Array{Elements: elements}, nil
+}
+
+// object reads the members of an object whose { was read already. A key that appears twice keeps its first position
+// and its last value.
+func (d *jsonDecoder) object() (Object, *Error) {
+	hash := NewHash(0)
+	for d.dec.More() {
+		key, err := d.token()
+		if err != nil {
+			return nil, err
+		}
+		value, err := d.value()
+		if err != nil {
+			return nil, err
+		}
+		hash.Set(&String{Value: key.(string)}, value)
+	}
+	if _, err := d.token(); err != nil {
+		return nil, err
+	}
+	if err := allocate(d.ctx, HashSize(hash.Len())); err != nil {
+		return nil, err
+	}
+	return hash, nil
+}
+
+// json_stringify(value, indent) returns value as JSON. Hashes need string keys and are written with their keys
+// sorted, so equal hashes give the same JSON whatever order their keys were set in. Functions and errors can't be
+// written at all. Without indent, or with 0, the JSON is on one line,
+// otherwise every element is on a line of its own, indented by indent spaces per level.
+// A string indent is used as it is, it may be as long as an indent of spaces.
+func builtinJSONStringify(ctx CallContext, args ...Object) Object {
+	if err := checkArity(args, 1, 2); err != nil {
+		return err
+	}
+	e := &jsonEncoder{ctx: ctx, visiting: map[*Hash]bool{}}
+	if len(args) == 2 {
+		switch indent := args[1].(type) {
+		case *Integer:
+			if indent.Value < 0 || indent.Value > maxJSONIndent {
+				return newError("indent of `json_stringify` must be between 0 and %d, got %d", maxJSONIndent, indent.Value)
+			}
+			e.indent = strings.Repeat(" ", int(indent.Value))
+		case *String:
+			if length := utf8.RuneCountInString(indent.Value); length > maxJSONIndent {
+				return newError("indent of `json_stringify` must be at most %d characters, got %d", maxJSONIndent, length)
+			}
+			e.indent = indent.Value
+		default:
+			return newError("indent of `json_stringify` must be INTEGER or STRING, got %s", args[1].Type())
+		}
+	}
+	if err := e.write(args[0], 0); err != nil {
+		return err
+	}
+	if err := e.account(true); err != nil {
+		return err
+	}
+	if err := allocate(ctx, StringSize(0)); err != nil {
+		return err
+	}
+	return &String{Value: e.out.String()}
+}
+
+// maxJSONIndent bounds the spaces json_stringify indents by
+const maxJSONIndent = 16
+
+// jsonAccountChunk is how many bytes json_stringify writes between accounting them
+const jsonAccountChunk = 4096
+
+type jsonEncoder struct {
+	ctx    CallContext
+	out    strings.Builder
+	indent string
+	// accounted is how much of out is accounted already
+	accounted int
+	// visiting holds the hashes being written, a hash containing itself can't be written
+	visiting map[*Hash]bool
+}
+
+func (e *jsonEncoder) write(obj Object, depth int) *Error {
+	if depth > maxJSONDepth {
+		return newError("can't convert to JSON: nested deeper than %d levels", maxJSONDepth)
+	}
+	if err := e.account(false); err != nil {
+		return err
+	}
+	switch obj := obj.(type) {
+	case *Integer:
+		e.out.WriteString(strconv.FormatInt(obj.Value, 10))
+	case *Float:
+		if math.IsNaN(obj.Value) || math.IsInf(obj.Value, 0) {
+			return newError("can't convert %s to JSON", obj.Inspect())
+		}
+		e.out.WriteString(strconv.FormatFloat(obj.Value, 'g', -1, 64))
+	case *String:
+		e.writeString(obj.Value)
+	case *Boolean:
+		e.out.WriteString(strconv.FormatBool(obj.Value))
+	case *Null:
+		e.out.WriteString("null")
+	case *Array:
+		e.out.WriteByte('[')
+		for i, element := range obj.Elements {
+			if i > 0 {
+				e.out.WriteByte(',')
+			}
+			e.newline(depth + 1)
+			if err := e.write(element, depth+1); err != nil {
+				return err
+			}
+		}
+		if len(obj.Elements) > 0 {
+			e.newline(depth)
+		}
+		e.out.WriteByte(']')
+	case *Hash:
+		if e.visiting[obj] {
+			return newError("can't convert to JSON: hash contains itself")
+		}
+		e.visiting[obj] = true
+		defer delete(e.visiting, obj)
+		pairs := make([]HashPair, obj.Len())
+		copy(pairs, obj.Pairs())
+		for _, pair := range pairs {
+			if _, ok := pair.Key.(*String); !ok {
+				return newError("can't convert to JSON: hash key must be STRING, got %s", pair.Key.Type())
+			}
+		}
+		sort.Slice(pairs, func(i, j int) bool {
+			return pairs[i].Key.(*String).Value < pairs[j].Key.(*String).Value
+		})
+		e.out.WriteByte('{')
+		for i, pair := range pairs {
+			if i > 0 {
+				e.out.WriteByte(',')
+			}
+			e.newline(depth + 1)
+			e.writeString(pair.Key.(*String).Value)
+			e.out.WriteByte(':')
+			if e.indent != "" {
+				e.out.WriteByte(' ')
+			}
+			if err := e.write(pair.Value, depth+1); err != nil {
+				return err
+			}
+		}
+		if obj.Len() > 0 {
+			e.newline(depth)
+		}
+		e.out.WriteByte('}')
+	default:
+		return newError("can't convert %s to JSON", obj.Type())
+	}
+	return nil
+}
+
+// account accounts the output written since the last call once it has grown by a chunk, or with final all of it
+func (e *jsonEncoder) account(final bool) *Error {
+	grown := e.out.Len() - e.accounted
+	if grown < jsonAccountChunk && !final {
+		return nil
+	}
+	if e.out.Len() > maxStringLength {
+		return newError("result of `json_stringify` is too long")
+	}
+	e.accounted = e.out.Len()
+	return allocate(e.ctx, int64(grown))
+}
+
+// newline starts the line of an element at depth, if the JSON is indented
+func (e *jsonEncoder) newline(depth int) {
+	if e.indent == "" {
+		return
+	}
+	e.out.WriteByte('\n')
+	for i := 0; i < depth; i++ {
+		e.out.WriteString(e.indent)
+	}
+}
+
+// writeString writes s quoted, bytes that aren't UTF-8 become the replacement character
+func (e *jsonEncoder) writeString(s string) {
+	e.out.WriteByte('"')
+	for _, r := range s {
+		switch {
+		case r == '"' || r == '\\':
+			e.out.WriteByte('\\')
+			e.out.WriteRune(r)
+		case r == '\n':
+			e.out.WriteString(`\n`)
+		case r == '\r':
+			e.out.WriteString(`\r`)
+		case r == '\t':
+			e.out.WriteString(`\t`)
+		case r < 0x20:
+			e.out.WriteString(`\u00`)
+			e.out.WriteByte("0123456789abcdef"[r>>4])
+			e.out.WriteByte("0123456789abcdef"[r&0xf])
+		default:
+			e.out.WriteRune(r)
+		}
+	}
+	e.out.WriteByte('"')
+}
@@ -0,0 +1,131 @@
+package object
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestJSONRoundTrip(t *testing.T) {
+	documents := []string{
+		`{"a":[true,false,null],"m":{"x":"y"},"z":1}`,
+		`[1.5,-2,1e+30,"\"quoted\"\\","tab\tand\u0001"]`,
+		`"héllo"`,
+		`{}`,
+		`[[],{}]`,
+		`9223372036854775807`,
+	}
+	for _, document := range documents {
+		parsed := builtinJSONParse(nil, &String{Value: document})
+		if err, ok := parsed.(*Error); ok {
+			t.Errorf("parsing %s failed: %s", document, err.Message)
+			continue
+		}
+		stringified := builtinJSONStringify(nil, parsed)
+		str, ok := stringified.(*String)
+		if !ok {
+			t.Errorf("stringifying %s failed: %s", document, stringified.Inspect())
+			continue
+		}
+		if str.Value != document {
+			t.Errorf("round trip changed the document. want=%s, got=%s", document, str.Value)
+		}
+		if again := builtinJSONParse(nil, str); !Equal(again, parsed) {
+			t.Errorf("parsing %s again gave %s, want %s", str.Value, again.Inspect(), parsed.Inspect())
+		}
+	}
+}
+
+func TestJSONErrors(t *testing.T) {
+	cyclic := NewHash(1)
+	cyclic.Set(&String{Value: "self"}, &Array{Elements: []Object{cyclic}})
+	if result := builtinJSONStringify(nil, cyclic); !isError(result, "can't convert to JSON: hash contains itself") {
+		t.Errorf("wrong result for a cyclic hash: %s", result.Inspect())
+	}
+	unordered := builtinJSONParse(nil, &String{Value: `{"z":1,"a":{"y":2,"x":3}}`})
+	if result := builtinJSONStringify(nil, unordered); result.Inspect() != `{"a":{"x":3,"y":2},"z":1}` {
+		t.Errorf("keys not sorted: %s", result.Inspect())
+	}
+	shared := &Array{}
+	twice := &Array{Elements: []Object{shared, shared}}
+	if result := builtinJSONStringify(nil, twice); result.Inspect() != "[[],[]]" {
+		t.Errorf("wrong result for a shared array: %s", result.Inspect())
+	}
+	deep := strings.Repeat("[", maxJSONDepth+1) + strings.Repeat("]", maxJSONDepth+1)
+	if result := builtinJSONParse(nil, &String{Value: deep}); !isError(result, "invalid JSON: nested deeper than 1000 levels") {
+		t.Errorf("wrong result for deeply nested JSON: %s", result.Inspect())
+	}
+	if result := builtinJSONParse(nil, &String{Value: "1e999"}); !isError(result, "invalid JSON: number 1e999 is out of range") {
+		t.Errorf("wrong result for a huge number: %s", result.Inspect())
+	}
+}
+
+// recordingContext records the allocations of a builtin and fails them after limit bytes
+type recordingContext struct {
+	allocations []int64
+	total       int64
+	limit       int64
+}
+
+func (c *recordingContext) Call(fn Object, args ...Object) (Object, error) {
+	return nil, fmt.Errorf("can't call functions")
+}
+
+func (c *recordingContext) TryCall(fn Object, args ...Object) (Object, error) {
+	return c.Call(fn, args...)
+}
+
+func (c *recordingContext) Random() *RandomSource {
+	return &RandomSource{}
+}
+
+func (c *recordingContext) Allocate(size int64) error {
+	c.allocations = append(c.allocations, size)
+	c.total += size
+	if c.total > c.limit {
+		return fmt.Errorf("allocation limit of %d bytes exceeded", c.limit)
+	}
+	return nil
+}
+
+func TestJSONStringifyAccountsOutput(t *testing.T) {
+	elements := make([]Object, 2000)
+	for i := range elements {
+		elements[i] = &Array{Elements: []Object{&String{Value: strings.Repeat("x", 100)}}}
+	}
+	value := &Array{Elements: elements}
+
+	ctx := &recordingContext{limit: 1 << 30}
+	result := builtinJSONStringify(ctx, value, NewInteger(maxJSONIndent))
+	str, ok := result.(*String)
+	if !ok {
+		t.Fatalf("stringify failed: %s", result.Inspect())
+	}
+	if ctx.total != StringSize(len(str.Value)) {
+		t.Errorf("wrong accounted size. want=%d, got=%d", StringSize(len(str.Value)), ctx.total)
+	}
+	for _, size := range ctx.allocations {
+		if size > 2*jsonAccountChunk {
+			t.Errorf("output accounted in a chunk of %d bytes, want it accounted as it grows", size)
+		}
+	}
+
+	ctx = &recordingContext{limit: 10000}
+	result = builtinJSONStringify(ctx, value)
+	if !isError(result, "allocation limit of 10000 bytes exceeded") {
+		t.Errorf("wrong result over the limit: %s", result.Inspect())
+	}
+	if ctx.total > ctx.limit+2*jsonAccountChunk {
+		t.Errorf("stringify went on after the limit, accounted %d bytes", ctx.total)
+	}
+
+	long := &String{Value: strings.Repeat(" ", maxJSONIndent+1)}
+	if result := builtinJSONStringify(nil, value, long); !isError(result, "indent of `json_stringify` must be at most 16 characters, got 17") {
+		t.Errorf("wrong result for a long indent: %s", result.Inspect())
+	}
+}
+
+func isError(obj Object, message string) bool {
+	err, ok := obj.(*Error)
+	return ok && err.Message == message
+}
@@ -0,0 +1,323 @@
+package object
+
+import (
+	"math"
+	"math/rand"
+)
+
+// The builtins for numbers. They take integers and floats alike, functions that are exact on integers,
+// like abs or pow with a non-negative exponent, keep integers integers.
+
+// Constants is the default set of named constants. Every BuiltinRegistry starts with a copy of it.
+var Constants = map[string]Object{
+	"PI": &Float{Value: math.Pi},
+	"E":  &Float{Value: math.E},
+}
+
+// DefaultRandomSeed seeds random and random_int until random_seed is called,
+// so scripts get the same numbers on every run.
+const DefaultRandomSeed = 1
+
+// RandomSource holds the random numbers of one run. Every engine has its own and resets it
+// when a run starts, so no script sees the numbers another one used or the seed it set.
+type RandomSource struct {
+	rand *rand.Rand // created when the run uses the first number
+}
+
+// Reset starts the numbers from DefaultRandomSeed again.
+func (r *RandomSource) Reset() {
+	r.rand = nil
+}
+
+// Seed starts the numbers from seed.
+func (r *RandomSource) Seed(seed int64) {
+	r.rand = rand.New(rand.NewSource(seed))
+}
+
+func (r *RandomSource) next() *rand.Rand {
+	if r.rand == nil {
+		r.Seed(DefaultRandomSeed)
+	}
+	return r.rand
+}
+
+// randomSource returns the random numbers of the run of ctx. Without an engine every call starts from DefaultRandomSeed.
+func randomSource(ctx CallContext) *rand.Rand {
+	if ctx == nil {
+		return (&RandomSource{}).next()
+	}
+	return ctx.Random().next()
+}
+
+// numberArgument returns the value of an integer or float argument as a float64
+func numberArgument(name string, arg Object) (float64, *Error) {
+	switch arg := arg.(type) {
+	case *Integer:
+		return float64(arg.Value), nil
+	case *Float:
+		return arg.Value, nil
+	}
+	return 0, newError("argument to `%s` must be INTEGER or FLOAT, got %s", name, arg.Type())
+}
+
+// floatFunction makes a builtin of a function from one float to another
+func floatFunction(name string, fn func(float64) float64) BuiltinFunction {
+	return func(ctx CallContext, args ...Object) Object {
+		if err := checkArity(args, 1, 1); err != nil {
+			return err
+		}
+		x, err := numberArgument(name, args[0])
+		if err != nil {
+			return err
+		}
+		return &Float{Value: fn(x)}
+	}
+}
+
+// binaryFloatFunction makes a builtin of a function from two floats to another
+func binaryFloatFunction(name string, fn func(x, y float64) float64) BuiltinFunction {
+	return func(ctx CallContext, args ...Object) Object {
+		if err := checkArity(args, 2, 2); err != nil {
+			return err
+		}
+		x, err := numberArgument(name, args[0])
+		if err != nil {
+			return err
+		}
+		y, err := numberArgument(name, args[1])
+		if err != nil {
+			return err
+		}
+		return &Float{Value: fn(x, y)}
+	}
+}
+
+// roundFunction makes floor, ceil or round, which return integers as they are and floats rounded to integers
+func roundFunction(name string, fn func(float64) float64) BuiltinFunction {
+	return func(ctx CallContext, args ...Object) Object {
+		if err := checkArity(args, 1, 1); err != nil {
+			return err
+		}
+		if args[0].Type() == IntegerObj {
+			return args[0]
+		}
+		value, err := numberArgument(name, args[0])
+		if err != nil {
+			return err
+		}
+		rounded := fn(value)
+		if math.IsNaN(rounded) || rounded < math.MinInt64 || rounded >= math.MaxInt64 {
+			return newError("result of `%s` is out of the INTEGER range: %s", name, args[0].Inspect())
+		}
+		return NewInteger(int64(rounded))
+	}
+}
+
+// abs(n) returns n without its sign
+func builtinAbs(ctx CallContext, args ...Object) Object {
+	if err := checkArity(args, 1, 1); err != nil {
+		return err
+	}
+	switch arg := args[0].(type) {
+	case *Integer:
+		if arg.Value == math.MinInt64 {
+			return newError("result of `abs` is out of the INTEGER range")
+		}
+		if arg.Value < 0 {
+			return NewInteger(-arg.Value)
+		}
+		return arg
+	case *Float:
+		return &Float{Value: math.Abs(arg.Value)}
+	}
+	return newError("argument to `abs` must be INTEGER or FLOAT, got %s", args[0].Type())
+}
+
+// extremeFunction makes min or max of one or more numbers or strings, ties return the first of them
+func extremeFunction(name string, wanted int) BuiltinFunction {
+	return func(ctx CallContext, args ...Object) Object {
+		if len(args) == 0 {
+			return newError("wrong number of arguments. got=0, want at least 1")
+		}
+		result := args[0]
+		if _, err := compareNatural(name, result, result); err != nil {
+			return err
+		}
+		for _, arg := range args[1:] {
+			order, err := compareNatural(name, arg, result)
+			if err != nil {
+				return err
+			}
+			if order == wanted {
+				result = arg
+			}
+		}
+		return result
+	}
+}
+
+// pow(base, exponent) returns base to the power of exponent. It's an integer if both are integers
+// and exponent isn't negative, a float otherwise.
+func builtinPow(ctx CallContext, args ...Object) Object {
+	if err := checkArity(args, 2, 2); err != nil {
+		return err
+	}
+	base, baseIsInteger := args[0].(*Integer)
+	exponent, exponentIsInteger := args[1].(*Integer)
+	if !baseIsInteger || !exponentIsInteger || exponent.Value < 0 {
+		return binaryFloatFunction("pow", math.Pow)(ctx, args...)
+	}
+	result, b, ok := int64(1), base.Value, true
+	for e := exponent.Value; e > 0 && ok; e >>= 1 {
+		if e&1 == 1 {
+			result, ok = multiply(result, b)
+		}
+		if e > 1 && ok {
+			b, ok = multiply(b, b)
+		}
+	}
+	if !ok {
+		return newError("result of `pow` is out of the INTEGER range")
+	}
+	return NewInteger(result)
+}
+
+// multiply returns a * b and whether it fits into an int64
+func multiply(a, b int64) (int64, bool) {
+	if a == 0 || b == 0 {
+		return 0, true
+	}
+	product := a * b
+	if product/b != a || (a == -1 && b == math.MinInt64) || (b == -1 && a == math.MinInt64) {
+		return 0, false
+	}
+	return product, true
+}
+
+// sqrt(n) returns the square root of n as a float
+func builtinSqrt(ctx CallContext, args ...Object) Object {
+	if err := checkArity(args, 1, 1); err != nil {
+		return err
+	}
+	value, err := numberArgument("sqrt", args[0])
+	if err != nil {
+		return err
+	}
+	if value < 0 {
+		return newError("argument to `sqrt` must not be negative, got %s", args[0].Inspect())
+	}
+	return &Float{Value: math.Sqrt(value)}
+}
+
+// clamp(n, low, high) returns low if n is less than low, high if it's greater than high and n otherwise
+func builtinClamp(ctx CallContext, args ...Object) Object {
+	if err := checkArity(args, 3, 3); err != nil {
+		return err
+	}
+	for _, arg := range args {
+		if _, err := numberArgument("clamp", arg); err != nil {
+			return err
+		}
+	}
+	value, low, high := args[0], args[1], args[2]
+	if order, _ := compareNatural("clamp", low, high); order > 0 {
+		return newError("bounds of `clamp` are reversed: %s > %s", low.Inspect(), high.Inspect())
+	}
+	if order, _ := compareNatural("clamp", value, low); order < 0 {
+		return low
+	}
+	if order, _ := compareNatural("clamp", value, high); order > 0 {
+		return high
+	}
+	return value
+}
+
+// gcd(a, b) returns the greatest common divisor of the integers a and b, which is never negative
+func builtinGcd(ctx CallContext, args ...Object) Object {
+	if err := checkArity(args, 2, 2); err != nil {
+		return err
+	}
+	a, err := integerArgument("gcd", args[0])
+	if err != nil {
+		return err
+	}
+	b, err := integerArgument("gcd", args[1])
+	if err != nil {
+		return err
+	}
+	for b != 0 {
+		a, b = b, a%b
+	}
+	if a == math.MinInt64 {
+		return newError("result of `gcd` is out of the INTEGER range")
+	}
+	if a < 0 {
+		a = -a
+	}
+	return NewInteger(a)
+}
+
+// atan(x) returns the arc tangent of x, atan(y, x) the angle of the point (x, y) like atan2 in other languages
+func builtinAtan(ctx CallContext, args ...Object) Object {
+	if len(args) == 2 {
+		return binaryFloatFunction("atan", math.Atan2)(ctx, args...)
+	}
+	if err := checkArity(args, 1, 2); err != nil {
+		return err
+	}
+	return floatFunction("atan", math.Atan)(ctx, args...)
+}
+
+// random() returns a float in [0, 1)
+func builtinRandom(ctx CallContext, args ...Object) Object {
+	if err := checkArity(args, 0, 0); err != nil {
+		return err
+	}
+	return &Float{Value: randomSource(ctx).Float64()}
+}
+
+// random_int(low, high) returns an integer from low to high, both included
+func builtinRandomInt(ctx CallContext, args ...Object) Object {
+	if err := checkArity(args, 2, 2); err != nil {
+		return err
+	}
+	low, err := integerArgument("random_int", args[0])
+	if err != nil {
+		return err
+	}
+	high, err := integerArgument("random_int", args[1])
+	if err != nil {
+		return err
+	}
+	if low > high {
+		return newError("bounds of `random_int` are reversed: %d > %d", low, high)
+	}
+	random := randomSource(ctx)
+	span := uint64(high - low)
+	if span == math.MaxUint64 {
+		return NewInteger(int64(random.Uint64()))
+	}
+	// values from limit up would make the lowest results more likely than the others
+	count := span + 1
+	limit := math.MaxUint64 - math.MaxUint64%count
+	for {
+		if n := random.Uint64(); n < limit {
+			return NewInteger(low + int64(n%count))
+		}
+	}
+}
+
+// random_seed(n) restarts the numbers of random and random_int from seed n for the rest of the run
+func builtinRandomSeed(ctx CallContext, args ...Object) Object {
+	if err := checkArity(args, 1, 1); err != nil {
+		return err
+	}
+	seed, err := integerArgument("random_seed", args[0])
+	if err != nil {
+		return err
+	}
+	if ctx != nil {
+		ctx.Random().Seed(seed)
+	}
+	return NULL
+}
@@ -0,0 +1,276 @@
+package object
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+// The builtins for strings. Like indexing and slicing they count characters, not bytes.
+
+// maxStringLength bounds the strings repeat and pad build, in bytes
+const maxStringLength = 1 << 32
+
+func stringArgument(name string, arg Object) (string, *Error) {
+	str, ok := arg.(*String)
+	if !ok {
+		return "", newError("argument to `%s` must be STRING, got %s", name, arg.Type())
+	}
+	return str.Value, nil
+}
+
+// charArgument returns the only character of a string of length 1
+func charArgument(name string, arg Object) (rune, *Error) {
+	str, err := stringArgument(name, arg)
+	if err != nil {
+		return 0, err
+	}
+	if utf8.RuneCountInString(str) != 1 {
+		return 0, newError("argument to `%s` must be a single character, got %q", name, str)
+	}
+	r, _ := utf8.DecodeRuneInString(str)
+	return r, nil
+}
+
+// newString accounts and returns a string of value
+func newString(ctx CallContext, value string) Object {
+	if err := allocate(ctx, StringSize(len(value))); err != nil {
+		return err
+	}
+	return &String{Value: value}
+}
+
+// stringFunction makes a builtin of a function from one string to another
+func stringFunction(name string, fn func(string) string) BuiltinFunction {
+	return func(ctx CallContext, args ...Object) Object {
+		if err := checkArity(args, 1, 1); err != nil {
+			return err
+		}
+		str, err := stringArgument(name, args[0])
+		if err != nil {
+			return err
+		}
+		return newString(ctx, fn(str))
+	}
+}
+
+// stringPredicate makes a builtin of a function testing a string against another one
+func stringPredicate(name string, fn func(s, other string) bool) BuiltinFunction {
+	return func(ctx CallContext, args ...Object) Object {
+		if err := checkArity(args, 2, 2); err != nil {
+			return err
+		}
+		str, err := stringArgument(name, args[0])
+		if err != nil {
+			return err
+		}
+		other, err := stringArgument(name, args[1])
+		if err != nil {
+			return err
+		}
+		return nativeBool(fn(str, other))
+	}
+}
+
+// split(s, separator) returns the parts of s between the separators, the characters of s if separator is empty
+func builtinSplit(ctx CallContext, args ...Object) Object {
+	if err := checkArity(args, 2, 2); err != nil {
+		return err
+	}
+	str, err := stringArgument("split", args[0])
+	if err != nil {
+		return err
+	}
+	separator, err := stringArgument("split", args[1])
+	if err != nil {
+		return err
+	}
+	parts := strings.Split(str, separator)
+	if err := allocate(ctx, ArraySize(len(parts))+int64(len(parts))*StringSize(0)+int64(len(str))); err != nil {
+		return err
+	}
+	elements := make([]Object, len(parts))
+	for i, part := range parts {
+		elements[i] = &String{Value: part}
+	}
+	return &Array{Elements: elements}
+}
+
+// replace(s, old, new) returns s with every old replaced by new
+func builtinReplace(ctx CallContext, args ...Object) Object {
+	if err := checkArity(args, 3, 3); err != nil {
+		return err
+	}
+	var values [3]string
+	for i, arg := range args {
+		value, err := stringArgument("replace", arg)
+		if err != nil {
+			return err
+		}
+		values[i] = value
+	}
+	str, old, replacement := values[0], values[1], values[2]
+	if n := strings.Count(str, old); len(replacement) > len(old) && n > 0 &&
+		int64(len(str))+int64(n)*int64(len(replacement)-len(old)) > maxStringLength {
+		return newError("result of `replace` is too long")
+	}
+	return newString(ctx, strings.ReplaceAll(str, old, replacement))
+}
+
+// repeat(s, n) returns s n times in a row
+func builtinRepeat(ctx CallContext, args ...Object) Object {
+	if err := checkArity(args, 2, 2); err != nil {
+		return err
+	}
+	str, err := stringArgument("repeat", args[0])
+	if err != nil {
+		return err
+	}
+	count, err := integerArgument("repeat", args[1])
+	if err != nil {
+		return err
+	}
+	if count < 0 {
+		return newError("count of `repeat` must not be negative, got %d", count)
+	}
+	if len(str) > 0 && count > maxStringLength/int64(len(str)) {
+		return newError("result of `repeat` is too long")
+	}
+	if err := allocate(ctx, StringSize(len(str)*int(count))); err != nil {
+		return err
+	}
+	return &String{Value: strings.Repeat(str, int(count))}
+}
+
+// chars(s) returns the characters of s as strings of length 1
+func builtinChars(ctx CallContext, args ...Object) Object {
+	if err := checkArity(args, 1, 1); err != nil {
+		return err
+	}
+	str, err := stringArgument("chars", args[0])
+	if err != nil {
+		return err
+	}
+	length := utf8.RuneCountInString(str)
+	if err := allocate(ctx, ArraySize(length)+int64(length)*StringSize(0)+int64(len(str))); err != nil {
+		return err
+	}
+	elements := make([]Object, 0, length)
+	for _, r := range str {
+		elements = append(elements, &String{Value: string(r)})
+	}
+	return &Array{Elements: elements}
+}
+
+// ord(c) returns the code point of the character c
+func builtinOrd(ctx CallContext, args ...Object) Object {
+	if err := checkArity(args, 1, 1); err != nil {
+		return err
+	}
+	r, err := charArgument("ord", args[0])
+	if err != nil {
+		return err
+	}
+	return NewInteger(int64(r))
+}
+
+// chr(n) returns the character of the code point n
+func builtinChr(ctx CallContext, args ...Object) Object {
+	if err := checkArity(args, 1, 1); err != nil {
+		return err
+	}
+	n, err := integerArgument("chr", args[0])
+	if err != nil {
+		return err
+	}
+	if n < 0 || n > utf8.MaxRune || !utf8.ValidRune(rune(n)) {
+		return newError("argument to `chr` is not a code point: %d", n)
+	}
+	return &String{Value: string(rune(n))}
+}
+
+// padFunction makes pad_left or pad_right: pad_left(s, width, c) puts c before s until it's width characters long,
+// c may be left out for a space
+func padFunction(name string, left bool) BuiltinFunction {
+	return func(ctx CallContext, args ...Object) Object {
+		if err := checkArity(args, 2, 3); err != nil {
+			return err
+		}
+		str, err := stringArgument(name, args[0])
+		if err != nil {
+			return err
+		}
+		width, err := integerArgument(name, args[1])
+		if err != nil {
+			return err
+		}
+		pad := ' '
+		if len(args) == 3 {
+			pad, err = charArgument(name, args[2])
+			if err != nil {
+				return err
+			}
+		}
+		missing := width - int64(utf8.RuneCountInString(str))
+		if missing <= 0 {
+			return args[0]
+		}
+		if missing > maxStringLength/int64(utf8.RuneLen(pad)) {
+			return newError("result of `%s` is too long", name)
+		}
+		padding := strings.Repeat(string(pad), int(missing))
+		if left {
+			return newString(ctx, padding+str)
+		}
+		return newString(ctx, str+padding)
+	}
+}
+
+// format(template, args...) replaces the verbs of template with args in order: %s and %v with how they print,
+// %d with an integer. %% is a percent sign.
+func builtinFormat(ctx CallContext, args ...Object) Object {
+	if len(args) == 0 {
+		return newError("wrong number of arguments. got=0, want at least 1")
+	}
+	template, err := stringArgument("format", args[0])
+	if err != nil {
+		return err
+	}
+	values := args[1:]
+	var out strings.Builder
+	used := 0
+	for i := 0; i < len(template); i++ {
+		if template[i] != '%' {
+			out.WriteByte(template[i])
+			continue
+		}
+		if i+1 == len(template) {
+			return newError("format ends with a lone %%")
+		}
+		verb, size := utf8.DecodeRuneInString(template[i+1:])
+		i += size
+		if verb == '%' {
+			out.WriteByte('%')
+			continue
+		}
+		if used == len(values) {
+			return newError("format has more verbs than arguments")
+		}
+		value := values[used]
+		used++
+		switch verb {
+		case 's', 'v':
+			out.WriteString(value.Inspect())
+		case 'd':
+			if value.Type() != IntegerObj {
+				return newError("%%d of format must be INTEGER, got %s", value.Type())
+			}
+			out.WriteString(value.Inspect())
+		default:
+			return newError("unknown verb %%%c in format", verb)
+		}
+	}
+	if used != len(values) {
+		return newError("format has %d arguments but %d verbs", len(values), used)
+	}
+	return newString(ctx, out.String())
+}
@@ -0,0 +1,83 @@
+package object
+
+import (
+	"math"
+	"strconv"
+	"strings"
+)
+
+// The builtins for looking at values and converting them from one type to another.
+
+// type(x) returns the name of the type of x, like "INTEGER". Every kind of function is a "FUNCTION",
+// whichever engine made it.
+func builtinType(ctx CallContext, args ...Object) Object {
+	if err := checkArity(args, 1, 1); err != nil {
+		return err
+	}
+	switch args[0].(type) {
+	case *Function, *Closure, *CompiledFunction:
+		return &String{Value: string(FunctionObj)}
+	}
+	return &String{Value: string(args[0].Type())}
+}
+
+// str(x) returns x the way puts prints it
+func builtinStr(ctx CallContext, args ...Object) Object {
+	if err := checkArity(args, 1, 1); err != nil {
+		return err
+	}
+	if str, ok := args[0].(*String); ok {
+		return str
+	}
+	return newString(ctx, args[0].Inspect())
+}
+
+// int(x) returns x as an integer: strings are parsed as decimal numbers and floats are truncated
+func builtinInt(ctx CallContext, args ...Object) Object {
+	if err := checkArity(args, 1, 1); err != nil {
+		return err
+	}
+	switch arg := args[0].(type) {
+	case *Integer:
+		return arg
+	case *Float:
+		truncated := math.Trunc(arg.Value)
+		if math.IsNaN(truncated) || truncated < math.MinInt64 || truncated >= math.MaxInt64 {
+			return newError("can't convert %s to INTEGER", arg.Inspect())
+		}
+		return NewInteger(int64(truncated))
+	case *String:
+		value, err := strconv.ParseInt(strings.TrimSpace(arg.Value), 10, 64)
+		if err != nil {
+			return newError("can't convert %q to INTEGER", arg.Value)
+		}
+		return NewInteger(value)
+	}
+	return newError("argument to `int` must be INTEGER, FLOAT or STRING, got %s", args[0].Type())
+}
+
+// bool(x) returns whether conditions take x as true
+func builtinBool(ctx CallContext, args ...Object) Object {
+	if err := checkArity(args, 1, 1); err != nil {
+		return err
+	}
+	return nativeBool(IsTruthy(args[0]))
+}
+
+// arity(fn) returns the number of parameters of fn, -1 for builtins taking a varying number of arguments
+func builtinArity(ctx CallContext, args ...Object) Object {
+	if err := checkArity(args, 1, 1); err != nil {
+		return err
+	}
+	switch fn := args[0].(type) {
+	case *Function:
+		return NewInteger(int64(len(fn.Parameters)))
+	case *Closure:
+		return NewInteger(int64(fn.Fn.NumParameters))
+	case *CompiledFunction:
+		return NewInteger(int64(fn.NumParameters))
+	case *Builtin:
+		return NewInteger(int64(fn.Arity))
+	}
+	return newError("argument to `arity` must be a function, got %s", args[0].Type())
+}
@@ -0,0 +1,529 @@
+package object
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// FromGo and ToGo translate between Go values and objects so host functions don't have to do it by hand.
+//
+//	Go                          object
+//	bool                        Boolean
+//	int*, uint*                 Integer
+//	float*                      Float
+//	string, []byte              String
+//	slice, array                Array
+//	map                         Hash, the keys have to convert to hashable objects
+//	struct                      Hash with a String key per exported field, renamed or skipped ("-") by a `js` tag
+//	func                        Builtin converting its arguments with ToGo and its results with FromGo,
+//	                            a leading CallContext parameter receives the calling engine
+//	nil, nil pointer            Null
+//
+// Values that already are objects are passed through unchanged in both directions.
+
+var (
+	objectType      = reflect.TypeOf((*Object)(nil)).Elem()
+	errorType       = reflect.TypeOf((*error)(nil)).Elem()
+	callContextType = reflect.TypeOf((*CallContext)(nil)).Elem()
+	anyType         = reflect.TypeOf((*any)(nil)).Elem()
+)
+
+// FromGo converts a Go value into an object.
+func FromGo(value any) (Object, error) {
+	if value == nil {
+		return NULL, nil
+	}
+	if obj, ok := value.(Object); ok {
+		return obj, nil
+	}
+	return newFromGoConverter().fromValue(reflect.ValueOf(value))
+}
+
+// fromGoConverter converts one Go value, it remembers the pointers, maps and slices it is inside of
+// to report a value that contains itself instead of recursing until the stack overflows.
+type fromGoConverter struct {
+	visiting map[reference]bool
+}
+
+// reference identifies what a pointer, map or slice refers to. Slices of one array with
+// different lengths are different values, so the length is part of it.
+type reference struct {
+	pointer uintptr
+	typ     reflect.Type
+	length  int
+}
+
+func newFromGoConverter() *fromGoConverter {
+	return &fromGoConverter{visiting: map[reference]bool{}}
+}
+
+// enter marks the value v refers to as being converted, leave has to be called when it's done
+func (c *fromGoConverter) enter(v reflect.Value) (reference, error) {
+	ref := reference{pointer: v.Pointer(), typ: v.Type()}
+	if v.Kind() == reflect.Slice {
+		ref.length = v.Len()
+	}
+	if c.visiting[ref] {
+		return ref, fmt.Errorf("cannot convert %s: it contains itself", v.Type())
+	}
+	c.visiting[ref] = true
+	return ref, nil
+}
+
+func (c *fromGoConverter) leave(ref reference) {
+	delete(c.visiting, ref)
+}
+
+func (c *fromGoConverter) fromValue(v reflect.Value) (Object, error) {
+	if !v.IsValid() {
+		return NULL, nil
+	}
+	if v.Type().Implements(objectType) {
+		if (v.Kind() == reflect.Pointer || v.Kind() == reflect.Interface) && v.IsNil() {
+			return NULL, nil
+		}
+		return v.Interface().(Object), nil
+	}
+	switch v.Kind() {
+	case reflect.Bool:
+		if v.Bool() {
+			return TRUE, nil
+		}
+		return FALSE, nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return NewInteger(v.Int()), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		u := v.Uint()
+		if u > math.MaxInt64 {
+			return nil, fmt.Errorf("cannot convert %d to %s: out of range", u, IntegerObj)
+		}
+		return NewInteger(int64(u)), nil
+	case reflect.Float32, reflect.Float64:
+		return &Float{Value: v.Float()}, nil
+	case reflect.String:
+		return &String{Value: v.String()}, nil
+	case reflect.Interface:
+		if v.IsNil() {
+			return NULL, nil
+		}
+		return c.fromValue(v.Elem())
+	case reflect.Pointer:
+		if v.IsNil() {
+			return NULL, nil
+		}
+		ref, err := c.enter(v)
+		if err != nil {
+			return nil, err
+		}
+		defer c.leave(ref)
+		return c.fromValue(v.Elem())
+	case reflect.Slice:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			return &String{Value: string(v.Bytes())}, nil
+		}
+		if v.Len() == 0 {
+			return c.arrayFromValue(v)
+		}
+		ref, err := c.enter(v)
+		if err != nil {
+			return nil, err
+		}
+		defer c.leave(ref)
+		return c.arrayFromValue(v)
+	case reflect.Array:
+		return c.arrayFromValue(v)
+	case reflect.Map:
+		if v.IsNil() || v.Len() == 0 {
+			return c.hashFromMap(v)
+		}
+		ref, err := c.enter(v)
+		if err != nil {
+			return nil, err
+		}
+		defer c.leave(ref)
+		return c.hashFromMap(v)
+	case reflect.Struct:
+		return c.hashFromStruct(v)
+	case reflect.Func:
+		if v.IsNil() {
+			return NULL, nil
+		}
+		return builtinFromFunc(v)
+	default:
+		return nil, fmt.Errorf("cannot convert %s to an object", v.Type())
+	}
+}
+
+func (c *fromGoConverter) arrayFromValue(v reflect.Value) (Object, error) {
+	elements := make([]Object, v.Len())
+	for i := range elements {
+		element, err := c.fromValue(v.Index(i))
+		if err != nil {
+			return nil, fmt.Errorf("element %d: %w", i, err)
+		}
+		elements[i] = element
+	}
+	return &Array{Elements: elements}, nil
+}
+
+// hashFromMap converts a map into a hash. Go doesn't order maps, the pairs are sorted by their keys with compareKeys
+// to give a deterministic order.
+func (c *fromGoConverter) hashFromMap(v reflect.Value) (Object, error) {
+	pairs := make([]HashPair, 0, v.Len())
+	iter := v.MapRange()
+	for iter.Next() {
+		key, err := c.fromValue(iter.Key())
+		if err != nil {
+			return nil, fmt.Errorf("key %v: %w", iter.Key(), err)
+		}
+		hashKey, ok := AsHashable(key)
+		if !ok {
+			return nil, fmt.Errorf("unusable as hash key: %s", key.Type())
+		}
+		value, err := c.fromValue(iter.Value())
+		if err != nil {
+			return nil, fmt.Errorf("value of key %v: %w", iter.Key(), err)
+		}
+		pairs = append(pairs, HashPair{Key: hashKey, Value: value})
+	}
+	sort.SliceStable(pairs, func(i, j int) bool {
+		return compareKeys(pairs[i].Key, pairs[j].Key) < 0
+	})
+	hash := NewHash(len(pairs))
+	for i, pair := range pairs {
+		// keys like 1 and int64(1) of a map[any]any convert to the same key, which of them would win depends on the map order
+		if i > 0 && compareKeys(pairs[i-1].Key, pair.Key) == 0 {
+			return nil, fmt.Errorf("more than one key converts to %s", pair.Key.Inspect())
+		}
+		hash.Set(pair.Key.(Hashable), pair.Value)
+	}
+	return hash, nil
+}
+
+// compareKeys orders hash keys by type, then arrays element by element and other keys by their Inspect string.
+// It only returns 0 for keys that are the same hash key.
+func compareKeys(a, b Object) int {
+	if a.Type() != b.Type() {
+		return strings.Compare(string(a.Type()), string(b.Type()))
+	}
+	if a, ok := a.(*Array); ok {
+		b := b.(*Array)
+		for i := 0; i < len(a.Elements) && i < len(b.Elements); i++ {
+			if c := compareKeys(a.Elements[i], b.Elements[i]); c != 0 {
+				return c
+			}
+		}
+		return len(a.Elements) - len(b.Elements)
+	}
+	return strings.Compare(a.Inspect(), b.Inspect())
+}
+
+func (c *fromGoConverter) hashFromStruct(v reflect.Value) (Object, error) {
+	t := v.Type()
+	hash := NewHash(t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		name, ok := fieldName(t.Field(i))
+		if !ok {
+			continue
+		}
+		value, err := c.fromValue(v.Field(i))
+		if err != nil {
+			return nil, fmt.Errorf("field %s: %w", t.Field(i).Name, err)
+		}
+		hash.Set(&String{Value: name}, value)
+	}
+	return hash, nil
+}
+
+// fieldName returns the hash key of a struct field, false if the field isn't converted.
+func fieldName(field reflect.StructField) (string, bool) {
+	if !field.IsExported() {
+		return "", false
+	}
+	tag := field.Tag.Get("js")
+	if tag == "-" {
+		return "", false
+	}
+	if name, _, _ := strings.Cut(tag, ","); name != "" {
+		return name, true
+	}
+	return field.Name, true
+}
+
+// builtinFromFunc wraps a Go function. It may return nothing, one value, an error, or one value and an error.
+func builtinFromFunc(fn reflect.Value) (*Builtin, error) {
+	t := fn.Type()
+	numOut := t.NumOut()
+	returnsError := numOut > 0 && t.Out(numOut-1) == errorType
+	if numOut > 2 || (numOut == 2 && !returnsError) {
+		return nil, fmt.Errorf("cannot convert %s to a builtin: want at most one result and an error", t)
+	}
+	numIn := t.NumIn()
+	// the parameters filled from script arguments start after a leading CallContext
+	first := 0
+	if numIn > 0 && t.In(0) == callContextType {
+		first = 1
+	}
+	numParams := numIn - first
+	arity := numParams
+	if t.IsVariadic() {
+		arity = VariadicArity
+	}
+	builtin := func(ctx CallContext, args ...Object) Object {
+		if t.IsVariadic() && len(args) < numParams-1 {
+			return newError("wrong number of arguments. got=%d, want at least %d", len(args), numParams-1)
+		}
+		if !t.IsVariadic() && len(args) != numParams {
+			return newError("wrong number of arguments. got=%d, want=%d", len(args), numParams)
+		}
+		in := make([]reflect.Value, first+len(args))
+		if first == 1 {
+			in[0] = reflect.New(callContextType).Elem()
+			if ctx != nil {
+				in[0].Set(reflect.ValueOf(ctx))
+			}
+		}
+		for i, arg := range args {
+			var paramType reflect.Type
+			if t.IsVariadic() && first+i >= numIn-1 {
+				paramType = t.In(numIn - 1).Elem()
+			} else {
+				paramType = t.In(first + i)
+			}
+			param := reflect.New(paramType).Elem()
+			if err := toValue(arg, param); err != nil {
+				return newError("argument %d: %s", i+1, err)
+			}
+			in[first+i] = param
+		}
+		out := fn.Call(in)
+		if returnsError {
+			if err := out[numOut-1]; !err.IsNil() {
+				return newError("%s", err.Interface().(error))
+			}
+			out = out[:numOut-1]
+		}
+		if len(out) == 0 {
+			return nil
+		}
+		result, err := newFromGoConverter().fromValue(out[0])
+		if err != nil {
+			return newError("result: %s", err)
+		}
+		return result
+	}
+	return &Builtin{Fn: builtin, Arity: arity}, nil
+}
+
+// ToGo stores obj in the value target points to, converting it to the type of that value.
+// A target of type any receives int64, float64, bool, string, nil, []any, and map[string]any,
+// or map[any]any for hashes with keys that aren't all strings. Array keys become Go arrays of type [n]any
+// in maps with keys of type any, since slices can't be map keys.
+func ToGo(obj Object, target any) error {
+	v := reflect.ValueOf(target)
+	if v.Kind() != reflect.Pointer || v.IsNil() {
+		return errors.New("target must be a non-nil pointer")
+	}
+	return toValue(obj, v.Elem())
+}
+
+func toValue(obj Object, v reflect.Value) error {
+	if obj == nil {
+		obj = NULL
+	}
+	t := v.Type()
+	if t.Kind() == reflect.Interface && t.NumMethod() == 0 {
+		native := toNative(obj)
+		if native == nil {
+			v.Set(reflect.Zero(t))
+		} else {
+			v.Set(reflect.ValueOf(native))
+		}
+		return nil
+	}
+	if reflect.TypeOf(obj).AssignableTo(t) {
+		v.Set(reflect.ValueOf(obj))
+		return nil
+	}
+	if obj == NULL {
+		switch t.Kind() {
+		case reflect.Pointer, reflect.Slice, reflect.Map, reflect.Interface, reflect.Func:
+			v.Set(reflect.Zero(t))
+			return nil
+		}
+	}
+	switch t.Kind() {
+	case reflect.Pointer:
+		elem := reflect.New(t.Elem())
+		if err := toValue(obj, elem.Elem()); err != nil {
+			return err
+		}
+		v.Set(elem)
+		return nil
+	case reflect.Bool:
+		if b, ok := obj.(*Boolean); ok {
+			v.SetBool(b.Value)
+			return nil
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if i, ok := obj.(*Integer); ok {
+			if v.OverflowInt(i.Value) {
+				return fmt.Errorf("cannot convert %d to %s: out of range", i.Value, t)
+			}
+			v.SetInt(i.Value)
+			return nil
+		}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		if i, ok := obj.(*Integer); ok {
+			if i.Value < 0 || v.OverflowUint(uint64(i.Value)) {
+				return fmt.Errorf("cannot convert %d to %s: out of range", i.Value, t)
+			}
+			v.SetUint(uint64(i.Value))
+			return nil
+		}
+	case reflect.Float32, reflect.Float64:
+		switch n := obj.(type) {
+		case *Float:
+			v.SetFloat(n.Value)
+			return nil
+		case *Integer:
+			v.SetFloat(float64(n.Value))
+			return nil
+		}
+	case reflect.String:
+		if s, ok := obj.(*String); ok {
+			v.SetString(s.Value)
+			return nil
+		}
+	case reflect.Slice:
+		if s, ok := obj.(*String); ok && t.Elem().Kind() == reflect.Uint8 {
+			v.SetBytes([]byte(s.Value))
+			return nil
+		}
+		if arr, ok := obj.(*Array); ok {
+			slice := reflect.MakeSlice(t, len(arr.Elements), len(arr.Elements))
+			for i, element := range arr.Elements {
+				if err := toValue(element, slice.Index(i)); err != nil {
+					return fmt.Errorf("element %d: %w", i, err)
+				}
+			}
+			v.Set(slice)
+			return nil
+		}
+	case reflect.Array:
+		if arr, ok := obj.(*Array); ok {
+			if len(arr.Elements) != t.Len() {
+				return fmt.Errorf("cannot convert ARRAY of length %d to %s", len(arr.Elements), t)
+			}
+			for i, element := range arr.Elements {
+				if err := toValue(element, v.Index(i)); err != nil {
+					return fmt.Errorf("element %d: %w", i, err)
+				}
+			}
+			return nil
+		}
+	case reflect.Map:
+		if hash, ok := obj.(*Hash); ok {
+			m := reflect.MakeMapWithSize(t, hash.Len())
+			for _, pair := range hash.Pairs() {
+				key := reflect.New(t.Key()).Elem()
+				if t.Key() == anyType {
+					if native := nativeKey(pair.Key); native != nil {
+						key.Set(reflect.ValueOf(native))
+					}
+				} else if err := toValue(pair.Key, key); err != nil {
+					return fmt.Errorf("key %s: %w", pair.Key.Inspect(), err)
+				}
+				value := reflect.New(t.Elem()).Elem()
+				if err := toValue(pair.Value, value); err != nil {
+					return fmt.Errorf("value of key %s: %w", pair.Key.Inspect(), err)
+				}
+				m.SetMapIndex(key, value)
+			}
+			v.Set(m)
+			return nil
+		}
+	case reflect.Struct:
+		if hash, ok := obj.(*Hash); ok {
+			for i := 0; i < t.NumField(); i++ {
+				name, ok := fieldName(t.Field(i))
+				if !ok {
+					continue
+				}
+				value, ok := hash.Get(&String{Value: name})
+				if !ok {
+					continue
+				}
+				if err := toValue(value, v.Field(i)); err != nil {
+					return fmt.Errorf("field %s: %w", t.Field(i).Name, err)
+				}
+			}
+			return nil
+		}
+	}
+	return fmt.Errorf("cannot convert %s to %s", obj.Type(), t)
+}
+
+// toNative converts obj into the plain Go value used for targets of type any.
+func toNative(obj Object) any {
+	switch obj := obj.(type) {
+	case *Integer:
+		return obj.Value
+	case *Float:
+		return obj.Value
+	case *Boolean:
+		return obj.Value
+	case *String:
+		return obj.Value
+	case *Null:
+		return nil
+	case *Array:
+		elements := make([]any, len(obj.Elements))
+		for i, element := range obj.Elements {
+			elements[i] = toNative(element)
+		}
+		return elements
+	case *Hash:
+		stringKeys := true
+		for _, pair := range obj.Pairs() {
+			if pair.Key.Type() != StringObj {
+				stringKeys = false
+				break
+			}
+		}
+		if stringKeys {
+			m := make(map[string]any, obj.Len())
+			for _, pair := range obj.Pairs() {
+				m[pair.Key.(*String).Value] = toNative(pair.Value)
+			}
+			return m
+		}
+		m := make(map[any]any, obj.Len())
+		for _, pair := range obj.Pairs() {
+			m[nativeKey(pair.Key)] = toNative(pair.Value)
+		}
+		return m
+	default:
+		return obj
+	}
+}
+
+// nativeKey converts a hash key like toNative, except for arrays, which become Go arrays of type [n]any
+// that unlike []any can be map keys.
+func nativeKey(key Object) any {
+	array, ok := key.(*Array)
+	if !ok {
+		return toNative(key)
+	}
+	native := reflect.New(reflect.ArrayOf(len(array.Elements), anyType)).Elem()
+	for i, element := range array.Elements {
+		if element := nativeKey(element); element != nil {
+			native.Index(i).Set(reflect.ValueOf(element))
+		}
+	}
+	return native.Interface()
+}
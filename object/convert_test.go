@@ -0,0 +1,280 @@
+package object
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type convertPoint struct {
+	X      int    `js:"x"`
+	Y      int    `js:"y"`
+	Label  string // no tag, keeps the field name
+	Secret string `js:"-"`
+	hidden int
+}
+
+func TestFromGo(t *testing.T) {
+	tests := []struct {
+		input    any
+		expected string
+	}{
+		{nil, "null"},
+		{true, "true"},
+		{false, "false"},
+		{42, "42"},
+		{int8(-7), "-7"},
+		{uint16(7), "7"},
+		{2.5, "2.5"},
+		{float32(0.5), "0.5"},
+		{"hello", "hello"},
+		{[]byte("bytes"), "bytes"},
+		{[]int{1, 2, 3}, "[1, 2, 3]"},
+		{[2]string{"a", "b"}, "[a, b]"},
+		{[]any{1, "two", nil, []bool{true}}, "[1, two, null, [true]]"},
+		{map[string]int{"one": 1}, "{one: 1}"},
+		// keys that inspect the same are ordered by their type
+		{map[any]any{"1": "s", 1: "i", [1]any{"1"}: "as", [1]any{1}: "ai"}, "{[1]: ai, [1]: as, 1: i, 1: s}"},
+		{(*convertPoint)(nil), "null"},
+		{&Integer{Value: 5}, "5"},
+	}
+	for _, tt := range tests {
+		obj, err := FromGo(tt.input)
+		if err != nil {
+			t.Fatalf("FromGo(%#v) failed: %s", tt.input, err)
+		}
+		if obj.Inspect() != tt.expected {
+			t.Errorf("FromGo(%#v) wrong. want=%s, got=%s", tt.input, tt.expected, obj.Inspect())
+		}
+	}
+	if obj, _ := FromGo(true); obj != TRUE {
+		t.Errorf("booleans must convert to the shared TRUE and FALSE")
+	}
+}
+
+func TestFromGoStructFields(t *testing.T) {
+	obj, err := FromGo(&convertPoint{X: 1, Y: 2, Label: "p", Secret: "s", hidden: 3})
+	if err != nil {
+		t.Fatalf("FromGo failed: %s", err)
+	}
+	hash, ok := obj.(*Hash)
+	if !ok {
+		t.Fatalf("struct not converted to Hash. got=%T", obj)
+	}
+	if hash.Len() != 3 {
+		t.Fatalf("wrong number of fields. want=3, got=%d (%s)", hash.Len(), hash.Inspect())
+	}
+	for _, key := range []string{"x", "y", "Label"} {
+		if _, ok := hash.Get(&String{Value: key}); !ok {
+			t.Errorf("field %s missing", key)
+		}
+	}
+}
+
+func TestFromGoErrors(t *testing.T) {
+	tests := []struct {
+		input    any
+		expected string
+	}{
+		{make(chan int), "cannot convert chan int to an object"},
+		{uint64(1 << 63), "out of range"},
+		{map[string]any{"c": make(chan int)}, "value of key c"},
+		{map[any]int{1: 1, int64(1): 2}, "more than one key converts to 1"},
+		{func() (int, int) { return 1, 2 }, "want at most one result and an error"},
+	}
+	for _, tt := range tests {
+		_, err := FromGo(tt.input)
+		if err == nil || !strings.Contains(err.Error(), tt.expected) {
+			t.Errorf("FromGo(%T) wrong error. want %q, got=%v", tt.input, tt.expected, err)
+		}
+	}
+}
+
+type convertNode struct {
+	Value int
+	Next  *convertNode
+}
+
+func TestFromGoCycles(t *testing.T) {
+	node := &convertNode{Value: 1}
+	node.Next = node
+	hash := map[string]any{"a": 1}
+	hash["self"] = hash
+	slice := []any{1, nil}
+	slice[1] = slice
+	wrapped := func() *convertNode { return node }
+	for _, input := range []any{node, hash, slice} {
+		_, err := FromGo(input)
+		if err == nil || !strings.Contains(err.Error(), "it contains itself") {
+			t.Errorf("FromGo(%T) wrong error for a cycle. got=%v", input, err)
+		}
+	}
+	builtin, err := FromGo(wrapped)
+	if err != nil {
+		t.Fatalf("FromGo(func) failed: %s", err)
+	}
+	result := builtin.(*Builtin).Fn(nil)
+	if err, ok := result.(*Error); !ok || !strings.Contains(err.Message, "it contains itself") {
+		t.Errorf("wrong result of a func returning a cycle. got=%s", result.Inspect())
+	}
+
+	// values met twice without containing themselves aren't cycles
+	shared := &convertNode{Value: 2}
+	obj, err := FromGo([]*convertNode{shared, shared, {Value: 3, Next: shared}})
+	if err != nil {
+		t.Fatalf("FromGo of shared values failed: %s", err)
+	}
+	if len(obj.(*Array).Elements) != 3 {
+		t.Errorf("wrong result of shared values. got=%s", obj.Inspect())
+	}
+}
+
+func TestToGo(t *testing.T) {
+	var i int
+	var u8 uint8
+	var f float64
+	var s string
+	var b bool
+	var ints []int
+	var pair [2]string
+	var m map[string]int
+	var p convertPoint
+	var pp *convertPoint
+	var native any
+	var obj Object
+	var integer *Integer
+
+	hash, _ := FromGo(map[string]any{"x": 3, "y": 4, "Label": "p"})
+	tests := []struct {
+		obj      Object
+		target   any
+		expected any
+	}{
+		{&Integer{Value: 5}, &i, 5},
+		{&Integer{Value: 200}, &u8, uint8(200)},
+		{&Integer{Value: 2}, &f, 2.0},
+		{&Float{Value: 2.5}, &f, 2.5},
+		{&String{Value: "hi"}, &s, "hi"},
+		{TRUE, &b, true},
+		{&Array{Elements: []Object{&Integer{Value: 1}, &Integer{Value: 2}}}, &ints, []int{1, 2}},
+		{&Array{Elements: []Object{&String{Value: "a"}, &String{Value: "b"}}}, &pair, [2]string{"a", "b"}},
+		{hash, &p, convertPoint{X: 3, Y: 4, Label: "p"}},
+		{hash, &pp, &convertPoint{X: 3, Y: 4, Label: "p"}},
+		{NULL, &pp, (*convertPoint)(nil)},
+		{&Array{Elements: []Object{&Integer{Value: 1}, NULL}}, &native, []any{int64(1), nil}},
+		{&Integer{Value: 7}, &obj, Object(&Integer{Value: 7})},
+		{&Integer{Value: 8}, &integer, &Integer{Value: 8}},
+	}
+	for _, tt := range tests {
+		err := ToGo(tt.obj, tt.target)
+		if err != nil {
+			t.Fatalf("ToGo(%s, %T) failed: %s", tt.obj.Inspect(), tt.target, err)
+		}
+		got := reflect.ValueOf(tt.target).Elem().Interface()
+		if !reflect.DeepEqual(got, tt.expected) {
+			t.Errorf("ToGo(%s, %T) wrong. want=%#v, got=%#v", tt.obj.Inspect(), tt.target, tt.expected, got)
+		}
+	}
+
+	err := ToGo(hash, &m)
+	if err == nil {
+		t.Errorf("expected error converting a string value to int")
+	}
+	if err := ToGo(&Integer{Value: 300}, &u8); err == nil || !strings.Contains(err.Error(), "out of range") {
+		t.Errorf("expected out of range error. got=%v", err)
+	}
+	if err := ToGo(&String{Value: "x"}, &i); err == nil || err.Error() != "cannot convert STRING to int" {
+		t.Errorf("wrong type error. got=%v", err)
+	}
+	if err := ToGo(&Integer{Value: 1}, i); err == nil {
+		t.Errorf("expected error for non-pointer target")
+	}
+}
+
+func TestToGoArrayKeys(t *testing.T) {
+	hash := NewHash(2)
+	hash.Set(&Array{Elements: []Object{&Integer{Value: 1}, &Array{Elements: []Object{NULL}}}}, &String{Value: "a"})
+	hash.Set(&String{Value: "b"}, &Integer{Value: 2})
+	expected := map[any]any{[2]any{int64(1), [1]any{nil}}: "a", "b": int64(2)}
+
+	var native any
+	if err := ToGo(hash, &native); err != nil {
+		t.Fatalf("ToGo(%s, any) failed: %s", hash.Inspect(), err)
+	}
+	if !reflect.DeepEqual(native, expected) {
+		t.Errorf("ToGo(%s, any) wrong. want=%#v, got=%#v", hash.Inspect(), expected, native)
+	}
+	var m map[any]any
+	if err := ToGo(hash, &m); err != nil {
+		t.Fatalf("ToGo(%s, map[any]any) failed: %s", hash.Inspect(), err)
+	}
+	if !reflect.DeepEqual(m, expected) {
+		t.Errorf("ToGo(%s, map[any]any) wrong. want=%#v, got=%#v", hash.Inspect(), expected, m)
+	}
+}
+
+func TestFromGoFunc(t *testing.T) {
+	obj, err := FromGo(func(name string, times int) string {
+		return strings.Repeat(name, times)
+	})
+	if err != nil {
+		t.Fatalf("FromGo failed: %s", err)
+	}
+	repeat, ok := obj.(*Builtin)
+	if !ok {
+		t.Fatalf("func not converted to Builtin. got=%T", obj)
+	}
+	if repeat.Arity != 2 {
+		t.Errorf("wrong arity. want=2, got=%d", repeat.Arity)
+	}
+	tests := []struct {
+		args     []Object
+		expected string
+	}{
+		{[]Object{&String{Value: "ab"}, &Integer{Value: 2}}, "abab"},
+		{[]Object{&String{Value: "ab"}}, "ERROR: wrong number of arguments. got=1, want=2"},
+		{[]Object{&Integer{Value: 1}, &Integer{Value: 2}}, "ERROR: argument 1: cannot convert INTEGER to string"},
+	}
+	for _, tt := range tests {
+		result := repeat.Fn(nil, tt.args...)
+		if result.Inspect() != tt.expected {
+			t.Errorf("wrong result. want=%q, got=%q", tt.expected, result.Inspect())
+		}
+	}
+
+	obj, _ = FromGo(func(xs ...int) (int, error) {
+		if len(xs) == 0 {
+			return 0, errors.New("nothing to sum")
+		}
+		sum := 0
+		for _, x := range xs {
+			sum += x
+		}
+		return sum, nil
+	})
+	sum := obj.(*Builtin)
+	if sum.Arity != VariadicArity {
+		t.Errorf("variadic func should have VariadicArity. got=%d", sum.Arity)
+	}
+	if result := sum.Fn(nil, &Integer{Value: 1}, &Integer{Value: 2}); result.Inspect() != "3" {
+		t.Errorf("wrong sum. got=%s", result.Inspect())
+	}
+	if result := sum.Fn(nil); result.Inspect() != "ERROR: nothing to sum" {
+		t.Errorf("error result not converted. got=%s", result.Inspect())
+	}
+}
+
+func TestBuiltinRegistryRegisterFunc(t *testing.T) {
+	r := NewBuiltinRegistry()
+	if _, err := r.RegisterFunc("answer", func() int { return 42 }); err != nil {
+		t.Fatalf("RegisterFunc failed: %s", err)
+	}
+	answer, _ := r.Lookup("answer")
+	if result := answer.Fn(nil); result.Inspect() != "42" {
+		t.Errorf("wrong result. got=%s", result.Inspect())
+	}
+	if _, err := r.RegisterFunc("bad", 42); err == nil {
+		t.Errorf("expected error registering a non-function")
+	}
+}
@@ -0,0 +1,84 @@
+package object
+
+// Equal reports whether a and b are equal as the == operator of the engines sees them.
+// Numbers, strings, booleans and null are compared by value, arrays element by element and hashes
+// pair by pair regardless of their order. Anything else is only equal to itself.
+// Arrays and hashes that contain themselves, which only host code can build, are compared without looping forever.
+func Equal(a, b Object) bool {
+	return equal(a, b, nil)
+}
+
+// comparison is a pair of arrays or hashes being compared further up the recursion
+type comparison struct {
+	a, b Object
+}
+
+func equal(a, b Object, comparing map[comparison]bool) bool {
+	if a == b {
+		return true
+	}
+	switch a := a.(type) {
+	case *Integer:
+		switch b := b.(type) {
+		case *Integer:
+			return a.Value == b.Value
+		case *Float:
+			return float64(a.Value) == b.Value
+		}
+	case *Float:
+		switch b := b.(type) {
+		case *Float:
+			return a.Value == b.Value
+		case *Integer:
+			return a.Value == float64(b.Value)
+		}
+	case *String:
+		b, ok := b.(*String)
+		return ok && a.Value == b.Value
+	case *Boolean:
+		b, ok := b.(*Boolean)
+		return ok && a.Value == b.Value
+	case *Null:
+		_, ok := b.(*Null)
+		return ok
+	case *Array:
+		b, ok := b.(*Array)
+		if !ok || len(a.Elements) != len(b.Elements) {
+			return false
+		}
+		// a pair met again is assumed equal, if it isn't the comparison fails elsewhere
+		if comparing[comparison{a, b}] {
+			return true
+		}
+		if comparing == nil {
+			comparing = make(map[comparison]bool)
+		}
+		comparing[comparison{a, b}] = true
+		for i := range a.Elements {
+			if !equal(a.Elements[i], b.Elements[i], comparing) {
+				return false
+			}
+		}
+		return true
+	case *Hash:
+		b, ok := b.(*Hash)
+		if !ok || a.Len() != b.Len() {
+			return false
+		}
+		if comparing[comparison{a, b}] {
+			return true
+		}
+		if comparing == nil {
+			comparing = make(map[comparison]bool)
+		}
+		comparing[comparison{a, b}] = true
+		for _, pair := range a.pairs {
+			value, ok := b.Get(pair.Key.(Hashable))
+			if !ok || !equal(pair.Value, value, comparing) {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
@@ -0,0 +1,64 @@
+package object
+
+import "testing"
+
+func TestEqual(t *testing.T) {
+	hash := func(pairs ...Object) *Hash {
+		h := NewHash(len(pairs) / 2)
+		for i := 0; i < len(pairs); i += 2 {
+			h.Set(pairs[i].(Hashable), pairs[i+1])
+		}
+		return h
+	}
+	array := func(elements ...Object) *Array { return &Array{Elements: elements} }
+	builtin := &Builtin{}
+
+	tests := []struct {
+		a, b     Object
+		expected bool
+	}{
+		{NewInteger(2000), NewInteger(2000), true},
+		{NewInteger(1), &Float{Value: 1}, true},
+		{&Float{Value: 1.5}, NewInteger(1), false},
+		{&String{Value: "a"}, &String{Value: "a"}, true},
+		{&String{Value: "a"}, &String{Value: "b"}, false},
+		{NULL, &Null{}, true},
+		{NULL, FALSE, false},
+		{array(NewInteger(1), &String{Value: "a"}), array(NewInteger(1), &String{Value: "a"}), true},
+		{array(NewInteger(1)), array(NewInteger(1), NewInteger(2)), false},
+		{array(array()), array(array()), true},
+		{hash(&String{Value: "a"}, NewInteger(1), TRUE, array()), hash(TRUE, array(), &String{Value: "a"}, NewInteger(1)), true},
+		{hash(&String{Value: "a"}, NewInteger(1)), hash(&String{Value: "a"}, NewInteger(2)), false},
+		{hash(), array(), false},
+		{builtin, builtin, true},
+		{builtin, &Builtin{}, false},
+	}
+	for _, tt := range tests {
+		if got := Equal(tt.a, tt.b); got != tt.expected {
+			t.Errorf("Equal(%s, %s) = %t, want %t", tt.a.Inspect(), tt.b.Inspect(), got, tt.expected)
+		}
+	}
+}
+
+func TestEqualCycles(t *testing.T) {
+	a := &Array{Elements: []Object{NewInteger(1), nil}}
+	a.Elements[1] = a
+	b := &Array{Elements: []Object{NewInteger(1), nil}}
+	b.Elements[1] = b
+	if !Equal(a, b) {
+		t.Errorf("arrays containing themselves aren't equal")
+	}
+	c := &Array{Elements: []Object{NewInteger(2), nil}}
+	c.Elements[1] = c
+	if Equal(a, c) {
+		t.Errorf("arrays containing themselves with different elements are equal")
+	}
+
+	h := NewHash(1)
+	h.Set(&String{Value: "self"}, h)
+	g := NewHash(1)
+	g.Set(&String{Value: "self"}, g)
+	if !Equal(h, g) {
+		t.Errorf("hashes containing themselves aren't equal")
+	}
+}
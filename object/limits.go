@@ -0,0 +1,91 @@
+package object
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// Limits bound the work a script may do in one run. A zero field means no limit.
+// Exceeding a limit ends the run, but for the memory limit a script may catch with the try builtin.
+// The engines return the error from running the script and from calling a script function from Go,
+// the vms from RunWithContext, Run and Call, the evaluator from EvalWithContext and Call.
+type Limits struct {
+	MaxInstructions int64 // instructions the vm executes, nodes the evaluator evaluates
+	MaxCallDepth    int   // nested calls of script functions
+	MaxAllocBytes   int64 // approximate bytes of the strings, arrays and hashes a run creates
+	MaxStackSize    int   // values on the stack of the vm
+}
+
+var (
+	// ErrTimeout is returned when the deadline of the context a script runs with passes.
+	ErrTimeout = errors.New("execution timed out")
+	// ErrBudgetExceeded is returned when a script exceeds one of its Limits.
+	ErrBudgetExceeded = errors.New("execution budget exceeded")
+	// ErrMemoryLimitExceeded is returned when a script allocates more than Limits.MaxAllocBytes.
+	ErrMemoryLimitExceeded = errors.New("memory limit exceeded")
+	// ErrStackOverflow is returned when the calls of a script need more stack than the engine has.
+	ErrStackOverflow = errors.New("stack overflow")
+)
+
+// ContextCheckInterval is the number of instructions the engines execute between two checks of their context.
+const ContextCheckInterval = 1024
+
+// ContextError converts the error of a done context into the error the engines return.
+func ContextError(ctx context.Context) error {
+	err := ctx.Err()
+	if errors.Is(err, context.DeadlineExceeded) {
+		return fmt.Errorf("%w: %w", ErrTimeout, err)
+	}
+	return fmt.Errorf("execution canceled: %w", err)
+}
+
+// InstructionBudgetError is returned when a script executes more than max instructions.
+func InstructionBudgetError(max int64) error {
+	return fmt.Errorf("%w: more than %d instructions", ErrBudgetExceeded, max)
+}
+
+// CallDepthError is returned when the calls of a script nest deeper than max.
+func CallDepthError(max int) error {
+	return fmt.Errorf("%w: call depth exceeds %d", ErrBudgetExceeded, max)
+}
+
+// AllocationError is returned when a script allocates more than max bytes.
+func AllocationError(max int64) error {
+	return fmt.Errorf("%w: more than %d bytes allocated", ErrMemoryLimitExceeded, max)
+}
+
+// The sizes the engines account for new objects. They approximate what the Go runtime allocates
+// and are only meant to stop a script long before it exhausts the memory of the host.
+const (
+	objectHeaderSize = 16
+	referenceSize    = 16 // an Object interface value
+	hashPairSize     = 64 // a HashPair and its entry in the index of the hash
+)
+
+// StringSize is the accounted size of a string of length bytes.
+func StringSize(length int) int64 {
+	return objectHeaderSize + int64(length)
+}
+
+// ArraySize is the accounted size of an array of length elements.
+func ArraySize(length int) int64 {
+	return objectHeaderSize + referenceSize*int64(length)
+}
+
+// HashSize is the accounted size of a hash of length pairs.
+func HashSize(length int) int64 {
+	return objectHeaderSize + hashPairSize*int64(length)
+}
+
+// allocate accounts size bytes with the engine a builtin runs in.
+// Builtins called without an engine, by the host directly, aren't accounted.
+func allocate(ctx CallContext, size int64) *Error {
+	if ctx == nil {
+		return nil
+	}
+	if err := ctx.Allocate(size); err != nil {
+		return newError("%s", err)
+	}
+	return nil
+}
@@ -2,15 +2,34 @@ package object
 
 import (
 	"bytes"
+	"encoding/binary"
 	"fmt"
 	"hash/fnv"
 	"jonathan/ast"
 	"jonathan/code"
+	"strconv"
 	"strings"
 )
 
 type Type string
-type BuiltinFunction func(args ...Object) Object
+
+// BuiltinFunction receives the engine calling it as ctx, so it can call back into script functions.
+type BuiltinFunction func(ctx CallContext, args ...Object) Object
+
+// CallContext is implemented by the engines (vm.VM and evaluator.Evaluator).
+type CallContext interface {
+	// Call applies fn, a function of the engine or a builtin, to args and returns its result.
+	// An error means the call failed and the engine stops running the script.
+	Call(fn Object, args ...Object) (Object, error)
+	// TryCall is Call for a call the script handles the failure of. If the call exceeds the memory limit,
+	// the engine forgets the allocations of the call and goes on running the script, the error is only returned.
+	TryCall(fn Object, args ...Object) (Object, error)
+	// Allocate accounts size bytes a builtin is about to allocate against the memory limit of the engine.
+	// An error means the limit is exceeded and the engine stops running the script, or the TryCall it is in.
+	Allocate(size int64) error
+	// Random returns the random numbers of the current run, which start from DefaultRandomSeed.
+	Random() *RandomSource
+}
 
 const (
 	IntegerObj          = "INTEGER"
@@ -25,8 +44,28 @@ const (
 	HashObj             = "HASH"
 	CompiledFunctionObj = "COMPILED_FUNCTION_OBJ"
 	ClosureObj          = "CLOSUREOBJ"
+	FloatObj            = "FLOAT"
+)
+
+// TRUE, FALSE and NULL are the only instances the engines create, so they can be compared by identity.
+var (
+	TRUE  = &Boolean{Value: true}
+	FALSE = &Boolean{Value: false}
+	NULL  = &Null{}
 )
 
+// IsTruthy reports whether conditions take obj as true. Only false and null are false.
+func IsTruthy(obj Object) bool {
+	switch obj := obj.(type) {
+	case *Boolean:
+		return obj.Value
+	case *Null:
+		return false
+	default:
+		return true
+	}
+}
+
 type Object interface {
 	Type() Type
 	Inspect() string
@@ -39,6 +78,55 @@ type Integer struct {
 func (i *Integer) Type() Type      { return IntegerObj }
 func (i *Integer) Inspect() string { return fmt.Sprintf("%d", i.Value) }
 
+// The integers from MinCachedInteger to MaxCachedInteger are preallocated, NewInteger shares them
+// instead of allocating a new one for every result.
+const (
+	MinCachedInteger = -128
+	MaxCachedInteger = 1024
+)
+
+var smallIntegers = func() []Integer {
+	integers := make([]Integer, MaxCachedInteger-MinCachedInteger+1)
+	for i := range integers {
+		integers[i].Value = int64(i + MinCachedInteger)
+	}
+	return integers
+}()
+
+// NewInteger returns an integer of value. Integers are never modified, so small ones are shared.
+func NewInteger(value int64) *Integer {
+	if value >= MinCachedInteger && value <= MaxCachedInteger {
+		return &smallIntegers[value-MinCachedInteger]
+	}
+	return &Integer{Value: value}
+}
+
+type Float struct {
+	Value float64
+}
+
+func (f *Float) Type() Type      { return FloatObj }
+func (f *Float) Inspect() string { return strconv.FormatFloat(f.Value, 'g', -1, 64) }
+
+// FloatOperands returns the values of left and right as float64s if one of them is a float
+// and the other a float or an integer, so that arithmetic and comparisons on them are done in floats.
+func FloatOperands(left, right Object) (float64, float64, bool) {
+	var values [2]float64
+	isFloat := false
+	for i, operand := range [2]Object{left, right} {
+		switch operand := operand.(type) {
+		case *Integer:
+			values[i] = float64(operand.Value)
+		case *Float:
+			values[i] = operand.Value
+			isFloat = true
+		default:
+			return 0, 0, false
+		}
+	}
+	return values[0], values[1], isFloat
+}
+
 type Boolean struct {
 	Value bool
 }
@@ -95,12 +183,15 @@ func (s *String) Type() Type      { return StringObj }
 func (s *String) Inspect() string { return s.Value }
 
 type Builtin struct {
-	Fn BuiltinFunction
+	Fn    BuiltinFunction
+	Arity int // number of arguments Fn expects, VariadicArity if it checks them itself
 }
 
 func (b *Builtin) Type() Type      { return BuiltinObj }
 func (b *Builtin) Inspect() string { return "builtin function" }
 
+// Array is immutable, builtins like push return new arrays. That's what lets arrays be hash keys,
+// so host functions must not modify the elements of arrays they didn't create either.
 type Array struct {
 	Elements []Object
 }
@@ -135,6 +226,25 @@ func (b *Boolean) HashKey() HashKey {
 func (i *Integer) HashKey() HashKey {
 	return HashKey{ObjectType: i.Type(), Value: uint64(i.Value)}
 }
+func (n *Null) HashKey() HashKey {
+	return HashKey{ObjectType: n.Type()}
+}
+
+// HashKey combines the hash keys of the elements. Only arrays of hashable elements are keys, see AsHashable.
+func (ao *Array) HashKey() HashKey {
+	h := fnv.New64a()
+	var buf [8]byte
+	for _, e := range ao.Elements {
+		if e, ok := e.(Hashable); ok {
+			key := e.HashKey()
+			h.Write([]byte(key.ObjectType))
+			binary.BigEndian.PutUint64(buf[:], key.Value)
+			h.Write(buf[:])
+		}
+	}
+	return HashKey{ObjectType: ao.Type(), Value: h.Sum64()}
+}
+
 func (s *String) HashKey() HashKey {
 	h := fnv.New64a()
 	_, err := h.Write([]byte(s.Value))
@@ -149,8 +259,16 @@ type HashPair struct {
 	Value Object
 }
 
+// Hash maps hashable keys to values. Keys with the same HashKey are told apart by comparing them with Equal,
+// and the pairs are kept in the order their keys were first set, so Inspect and Pairs are deterministic.
 type Hash struct {
-	Pairs map[HashKey]HashPair
+	pairs   []HashPair
+	indexes map[HashKey][]int // the indexes into pairs of the keys with the HashKey
+}
+
+// NewHash returns an empty hash with room for size pairs.
+func NewHash(size int) *Hash {
+	return &Hash{pairs: make([]HashPair, 0, size), indexes: make(map[HashKey][]int, size)}
 }
 
 func (h *Hash) Type() Type { return HashObj }
@@ -158,7 +276,7 @@ func (h *Hash) Type() Type { return HashObj }
 func (h *Hash) Inspect() string {
 	var out bytes.Buffer
 	var pairs []string
-	for _, pair := range h.Pairs {
+	for _, pair := range h.pairs {
 		pairs = append(pairs, fmt.Sprintf("%s: %s",
 			pair.Key.Inspect(), pair.Value.Inspect()))
 	}
@@ -168,14 +286,66 @@ func (h *Hash) Inspect() string {
 	return out.String()
 }
 
+// Set binds key to value. A key already in the hash keeps its place in the order.
+func (h *Hash) Set(key Hashable, value Object) {
+	if h.indexes == nil {
+		h.indexes = make(map[HashKey][]int)
+	}
+	hashKey := key.HashKey()
+	for _, i := range h.indexes[hashKey] {
+		if Equal(h.pairs[i].Key, key) {
+			h.pairs[i].Value = value
+			return
+		}
+	}
+	h.indexes[hashKey] = append(h.indexes[hashKey], len(h.pairs))
+	h.pairs = append(h.pairs, HashPair{Key: key, Value: value})
+}
+
+// Get returns the value bound to key, false if the hash doesn't contain key.
+func (h *Hash) Get(key Hashable) (Object, bool) {
+	for _, i := range h.indexes[key.HashKey()] {
+		if Equal(h.pairs[i].Key, key) {
+			return h.pairs[i].Value, true
+		}
+	}
+	return nil, false
+}
+
+// Len returns the number of pairs.
+func (h *Hash) Len() int { return len(h.pairs) }
+
+// Pairs returns the pairs in the order their keys were first set. The slice belongs to the hash and must not be modified.
+func (h *Hash) Pairs() []HashPair { return h.pairs }
+
+// Hashable objects can be keys of a hash.
 type Hashable interface {
+	Object
 	HashKey() HashKey
 }
 
+// AsHashable returns obj as a hash key, false if obj can't be one.
+// Arrays implement Hashable but are keys only if all their elements are, nested arrays included.
+func AsHashable(obj Object) (Hashable, bool) {
+	key, ok := obj.(Hashable)
+	if !ok {
+		return nil, false
+	}
+	if array, isArray := obj.(*Array); isArray {
+		for _, e := range array.Elements {
+			if _, ok := AsHashable(e); !ok {
+				return nil, false
+			}
+		}
+	}
+	return key, true
+}
+
 type CompiledFunction struct {
 	Instructions  code.Instructions // one function include many instructions
 	NumLocals     int
 	NumParameters int
+	Name          string // the name the function was bound to by let, empty for anonymous functions
 }
 
 func (cf *CompiledFunction) Type() Type {
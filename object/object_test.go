@@ -17,3 +17,88 @@ func TestStringHashKey(t *testing.T) {
 		t.Errorf("strings with different content have same hash keys")
 	}
 }
+
+func TestNewInteger(t *testing.T) {
+	for _, value := range []int64{MinCachedInteger - 1, MinCachedInteger, -1, 0, 1, MaxCachedInteger, MaxCachedInteger + 1} {
+		integer := NewInteger(value)
+		if integer.Value != value {
+			t.Errorf("wrong value. want=%d, got=%d", value, integer.Value)
+		}
+		cached := value >= MinCachedInteger && value <= MaxCachedInteger
+		if shared := NewInteger(value) == integer; shared != cached {
+			t.Errorf("integer %d shared=%t, want %t", value, shared, cached)
+		}
+	}
+}
+
+// collidingKey hashes to the same HashKey as every other collidingKey
+type collidingKey struct{ name string }
+
+func (k *collidingKey) Type() Type       { return "COLLIDING" }
+func (k *collidingKey) Inspect() string  { return k.name }
+func (k *collidingKey) HashKey() HashKey { return HashKey{ObjectType: "COLLIDING", Value: 1} }
+
+func TestHash(t *testing.T) {
+	hash := NewHash(0)
+	a, b := &collidingKey{name: "a"}, &collidingKey{name: "b"}
+	hash.Set(a, NewInteger(1))
+	hash.Set(&String{Value: "x"}, NewInteger(2))
+	hash.Set(b, NewInteger(3))
+	hash.Set(&String{Value: "x"}, NewInteger(4))
+
+	tests := []struct {
+		key      Hashable
+		expected int64
+	}{
+		{a, 1},
+		{b, 3},
+		{&String{Value: "x"}, 4},
+	}
+	for _, tt := range tests {
+		value, ok := hash.Get(tt.key)
+		if !ok {
+			t.Errorf("key %s missing", tt.key.Inspect())
+			continue
+		}
+		if value.(*Integer).Value != tt.expected {
+			t.Errorf("wrong value of %s. want=%d, got=%d", tt.key.Inspect(), tt.expected, value.(*Integer).Value)
+		}
+	}
+	if _, ok := hash.Get(&collidingKey{name: "a"}); ok {
+		t.Errorf("another key with the same HashKey found")
+	}
+	if hash.Len() != 3 {
+		t.Errorf("wrong length. want=3, got=%d", hash.Len())
+	}
+	if got := hash.Inspect(); got != "{a: 1, x: 4, b: 3}" {
+		t.Errorf("wrong order. got=%s", got)
+	}
+}
+
+func TestAsHashable(t *testing.T) {
+	tests := []struct {
+		key      Object
+		hashable bool
+	}{
+		{NULL, true},
+		{&Array{}, true},
+		{&Array{Elements: []Object{NewInteger(1), &Array{Elements: []Object{&String{Value: "a"}, TRUE}}}}, true},
+		{&Array{Elements: []Object{NewInteger(1), &Hash{}}}, false},
+		{&Array{Elements: []Object{&Array{Elements: []Object{&Builtin{}}}}}, false},
+		{&Hash{}, false},
+	}
+	for _, tt := range tests {
+		if _, ok := AsHashable(tt.key); ok != tt.hashable {
+			t.Errorf("AsHashable(%s) returned %t, want %t", tt.key.Inspect(), ok, tt.hashable)
+		}
+	}
+	a := &Array{Elements: []Object{NewInteger(1), &String{Value: "b"}}}
+	b := &Array{Elements: []Object{NewInteger(1), &String{Value: "b"}}}
+	if a.HashKey() != b.HashKey() {
+		t.Errorf("arrays with equal elements have different hash keys")
+	}
+	c := &Array{Elements: []Object{&String{Value: "b"}, NewInteger(1)}}
+	if a.HashKey() == c.HashKey() {
+		t.Errorf("arrays with different elements have the same hash key")
+	}
+}
@@ -0,0 +1,69 @@
+package object
+
+import "fmt"
+
+// CharAt returns the i-th character of s as a string, counting runes rather than bytes.
+// It returns false if s has no such character.
+func CharAt(s string, i int64) (*String, bool) {
+	if i < 0 {
+		return nil, false
+	}
+	var n int64
+	for _, r := range s {
+		if n == i {
+			return &String{Value: string(r)}, true
+		}
+		n++
+	}
+	return nil, false
+}
+
+// Slice returns the elements of an array or the characters of a string from start up to, not including, end.
+// The bounds are integers or null, which leaves them at the beginning and the end. Negative bounds count
+// from the end and bounds out of range are clamped, so only bounds that aren't integers fail.
+func Slice(left, start, end Object) (Object, error) {
+	switch left := left.(type) {
+	case *Array:
+		from, to, err := sliceBounds(start, end, len(left.Elements))
+		if err != nil {
+			return nil, err
+		}
+		elements := make([]Object, to-from)
+		copy(elements, left.Elements[from:to])
+		return &Array{Elements: elements}, nil
+	case *String:
+		runes := []rune(left.Value)
+		from, to, err := sliceBounds(start, end, len(runes))
+		if err != nil {
+			return nil, err
+		}
+		return &String{Value: string(runes[from:to])}, nil
+	}
+	return nil, fmt.Errorf("slice operator not supported: %s", left.Type())
+}
+
+func sliceBounds(start, end Object, length int) (int, int, error) {
+	from, err := sliceBound(start, 0, length)
+	if err != nil {
+		return 0, 0, err
+	}
+	to, err := sliceBound(end, length, length)
+	if err != nil {
+		return 0, 0, err
+	}
+	return min(from, to), to, nil
+}
+
+func sliceBound(bound Object, absent, length int) (int, error) {
+	switch bound := bound.(type) {
+	case *Null:
+		return absent, nil
+	case *Integer:
+		i := bound.Value
+		if i < 0 {
+			i += int64(length)
+		}
+		return int(min(max(i, 0), int64(length))), nil
+	}
+	return 0, fmt.Errorf("slice bound must be INTEGER, got %s", bound.Type())
+}
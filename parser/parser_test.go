@@ -184,6 +184,38 @@ func TestIntegerLiteralExpression(t *testing.T) {
 	}
 }
 
+func TestFloatLiteralExpression(t *testing.T) {
+	input := "2.5;"
+	l := lexer.NewLexer(input)
+	p := NewParser(l)
+
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	if len(program.Statements) != 1 {
+		t.Fatalf("program has not enough statements. got=%d",
+			len(program.Statements))
+	}
+
+	stmt, ok := program.Statements[0].(*ast.ExpressionStatement)
+	if !ok {
+		t.Fatalf("program.Statements[0] is not ast.ExpressionStatement. got=%T",
+			program.Statements[0])
+	}
+
+	literal, ok := stmt.Expression.(*ast.FloatLiteral)
+	if !ok {
+		t.Fatalf("exp not *ast.FloatLiteral. got=%T", stmt.Expression)
+	}
+	if literal.Value != 2.5 {
+		t.Errorf("literal.Value not %g. got=%g", 2.5, literal.Value)
+	}
+	if literal.TokenLiteral() != "2.5" {
+		t.Errorf("literal.TokenLiteral not %s. got=%s", "2.5",
+			literal.TokenLiteral())
+	}
+}
+
 func TestParsingPrefixExpressions(t *testing.T) {
 	prefixTests := []struct {
 		input        string
@@ -727,6 +759,28 @@ func TestParsingIndexExpressions(t *testing.T) {
 	}
 }
 
+func TestParsingSliceExpressions(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"a[1:2]", "(a[1:2])"},
+		{"a[1 + 1:]", "(a[(1 + 1):])"},
+		{"a[:-1]", "(a[:(-1)])"},
+		{"a[:]", "(a[:])"},
+		{"a[1:2][0]", "((a[1:2])[0])"},
+	}
+	for _, tt := range tests {
+		l := lexer.NewLexer(tt.input)
+		p := NewParser(l)
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+		if actual := program.String(); actual != tt.expected {
+			t.Errorf("expected=%q, got=%q", tt.expected, actual)
+		}
+	}
+}
+
 func TestParsingHashLiteralsStringKeys(t *testing.T) {
 	input := `{"one": 1, "two": 2, "three": 3}`
 	l := lexer.NewLexer(input)
@@ -832,3 +886,60 @@ func TestFunctionLiteralWithNAme(t *testing.T) {
 		t.Fatalf("function literal name wrong. want 'myFunction', got=%q\n", function.Name)
 	}
 }
+
+func TestTailCallMarking(t *testing.T) {
+	input := `
+	fn(x) {
+		a(x);
+		let y = b(x);
+		if (x) { return c(x) } else { d(x) };
+		if (y) { e(x) } else { f(g(x)) }
+	};
+	h(1);`
+	expected := map[string]bool{
+		"a(x)": false, "b(x)": false, "c(x)": true, "d(x)": false,
+		"e(x)": true, "f(g(x))": true, "g(x)": false, "h(1)": false,
+	}
+	l := lexer.NewLexer(input)
+	p := NewParser(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	calls := map[string]bool{}
+	var collect func(node ast.Node)
+	collect = func(node ast.Node) {
+		switch node := node.(type) {
+		case *ast.ExpressionStatement:
+			collect(node.Expression)
+		case *ast.LetStatement:
+			collect(node.Value)
+		case *ast.ReturnStatement:
+			collect(node.ReturnValue)
+		case *ast.BlockStatement:
+			for _, s := range node.Statements {
+				collect(s)
+			}
+		case *ast.IfExpression:
+			collect(node.Consequence)
+			collect(node.Alternative)
+		case *ast.FunctionLiteral:
+			collect(node.Body)
+		case *ast.CallExpression:
+			calls[node.String()] = node.Tail
+			for _, a := range node.Arguments {
+				collect(a)
+			}
+		}
+	}
+	for _, s := range program.Statements {
+		collect(s)
+	}
+	if len(calls) != len(expected) {
+		t.Fatalf("wrong number of calls. want=%d, got=%d", len(expected), len(calls))
+	}
+	for call, tail := range expected {
+		if calls[call] != tail {
+			t.Errorf("call %s: wrong tail mark. want=%t, got=%t", call, tail, calls[call])
+		}
+	}
+}
@@ -0,0 +1,163 @@
+package register
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"jonathan/code"
+)
+
+// Instructions of the register vm. Every instruction names the registers it reads and writes,
+// registers are the slots of the frame of the running function.
+type Instructions []byte
+type Opcode byte
+
+const (
+	OpLoadConstant Opcode = iota
+	OpLoadTrue
+	OpLoadFalse
+	OpLoadNull
+	OpMove
+
+	OpGetGlobal
+	OpSetGlobal
+	OpGetBuiltin
+	OpGetFree
+	OpCurrentClosure
+
+	OpAdd
+	OpSub
+	OpMul
+	OpDiv
+	OpEqual
+	OpNotEqual
+	OpGreaterThan
+	OpMinus
+	OpBang
+
+	OpJump
+	OpJumpNotTruthy
+
+	OpArray
+	OpHash
+	OpIndex
+	OpSlice
+
+	OpCall
+	OpTailCall
+	OpReturn
+	OpClosure
+
+	OpSetResult
+)
+
+// the widths of the kinds of operands
+const (
+	registerWidth = 2
+	constantWidth = 4
+	positionWidth = 4
+	indexWidth    = 2 // globals, builtins and free variables
+	countWidth    = 2
+)
+
+// MaxRegisters is the number of registers a function can use.
+const MaxRegisters = 1 << (8 * registerWidth)
+
+var definitions = map[Opcode]*code.Definition{
+	OpLoadConstant:   {Name: "OpLoadConstant", OperandWidths: []int{registerWidth, constantWidth}}, // dst, constant index
+	OpLoadTrue:       {Name: "OpLoadTrue", OperandWidths: []int{registerWidth}},
+	OpLoadFalse:      {Name: "OpLoadFalse", OperandWidths: []int{registerWidth}},
+	OpLoadNull:       {Name: "OpLoadNull", OperandWidths: []int{registerWidth}},
+	OpMove:           {Name: "OpMove", OperandWidths: []int{registerWidth, registerWidth}}, // dst, src
+	OpGetGlobal:      {Name: "OpGetGlobal", OperandWidths: []int{registerWidth, indexWidth}},
+	OpSetGlobal:      {Name: "OpSetGlobal", OperandWidths: []int{indexWidth, registerWidth}},
+	OpGetBuiltin:     {Name: "OpGetBuiltin", OperandWidths: []int{registerWidth, indexWidth}},
+	OpGetFree:        {Name: "OpGetFree", OperandWidths: []int{registerWidth, indexWidth}},
+	OpCurrentClosure: {Name: "OpCurrentClosure", OperandWidths: []int{registerWidth}},
+	OpAdd:            {Name: "OpAdd", OperandWidths: []int{registerWidth, registerWidth, registerWidth}}, // dst, left, right
+	OpSub:            {Name: "OpSub", OperandWidths: []int{registerWidth, registerWidth, registerWidth}},
+	OpMul:            {Name: "OpMul", OperandWidths: []int{registerWidth, registerWidth, registerWidth}},
+	OpDiv:            {Name: "OpDiv", OperandWidths: []int{registerWidth, registerWidth, registerWidth}},
+	OpEqual:          {Name: "OpEqual", OperandWidths: []int{registerWidth, registerWidth, registerWidth}},
+	OpNotEqual:       {Name: "OpNotEqual", OperandWidths: []int{registerWidth, registerWidth, registerWidth}},
+	OpGreaterThan:    {Name: "OpGreaterThan", OperandWidths: []int{registerWidth, registerWidth, registerWidth}},
+	OpMinus:          {Name: "OpMinus", OperandWidths: []int{registerWidth, registerWidth}}, // dst, operand
+	OpBang:           {Name: "OpBang", OperandWidths: []int{registerWidth, registerWidth}},
+	OpJump:           {Name: "OpJump", OperandWidths: []int{positionWidth}},
+	OpJumpNotTruthy:  {Name: "OpJumpNotTruthy", OperandWidths: []int{registerWidth, positionWidth}},     // condition, position
+	OpArray:          {Name: "OpArray", OperandWidths: []int{registerWidth, registerWidth, countWidth}}, // dst, first element, number of elements
+	OpHash:           {Name: "OpHash", OperandWidths: []int{registerWidth, registerWidth, countWidth}},  // dst, first key, number of keys and values
+	OpIndex:          {Name: "OpIndex", OperandWidths: []int{registerWidth, registerWidth, registerWidth}},
+	OpSlice:          {Name: "OpSlice", OperandWidths: []int{registerWidth, registerWidth, registerWidth, registerWidth}}, // dst, sliced value, start, end
+	OpCall:           {Name: "OpCall", OperandWidths: []int{registerWidth, registerWidth, countWidth}},                    // dst, callee followed by the arguments, number of arguments
+	OpTailCall:       {Name: "OpTailCall", OperandWidths: []int{registerWidth, countWidth}},                               // callee followed by the arguments, number of arguments
+	OpReturn:         {Name: "OpReturn", OperandWidths: []int{registerWidth}},
+	OpClosure:        {Name: "OpClosure", OperandWidths: []int{registerWidth, constantWidth, registerWidth, countWidth}}, // dst, function, first free variable, number of free variables
+	OpSetResult:      {Name: "OpSetResult", OperandWidths: []int{registerWidth}},                                         // the value of an expression statement of the main program
+}
+
+func Lookup(op byte) (*code.Definition, error) {
+	def, ok := definitions[Opcode(op)]
+	if !ok {
+		return nil, fmt.Errorf("opcode %d undefined", op)
+	}
+	return def, nil
+}
+
+// Make makes the instruction, it panics on an operand that doesn't fit. The compiler checks them beforehand.
+func Make(op Opcode, operands ...int) []byte {
+	def, ok := definitions[op]
+	if !ok {
+		return []byte{}
+	}
+	instructionLen := 1
+	for _, w := range def.OperandWidths {
+		instructionLen += w
+	}
+	instruction := make([]byte, instructionLen)
+	instruction[0] = byte(op)
+	offset := 1
+	for i, o := range operands {
+		width := def.OperandWidths[i]
+		if o < 0 || o >= 1<<(8*width) {
+			panic(fmt.Sprintf("operand %d of %s out of range: %d", i, def.Name, o))
+		}
+		switch width {
+		case 4:
+			binary.BigEndian.PutUint32(instruction[offset:], uint32(o))
+		case 2:
+			binary.BigEndian.PutUint16(instruction[offset:], uint16(o))
+		}
+		offset += width
+	}
+	return instruction
+}
+
+// print instructions
+func (ins Instructions) String() string {
+	var out bytes.Buffer
+	for i := 0; i < len(ins); {
+		def, err := Lookup(ins[i])
+		if err != nil {
+			fmt.Fprintf(&out, "ERROR: %s\n", err)
+			i++
+			continue
+		}
+		operands, read := code.ReadOperands(def, code.Instructions(ins[i+1:]))
+		fmt.Fprintf(&out, "%04d %s", i, def.Name)
+		for _, o := range operands {
+			fmt.Fprintf(&out, " %d", o)
+		}
+		out.WriteString("\n")
+		i += 1 + read
+	}
+	return out.String()
+}
+
+func readRegister(ins []byte, offset int) int {
+	return int(ins[offset])<<8 | int(ins[offset+1])
+}
+
+func readUint32(ins []byte, offset int) int {
+	return int(binary.BigEndian.Uint32(ins[offset:]))
+}
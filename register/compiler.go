@@ -0,0 +1,511 @@
+package register
+
+import (
+	"fmt"
+	"jonathan/ast"
+	"jonathan/code"
+	"jonathan/compiler"
+	"jonathan/object"
+)
+
+// Compiler compiles a program to the instructions of the register vm.
+// The parameters and let bindings of a function get a register each, in the order the symbol table
+// numbers them, the temporaries of expressions use the registers after those. Temporaries are
+// allocated like a stack: an expression frees the ones it used before it returns.
+type Compiler struct {
+	constants   []object.Object
+	symbolTable *compiler.SymbolTable
+	builtins    *object.BuiltinRegistry
+	strings     map[string]int // the index of every string constant
+	scopes      []compilationScope
+	scopeIndex  int
+	err         error // the first register that doesn't fit in an operand
+}
+
+type compilationScope struct {
+	instructions Instructions
+	next         int // the lowest free register
+	numRegisters int // the registers the function needs
+}
+
+type Bytecode struct {
+	Instructions Instructions
+	NumRegisters int // the registers of the main program
+	Constants    []object.Object
+	Builtins     *object.BuiltinRegistry
+}
+
+func NewCompiler() *Compiler {
+	return NewCompilerWithBuiltins(object.NewBuiltinRegistry())
+}
+
+// NewCompilerWithBuiltins compiles against the given registry instead of the default builtins.
+func NewCompilerWithBuiltins(builtins *object.BuiltinRegistry) *Compiler {
+	symbolTable := compiler.NewSymbolTable()
+	for i, v := range builtins.Definitions() {
+		symbolTable.DefineBuiltin(i, v.Name)
+	}
+	return &Compiler{
+		constants:   []object.Object{},
+		symbolTable: symbolTable,
+		builtins:    builtins,
+		strings:     map[string]int{},
+		scopes:      []compilationScope{{}},
+	}
+}
+
+func (c *Compiler) Compile(program *ast.Program) error {
+	for _, s := range program.Statements {
+		expression, ok := s.(*ast.ExpressionStatement)
+		if !ok {
+			err := c.compileStatement(s)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		mark := c.scope().next
+		r, err := c.operand(expression.Expression)
+		if err != nil {
+			return err
+		}
+		c.emit(OpSetResult, r)
+		c.scope().next = mark
+	}
+	return c.err
+}
+
+func (c *Compiler) Bytecode() *Bytecode {
+	return &Bytecode{
+		Instructions: c.scope().instructions,
+		NumRegisters: c.scope().numRegisters,
+		Constants:    c.constants,
+		Builtins:     c.builtins,
+	}
+}
+
+func (c *Compiler) compileStatement(s ast.Statement) error {
+	mark := c.scope().next
+	defer func() { c.scope().next = mark }()
+	switch s := s.(type) {
+	case *ast.ExpressionStatement:
+		_, err := c.operand(s.Expression)
+		return err
+	case *ast.LetStatement:
+		// The function body could use the symbol before the body is compiled
+		symbol := c.symbolTable.DefineSymbol(s.Name.Value)
+		if symbol.Scope == compiler.LocalScope {
+			return c.compileExpression(s.Value, symbol.Index)
+		}
+		if symbol.Index >= compiler.MaxGlobals {
+			return fmt.Errorf("too many global bindings: %s is number %d, the limit is %d", symbol.Name, symbol.Index+1, compiler.MaxGlobals)
+		}
+		r, err := c.operand(s.Value)
+		if err != nil {
+			return err
+		}
+		c.emit(OpSetGlobal, symbol.Index, r)
+	case *ast.ReturnStatement:
+		r, err := c.operand(s.ReturnValue)
+		if err != nil {
+			return err
+		}
+		c.emit(OpReturn, r)
+	}
+	return nil
+}
+
+// compileBlock compiles the statements of block and puts the value of the last one into dst
+func (c *Compiler) compileBlock(block *ast.BlockStatement, dst int) error {
+	statements := block.Statements
+	if len(statements) == 0 {
+		c.emit(OpLoadNull, dst)
+		return nil
+	}
+	for _, s := range statements[:len(statements)-1] {
+		err := c.compileStatement(s)
+		if err != nil {
+			return err
+		}
+	}
+	if last, ok := statements[len(statements)-1].(*ast.ExpressionStatement); ok {
+		return c.compileExpression(last.Expression, dst)
+	}
+	err := c.compileStatement(statements[len(statements)-1])
+	if err != nil {
+		return err
+	}
+	if !endsWithReturn(block) {
+		c.emit(OpLoadNull, dst)
+	}
+	return nil
+}
+
+func endsWithReturn(block *ast.BlockStatement) bool {
+	if len(block.Statements) == 0 {
+		return false
+	}
+	_, ok := block.Statements[len(block.Statements)-1].(*ast.ReturnStatement)
+	return ok
+}
+
+// operand returns the register holding the value of expression. Locals are read where they are,
+// everything else is compiled into a new temporary.
+func (c *Compiler) operand(expression ast.Expression) (int, error) {
+	if identifier, ok := expression.(*ast.Identifier); ok {
+		symbol, ok := c.symbolTable.Resolve(identifier.Value)
+		if ok && symbol.Scope == compiler.LocalScope {
+			return symbol.Index, nil
+		}
+	}
+	r := c.allocate()
+	return r, c.compileExpression(expression, r)
+}
+
+// compileExpression compiles expression so its value ends up in dst
+func (c *Compiler) compileExpression(expression ast.Expression, dst int) error {
+	mark := c.scope().next
+	defer func() { c.scope().next = mark }()
+	switch node := expression.(type) {
+	case *ast.IntegerLiteral:
+		c.emit(OpLoadConstant, dst, c.addConstant(&object.Integer{Value: node.Value}))
+	case *ast.FloatLiteral:
+		c.emit(OpLoadConstant, dst, c.addConstant(&object.Float{Value: node.Value}))
+	case *ast.StringLiteral:
+		c.emit(OpLoadConstant, dst, c.addConstant(&object.String{Value: node.Value}))
+	case *ast.Boolean:
+		if node.Value {
+			c.emit(OpLoadTrue, dst)
+		} else {
+			c.emit(OpLoadFalse, dst)
+		}
+	case *ast.Identifier:
+		symbol, ok := c.symbolTable.Resolve(node.Value)
+		if !ok {
+			if constant, ok := c.builtins.Constant(node.Value); ok {
+				c.emit(OpLoadConstant, dst, c.addConstant(constant))
+				return nil
+			}
+			return fmt.Errorf("undefined variable %s", node.Value)
+		}
+		c.loadSymbol(symbol, dst)
+	case *ast.PrefixExpression:
+		right, err := c.operand(node.Right)
+		if err != nil {
+			return err
+		}
+		switch node.Operator {
+		case "!":
+			c.emit(OpBang, dst, right)
+		case "-":
+			c.emit(OpMinus, dst, right)
+		default:
+			return fmt.Errorf("unknown operator %s", node.Operator)
+		}
+	case *ast.InfixExpression:
+		return c.compileInfix(node, dst)
+	case *ast.IfExpression:
+		condition, err := c.operand(node.Condition)
+		if err != nil {
+			return err
+		}
+		c.scope().next = mark
+		jumpNotTruthyPos := c.emit(OpJumpNotTruthy, condition, 0)
+		err = c.compileBlock(node.Consequence, dst)
+		if err != nil {
+			return err
+		}
+		jumpPos := c.emit(OpJump, 0)
+		c.changeJump(jumpNotTruthyPos, 1+registerWidth)
+		if node.Alternative == nil {
+			c.emit(OpLoadNull, dst)
+		} else {
+			err := c.compileBlock(node.Alternative, dst)
+			if err != nil {
+				return err
+			}
+		}
+		c.changeJump(jumpPos, 1)
+	case *ast.ArrayLiteral:
+		first, err := c.compileSequence(node.Elements)
+		if err != nil {
+			return err
+		}
+		c.emit(OpArray, dst, first, len(node.Elements))
+	case *ast.HashLiteral:
+		var elements []ast.Expression
+		for _, k := range node.Keys {
+			elements = append(elements, k, node.Pairs[k])
+		}
+		first, err := c.compileSequence(elements)
+		if err != nil {
+			return err
+		}
+		c.emit(OpHash, dst, first, len(elements))
+	case *ast.IndexExpression:
+		left, err := c.operand(node.Left)
+		if err != nil {
+			return err
+		}
+		index, err := c.operand(node.Index)
+		if err != nil {
+			return err
+		}
+		c.emit(OpIndex, dst, left, index)
+	case *ast.SliceExpression:
+		left, err := c.operand(node.Left)
+		if err != nil {
+			return err
+		}
+		// a bound left out is null
+		var bounds [2]int
+		for i, bound := range []ast.Expression{node.Start, node.End} {
+			if bound == nil {
+				bounds[i] = c.allocate()
+				c.emit(OpLoadNull, bounds[i])
+				continue
+			}
+			bounds[i], err = c.operand(bound)
+			if err != nil {
+				return err
+			}
+		}
+		c.emit(OpSlice, dst, left, bounds[0], bounds[1])
+	case *ast.CallExpression:
+		// the callee and its arguments are in consecutive registers, they become the parameters of the callee
+		callee, err := c.compileSequence(append([]ast.Expression{node.Function}, node.Arguments...))
+		if err != nil {
+			return err
+		}
+		if node.Tail && c.scopeIndex > 0 {
+			c.emit(OpTailCall, callee, len(node.Arguments))
+		} else {
+			c.emit(OpCall, dst, callee, len(node.Arguments))
+		}
+	case *ast.FunctionLiteral:
+		return c.compileFunction(node, dst)
+	default:
+		return fmt.Errorf("unsupported expression %T", expression)
+	}
+	return nil
+}
+
+func (c *Compiler) compileInfix(node *ast.InfixExpression, dst int) error {
+	left, right := node.Left, node.Right
+	if node.Operator == "<" {
+		left, right = right, left
+	}
+	l, err := c.operand(left)
+	if err != nil {
+		return err
+	}
+	r, err := c.operand(right)
+	if err != nil {
+		return err
+	}
+	switch node.Operator {
+	case "+":
+		c.emit(OpAdd, dst, l, r)
+	case "-":
+		c.emit(OpSub, dst, l, r)
+	case "*":
+		c.emit(OpMul, dst, l, r)
+	case "/":
+		c.emit(OpDiv, dst, l, r)
+	case ">", "<":
+		c.emit(OpGreaterThan, dst, l, r)
+	case "==":
+		c.emit(OpEqual, dst, l, r)
+	case "!=":
+		c.emit(OpNotEqual, dst, l, r)
+	default:
+		return fmt.Errorf("unknown operator %s", node.Operator)
+	}
+	return nil
+}
+
+// compileSequence compiles expressions into consecutive new registers and returns the first of them.
+func (c *Compiler) compileSequence(expressions []ast.Expression) (int, error) {
+	first := c.scope().next
+	registers := make([]int, len(expressions))
+	for i := range expressions {
+		registers[i] = c.allocate()
+	}
+	for i, e := range expressions {
+		err := c.compileExpression(e, registers[i])
+		if err != nil {
+			return 0, err
+		}
+	}
+	return first, nil
+}
+
+func (c *Compiler) compileFunction(node *ast.FunctionLiteral, dst int) error {
+	c.enterScope(len(node.Parameters) + countLets(node.Body))
+	if node.Name != "" {
+		c.symbolTable.DefineFunctionName(node.Name)
+	}
+	for _, p := range node.Parameters {
+		c.symbolTable.DefineSymbol(p.Value)
+	}
+	result := c.allocate()
+	err := c.compileBlock(node.Body, result)
+	if err != nil {
+		return err
+	}
+	if !endsWithReturn(node.Body) {
+		c.emit(OpReturn, result)
+	}
+	freeSymbols := c.symbolTable.FreeSymbols
+	instructions, numRegisters := c.leaveScope()
+	compiledFn := &object.CompiledFunction{
+		Instructions:  code.Instructions(instructions),
+		NumLocals:     numRegisters,
+		NumParameters: len(node.Parameters),
+		Name:          node.Name,
+	}
+	first := c.scope().next
+	for _, s := range freeSymbols {
+		c.loadSymbol(s, c.allocate())
+	}
+	c.emit(OpClosure, dst, c.addConstant(compiledFn), first, len(freeSymbols))
+	return nil
+}
+
+func (c *Compiler) loadSymbol(s compiler.Symbol, dst int) {
+	switch s.Scope {
+	case compiler.GlobalScope:
+		c.emit(OpGetGlobal, dst, s.Index)
+	case compiler.LocalScope:
+		if s.Index != dst {
+			c.emit(OpMove, dst, s.Index)
+		}
+	case compiler.BuiltinScope:
+		c.emit(OpGetBuiltin, dst, s.Index)
+	case compiler.FreeScope:
+		c.emit(OpGetFree, dst, s.Index)
+	case compiler.FunctionScope:
+		c.emit(OpCurrentClosure, dst)
+	}
+}
+
+func (c *Compiler) scope() *compilationScope {
+	return &c.scopes[c.scopeIndex]
+}
+
+// allocate returns the lowest free register
+func (c *Compiler) allocate() int {
+	scope := c.scope()
+	r := scope.next
+	if r >= MaxRegisters && c.err == nil {
+		c.err = fmt.Errorf("too many registers: a function can use %d", MaxRegisters)
+	}
+	scope.next++
+	scope.numRegisters = max(scope.numRegisters, scope.next)
+	return r
+}
+
+// addConstant interns strings like the compiler of the stack vm, so equal literals share one object
+func (c *Compiler) addConstant(obj object.Object) int {
+	str, ok := obj.(*object.String)
+	if ok {
+		if index, ok := c.strings[str.Value]; ok {
+			return index
+		}
+	}
+	c.constants = append(c.constants, obj)
+	if ok {
+		c.strings[str.Value] = len(c.constants) - 1
+	}
+	return len(c.constants) - 1
+}
+
+// emit appends the instruction and returns its position. Instructions with registers that don't fit
+// aren't emitted, Compile returns the error allocate recorded instead.
+func (c *Compiler) emit(op Opcode, operands ...int) int {
+	scope := c.scope()
+	pos := len(scope.instructions)
+	if c.err != nil {
+		return pos
+	}
+	scope.instructions = append(scope.instructions, Make(op, operands...)...)
+	return pos
+}
+
+// changeJump points the jump at pos, whose target is offset bytes into the instruction, to the end of the instructions
+func (c *Compiler) changeJump(pos int, offset int) {
+	instructions := c.scope().instructions
+	if c.err != nil {
+		return
+	}
+	target := len(instructions)
+	instructions[pos+offset] = byte(target >> 24)
+	instructions[pos+offset+1] = byte(target >> 16)
+	instructions[pos+offset+2] = byte(target >> 8)
+	instructions[pos+offset+3] = byte(target)
+}
+
+// enterScope starts a function whose parameters and let bindings take the first numLocals registers
+func (c *Compiler) enterScope(numLocals int) {
+	c.scopes = append(c.scopes, compilationScope{next: numLocals, numRegisters: numLocals})
+	c.scopeIndex++
+	c.symbolTable = compiler.NewEnclosedSymbolTable(c.symbolTable)
+}
+
+func (c *Compiler) leaveScope() (Instructions, int) {
+	scope := c.scope()
+	c.scopes = c.scopes[:len(c.scopes)-1]
+	c.scopeIndex--
+	c.symbolTable = c.symbolTable.Outer
+	return scope.instructions, scope.numRegisters
+}
+
+// countLets counts the let statements of a function body, the nested functions have registers of their own
+func countLets(node ast.Node) int {
+	count := 0
+	switch node := node.(type) {
+	case *ast.BlockStatement:
+		for _, s := range node.Statements {
+			count += countLets(s)
+		}
+	case *ast.LetStatement:
+		count = 1 + countLets(node.Value)
+	case *ast.ExpressionStatement:
+		count = countLets(node.Expression)
+	case *ast.ReturnStatement:
+		count = countLets(node.ReturnValue)
+	case *ast.PrefixExpression:
+		count = countLets(node.Right)
+	case *ast.InfixExpression:
+		count = countLets(node.Left) + countLets(node.Right)
+	case *ast.IfExpression:
+		count = countLets(node.Condition) + countLets(node.Consequence)
+		if node.Alternative != nil {
+			count += countLets(node.Alternative)
+		}
+	case *ast.CallExpression:
+		count = countLets(node.Function)
+		for _, a := range node.Arguments {
+			count += countLets(a)
+		}
+	case *ast.ArrayLiteral:
+		for _, el := range node.Elements {
+			count += countLets(el)
+		}
+	case *ast.HashLiteral:
+		for k, v := range node.Pairs {
+			count += countLets(k) + countLets(v)
+		}
+	case *ast.IndexExpression:
+		count = countLets(node.Left) + countLets(node.Index)
+	case *ast.SliceExpression:
+		count = countLets(node.Left)
+		if node.Start != nil {
+			count += countLets(node.Start)
+		}
+		if node.End != nil {
+			count += countLets(node.End)
+		}
+	}
+	return count
+}
@@ -0,0 +1,105 @@
+package register
+
+import (
+	"jonathan/ast"
+	"jonathan/lexer"
+	"jonathan/object"
+	"jonathan/parser"
+	"testing"
+)
+
+func parse(input string) *ast.Program {
+	l := lexer.NewLexer(input)
+	p := parser.NewParser(l)
+	return p.ParseProgram()
+}
+
+func TestRegisterAllocation(t *testing.T) {
+	tests := []struct {
+		input string
+		main  string
+		fn    string // the instructions of the last function, if any
+	}{
+		{
+			input: "1 + 2 * 3",
+			main: `0000 OpLoadConstant 1 0
+0007 OpLoadConstant 3 1
+0014 OpLoadConstant 4 2
+0021 OpMul 2 3 4
+0028 OpAdd 0 1 2
+0035 OpSetResult 0
+`,
+		},
+		{
+			// the parameters and the let binding are read where they are, the temporaries come after them
+			input: "let f = fn(a, b) { let c = a * b; if (c > 1) { c } else { a } }; f(1, 2)",
+			main: `0000 OpClosure 0 1 1 0
+0011 OpSetGlobal 0 0
+0016 OpGetGlobal 1 0
+0021 OpLoadConstant 2 2
+0028 OpLoadConstant 3 3
+0035 OpCall 0 1 2
+0042 OpSetResult 0
+`,
+			fn: `0000 OpMul 2 0 1
+0007 OpLoadConstant 5 0
+0014 OpGreaterThan 4 2 5
+0021 OpJumpNotTruthy 4 38
+0028 OpMove 3 2
+0033 OpJump 43
+0038 OpMove 3 0
+0043 OpReturn 3
+`,
+		},
+		{
+			input: "fn(x) { fn() { x } }",
+			main: `0000 OpClosure 0 1 1 0
+0011 OpSetResult 0
+`,
+			fn: `0000 OpMove 2 0
+0005 OpClosure 1 0 2 1
+0016 OpReturn 1
+`,
+		},
+		{
+			input: "let f = fn(n) { if (n == 0) { 0 } else { f(n - 1) } };",
+			main: `0000 OpClosure 0 3 1 0
+0011 OpSetGlobal 0 0
+`,
+			fn: `0000 OpLoadConstant 3 0
+0007 OpEqual 2 0 3
+0014 OpJumpNotTruthy 2 33
+0021 OpLoadConstant 1 1
+0028 OpJump 55
+0033 OpCurrentClosure 2
+0036 OpLoadConstant 4 2
+0043 OpSub 3 0 4
+0050 OpTailCall 2 1
+0055 OpReturn 1
+`,
+		},
+	}
+	for _, tt := range tests {
+		compiler := NewCompiler()
+		err := compiler.Compile(parse(tt.input))
+		if err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+		bytecode := compiler.Bytecode()
+		if bytecode.Instructions.String() != tt.main {
+			t.Errorf("%s: wrong main instructions.\nwant=\n%s\ngot=\n%s", tt.input, tt.main, bytecode.Instructions)
+		}
+		if tt.fn == "" {
+			continue
+		}
+		var fn *object.CompiledFunction
+		for _, constant := range bytecode.Constants {
+			if constant, ok := constant.(*object.CompiledFunction); ok {
+				fn = constant
+			}
+		}
+		if Instructions(fn.Instructions).String() != tt.fn {
+			t.Errorf("%s: wrong function instructions.\nwant=\n%s\ngot=\n%s", tt.input, tt.fn, Instructions(fn.Instructions))
+		}
+	}
+}
@@ -0,0 +1,12 @@
+package register
+
+import "jonathan/object"
+
+// Frame is a call of a function. Its registers are the slots of the stack from basePointer on,
+// the first of them hold the arguments.
+type Frame struct {
+	cl          *object.Closure
+	ip          int
+	basePointer int
+	result      int // the slot of the stack the caller expects the return value in
+}
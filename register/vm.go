@@ -0,0 +1,636 @@
+package register
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"jonathan/code"
+	"jonathan/compiler"
+	"jonathan/object"
+)
+
+// StackSize and MaxFrames are the default limits of the stack of registers and the frames,
+// Limits.MaxStackSize and Limits.MaxCallDepth replace them.
+const StackSize = 1 << 20
+const GlobalsSize = compiler.MaxGlobals
+const MaxFrames = 1 << 16
+
+const initialStackSize = 256
+const initialFrames = 64
+
+var True = object.TRUE
+var False = object.FALSE
+var Null = object.NULL
+
+// VM runs the instructions of the register compiler. The registers of all frames live on one stack,
+// a call places the frame of the callee right after the callee in the registers of the caller.
+type VM struct {
+	constants   []object.Object
+	stack       []object.Object
+	globals     []object.Object
+	frames      []Frame
+	framesIndex int // it points to the next frame of frames
+	result      object.Object
+	builtins    *object.BuiltinRegistry
+	callErr     error           // the error of a Call made by the running builtin
+	ctx         context.Context // cancels the current run
+	limits      object.Limits
+	executed    int64 // instructions executed in the current run
+	allocated   int64 // bytes accounted in the current run
+	random      object.RandomSource
+}
+
+func NewVm(bytecode *Bytecode) *VM {
+	mainFn := &object.CompiledFunction{Instructions: code.Instructions(bytecode.Instructions), NumLocals: bytecode.NumRegisters}
+	frames := make([]Frame, initialFrames)
+	frames[0] = Frame{cl: &object.Closure{Fn: mainFn}}
+	builtins := bytecode.Builtins
+	if builtins == nil {
+		builtins = object.NewBuiltinRegistry()
+	}
+	return &VM{
+		constants:   bytecode.Constants,
+		stack:       make([]object.Object, max(initialStackSize, bytecode.NumRegisters)),
+		globals:     make([]object.Object, GlobalsSize),
+		frames:      frames,
+		framesIndex: 1,
+		builtins:    builtins,
+		ctx:         context.Background(),
+	}
+}
+
+// SetLimits bounds the instructions, call depth, allocations and stack size of the following runs.
+func (vm *VM) SetLimits(limits object.Limits) {
+	vm.limits = limits
+	if limits.MaxStackSize > 0 && limits.MaxStackSize < len(vm.stack) && vm.frames[0].cl.Fn.NumLocals <= limits.MaxStackSize {
+		vm.stack = vm.stack[:limits.MaxStackSize]
+	}
+}
+
+// LastResult returns the value of the last expression statement of the main program.
+func (vm *VM) LastResult() object.Object {
+	return vm.result
+}
+
+func (vm *VM) Run() error {
+	return vm.RunWithContext(context.Background())
+}
+
+// RunWithContext runs the main program until it is done or ctx is done. Like the stack vm it returns
+// an error wrapping object.ErrTimeout when the deadline of ctx passes and object.ErrBudgetExceeded
+// when a limit is hit.
+func (vm *VM) RunWithContext(ctx context.Context) error {
+	outerCtx := vm.ctx
+	vm.ctx = ctx
+	vm.executed = 0
+	vm.allocated = 0
+	vm.random.Reset()
+	defer func() { vm.ctx = outerCtx }()
+	if vm.limits.MaxStackSize > 0 && vm.frames[0].cl.Fn.NumLocals > vm.limits.MaxStackSize {
+		return vm.stackOverflow(vm.limits.MaxStackSize)
+	}
+	return vm.run(0)
+}
+
+// run executes instructions until the frame at exitFrame returns or the main program is done.
+// The instructions, ip and registers of the running frame are kept in locals and only written back
+// to the frame around calls.
+func (vm *VM) run(exitFrame int) error {
+	frame := &vm.frames[vm.framesIndex-1]
+	ins := []byte(frame.cl.Fn.Instructions)
+	ip := frame.ip
+	bp := frame.basePointer
+	for ip < len(ins) {
+		err := vm.countInstruction()
+		if err != nil {
+			return err
+		}
+		switch Opcode(ins[ip]) {
+		case OpLoadConstant:
+			vm.stack[bp+readRegister(ins, ip+1)] = vm.constants[readUint32(ins, ip+3)]
+			ip += 7
+		case OpLoadTrue:
+			vm.stack[bp+readRegister(ins, ip+1)] = True
+			ip += 3
+		case OpLoadFalse:
+			vm.stack[bp+readRegister(ins, ip+1)] = False
+			ip += 3
+		case OpLoadNull:
+			vm.stack[bp+readRegister(ins, ip+1)] = Null
+			ip += 3
+		case OpMove:
+			vm.stack[bp+readRegister(ins, ip+1)] = vm.stack[bp+readRegister(ins, ip+3)]
+			ip += 5
+		case OpGetGlobal:
+			global := vm.globals[readRegister(ins, ip+3)]
+			if global == nil { // no let has set it yet
+				global = Null
+			}
+			vm.stack[bp+readRegister(ins, ip+1)] = global
+			ip += 5
+		case OpSetGlobal:
+			vm.globals[readRegister(ins, ip+1)] = vm.stack[bp+readRegister(ins, ip+3)]
+			ip += 5
+		case OpGetBuiltin:
+			index := readRegister(ins, ip+3)
+			builtin := vm.builtins.Get(index)
+			if builtin == nil {
+				return fmt.Errorf("builtin %d undefined", index)
+			}
+			vm.stack[bp+readRegister(ins, ip+1)] = builtin
+			ip += 5
+		case OpGetFree:
+			vm.stack[bp+readRegister(ins, ip+1)] = frame.cl.Free[readRegister(ins, ip+3)]
+			ip += 5
+		case OpCurrentClosure:
+			vm.stack[bp+readRegister(ins, ip+1)] = frame.cl
+			ip += 3
+		case OpAdd, OpSub, OpMul, OpDiv:
+			result, err := vm.executeBinaryOperation(Opcode(ins[ip]), vm.stack[bp+readRegister(ins, ip+3)], vm.stack[bp+readRegister(ins, ip+5)])
+			if err != nil {
+				return err
+			}
+			vm.stack[bp+readRegister(ins, ip+1)] = result
+			ip += 7
+		case OpEqual, OpNotEqual, OpGreaterThan:
+			result, err := executeComparison(Opcode(ins[ip]), vm.stack[bp+readRegister(ins, ip+3)], vm.stack[bp+readRegister(ins, ip+5)])
+			if err != nil {
+				return err
+			}
+			vm.stack[bp+readRegister(ins, ip+1)] = result
+			ip += 7
+		case OpMinus:
+			var result object.Object
+			switch operand := vm.stack[bp+readRegister(ins, ip+3)].(type) {
+			case *object.Integer:
+				result = object.NewInteger(-operand.Value)
+			case *object.Float:
+				result = &object.Float{Value: -operand.Value}
+			default:
+				return fmt.Errorf("unsupported type for negation: %s", operand.Type())
+			}
+			vm.stack[bp+readRegister(ins, ip+1)] = result
+			ip += 5
+		case OpBang:
+			vm.stack[bp+readRegister(ins, ip+1)] = nativeBoolToBooleanObject(!object.IsTruthy(vm.stack[bp+readRegister(ins, ip+3)]))
+			ip += 5
+		case OpJump:
+			ip = readUint32(ins, ip+1)
+		case OpJumpNotTruthy:
+			if object.IsTruthy(vm.stack[bp+readRegister(ins, ip+1)]) {
+				ip += 7
+			} else {
+				ip = readUint32(ins, ip+3)
+			}
+		case OpArray:
+			first := bp + readRegister(ins, ip+3)
+			array, err := vm.buildArray(first, first+readRegister(ins, ip+5))
+			if err != nil {
+				return err
+			}
+			vm.stack[bp+readRegister(ins, ip+1)] = array
+			ip += 7
+		case OpHash:
+			first := bp + readRegister(ins, ip+3)
+			hash, err := vm.buildHash(first, first+readRegister(ins, ip+5))
+			if err != nil {
+				return err
+			}
+			vm.stack[bp+readRegister(ins, ip+1)] = hash
+			ip += 7
+		case OpIndex:
+			result, err := executeIndexExpression(vm.stack[bp+readRegister(ins, ip+3)], vm.stack[bp+readRegister(ins, ip+5)])
+			if err != nil {
+				return err
+			}
+			vm.stack[bp+readRegister(ins, ip+1)] = result
+			ip += 7
+		case OpSlice:
+			result, err := vm.executeSliceExpression(vm.stack[bp+readRegister(ins, ip+3)], vm.stack[bp+readRegister(ins, ip+5)], vm.stack[bp+readRegister(ins, ip+7)])
+			if err != nil {
+				return err
+			}
+			vm.stack[bp+readRegister(ins, ip+1)] = result
+			ip += 9
+		case OpCall:
+			frame.ip = ip + 7
+			err := vm.call(bp+readRegister(ins, ip+1), bp+readRegister(ins, ip+3), readRegister(ins, ip+5))
+			if err != nil {
+				return err
+			}
+			frame = &vm.frames[vm.framesIndex-1]
+			ins, ip, bp = frame.cl.Fn.Instructions, frame.ip, frame.basePointer
+		case OpTailCall:
+			callee := bp + readRegister(ins, ip+1)
+			numArgs := readRegister(ins, ip+3)
+			cl, ok := vm.stack[callee].(*object.Closure)
+			if !ok { // builtins don't use a frame, their result is returned right away
+				err := vm.call(callee, callee, numArgs)
+				if err != nil {
+					return err
+				}
+				if vm.returnValue(vm.stack[callee], exitFrame) {
+					return nil
+				}
+				frame = &vm.frames[vm.framesIndex-1]
+				ins, ip, bp = frame.cl.Fn.Instructions, frame.ip, frame.basePointer
+				break
+			}
+			if numArgs != cl.Fn.NumParameters {
+				return fmt.Errorf("wrong number of arguments: want=%d, got=%d", cl.Fn.NumParameters, numArgs)
+			}
+			err := vm.ensureStack(bp + cl.Fn.NumLocals)
+			if err != nil {
+				return err
+			}
+			// the arguments become the parameters of the frame, the callee replaces the caller
+			copy(vm.stack[bp:], vm.stack[callee+1:callee+1+numArgs])
+			clearRegisters(vm.stack[bp+numArgs : bp+cl.Fn.NumLocals])
+			frame.cl = cl
+			ins, ip = cl.Fn.Instructions, 0
+		case OpReturn:
+			if vm.returnValue(vm.stack[bp+readRegister(ins, ip+1)], exitFrame) {
+				return nil
+			}
+			frame = &vm.frames[vm.framesIndex-1]
+			ins, ip, bp = frame.cl.Fn.Instructions, frame.ip, frame.basePointer
+		case OpClosure:
+			function, ok := vm.constants[readUint32(ins, ip+3)].(*object.CompiledFunction)
+			if !ok {
+				return fmt.Errorf("not a function: %+v", vm.constants[readUint32(ins, ip+3)])
+			}
+			first := bp + readRegister(ins, ip+7)
+			free := make([]object.Object, readRegister(ins, ip+9))
+			copy(free, vm.stack[first:])
+			vm.stack[bp+readRegister(ins, ip+1)] = &object.Closure{Fn: function, Free: free}
+			ip += 11
+		case OpSetResult:
+			vm.result = vm.stack[bp+readRegister(ins, ip+1)]
+			ip += 3
+		default:
+			return fmt.Errorf("opcode %d undefined", ins[ip])
+		}
+	}
+	frame.ip = ip
+	return nil
+}
+
+// returnValue hands value to the caller of the running frame and reports whether run is done
+func (vm *VM) returnValue(value object.Object, exitFrame int) bool {
+	frame := &vm.frames[vm.framesIndex-1]
+	if vm.framesIndex == 1 { // a return of the main program ends it
+		vm.result = value
+		frame.ip = len(frame.cl.Fn.Instructions)
+		return true
+	}
+	vm.stack[frame.result] = value
+	vm.framesIndex--
+	return vm.framesIndex <= exitFrame
+}
+
+// countInstruction enforces the instruction budget and checks the context every object.ContextCheckInterval instructions
+func (vm *VM) countInstruction() error {
+	vm.executed++
+	if vm.limits.MaxInstructions > 0 && vm.executed > vm.limits.MaxInstructions {
+		return object.InstructionBudgetError(vm.limits.MaxInstructions)
+	}
+	if vm.executed%object.ContextCheckInterval == 0 {
+		select {
+		case <-vm.ctx.Done():
+			return object.ContextError(vm.ctx)
+		default:
+		}
+	}
+	return nil
+}
+
+// clearRegisters sets registers to null, so a local a let hasn't set yet doesn't read what the stack held before
+func clearRegisters(registers []object.Object) {
+	for i := range registers {
+		registers[i] = Null
+	}
+}
+
+// call calls the function in the slot callee with the numArgs arguments after it. A builtin puts its
+// result into the slot result right away, a closure gets a frame the caller has to run.
+func (vm *VM) call(result, callee, numArgs int) error {
+	switch fn := vm.stack[callee].(type) {
+	case *object.Closure:
+		if numArgs != fn.Fn.NumParameters {
+			return fmt.Errorf("wrong number of arguments: want=%d, got=%d", fn.Fn.NumParameters, numArgs)
+		}
+		if vm.limits.MaxCallDepth > 0 && vm.framesIndex > vm.limits.MaxCallDepth { // the main frame isn't a call
+			return object.CallDepthError(vm.limits.MaxCallDepth)
+		}
+		err := vm.ensureStack(callee + 1 + fn.Fn.NumLocals)
+		if err != nil {
+			return err
+		}
+		clearRegisters(vm.stack[callee+1+numArgs : callee+1+fn.Fn.NumLocals])
+		return vm.pushFrame(Frame{cl: fn, basePointer: callee + 1, result: result})
+	case *object.Builtin:
+		args := vm.stack[callee+1 : callee+1+numArgs]
+		outerErr := vm.callErr
+		vm.callErr = nil
+		value := fn.Fn(vm, args...)
+		callErr := vm.callErr
+		vm.callErr = outerErr
+		if callErr != nil { // a function the builtin called back failed, that stops the vm
+			return callErr
+		}
+		if value == nil {
+			value = Null
+		}
+		vm.stack[result] = value
+		return nil
+	default:
+		return fmt.Errorf("calling non-function and non-built-in")
+	}
+}
+
+// Call applies a closure or builtin to args and returns the result. It uses the registers after
+// those of the running frame, so it can be used by builtins while the vm is running as well as by
+// the host after Run returned.
+func (vm *VM) Call(fn object.Object, args ...object.Object) (object.Object, error) {
+	framesIndex := vm.framesIndex
+	result, err := vm.callFromHost(fn, args)
+	if err != nil {
+		vm.framesIndex = framesIndex
+		vm.callErr = err
+		return nil, err
+	}
+	return result, nil
+}
+
+// TryCall is Call for the try builtin. If the call exceeds the memory limit, the vm drops what the call
+// allocated from its account and goes on, the error is only returned.
+func (vm *VM) TryCall(fn object.Object, args ...object.Object) (object.Object, error) {
+	allocated := vm.allocated
+	result, err := vm.Call(fn, args...)
+	if errors.Is(err, object.ErrMemoryLimitExceeded) {
+		vm.allocated = allocated
+		vm.callErr = nil
+	}
+	return result, err
+}
+
+func (vm *VM) callFromHost(fn object.Object, args []object.Object) (object.Object, error) {
+	frame := &vm.frames[vm.framesIndex-1]
+	callee := frame.basePointer + frame.cl.Fn.NumLocals
+	err := vm.ensureStack(callee + 1 + len(args))
+	if err != nil {
+		return nil, err
+	}
+	vm.stack[callee] = fn
+	copy(vm.stack[callee+1:], args)
+	exitFrame := vm.framesIndex
+	err = vm.call(callee, callee, len(args))
+	if err != nil {
+		return nil, err
+	}
+	if vm.framesIndex > exitFrame {
+		err = vm.run(exitFrame)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return vm.stack[callee], nil
+}
+
+// Allocate accounts size bytes against Limits.MaxAllocBytes. Exceeding the limit stops the vm,
+// even if the builtin that allocates ignores the error.
+func (vm *VM) Allocate(size int64) error {
+	err := vm.allocate(size)
+	if err != nil {
+		vm.callErr = err
+	}
+	return err
+}
+
+// Random returns the random numbers of the current run.
+func (vm *VM) Random() *object.RandomSource {
+	return &vm.random
+}
+
+func (vm *VM) allocate(size int64) error {
+	vm.allocated += size
+	if vm.limits.MaxAllocBytes > 0 && vm.allocated > vm.limits.MaxAllocBytes {
+		return object.AllocationError(vm.limits.MaxAllocBytes)
+	}
+	return nil
+}
+
+func (vm *VM) buildArray(startIndex, endIndex int) (object.Object, error) {
+	err := vm.allocate(object.ArraySize(endIndex - startIndex))
+	if err != nil {
+		return nil, err
+	}
+	elements := make([]object.Object, endIndex-startIndex)
+	copy(elements, vm.stack[startIndex:endIndex])
+	return &object.Array{Elements: elements}, nil
+}
+
+func (vm *VM) buildHash(startIndex, endIndex int) (object.Object, error) {
+	err := vm.allocate(object.HashSize((endIndex - startIndex) / 2))
+	if err != nil {
+		return nil, err
+	}
+	hash := object.NewHash((endIndex - startIndex) / 2)
+	for i := startIndex; i < endIndex; i += 2 {
+		key := vm.stack[i]
+		value := vm.stack[i+1]
+		hashKey, ok := object.AsHashable(key)
+		if !ok {
+			return nil, fmt.Errorf("unusable as hash key: %s", key.Type())
+		}
+		hash.Set(hashKey, value)
+	}
+	return hash, nil
+}
+
+func (vm *VM) executeSliceExpression(left, start, end object.Object) (object.Object, error) {
+	result, err := object.Slice(left, start, end)
+	if err != nil {
+		return nil, err
+	}
+	switch result := result.(type) {
+	case *object.Array:
+		err = vm.allocate(object.ArraySize(len(result.Elements)))
+	case *object.String:
+		err = vm.allocate(object.StringSize(len(result.Value)))
+	}
+	return result, err
+}
+
+func executeIndexExpression(left, index object.Object) (object.Object, error) {
+	switch {
+	case left.Type() == object.ArrayObj && index.Type() == object.IntegerObj:
+		elements := left.(*object.Array).Elements
+		i := index.(*object.Integer).Value
+		if i < 0 || i >= int64(len(elements)) {
+			return Null, nil
+		}
+		return elements[i], nil
+	case left.Type() == object.HashObj:
+		key, ok := object.AsHashable(index)
+		if !ok {
+			return nil, fmt.Errorf("unusable as hash key: %s", index.Type())
+		}
+		value, ok := left.(*object.Hash).Get(key)
+		if !ok {
+			return Null, nil
+		}
+		return value, nil
+	case left.Type() == object.StringObj && index.Type() == object.IntegerObj:
+		char, ok := object.CharAt(left.(*object.String).Value, index.(*object.Integer).Value)
+		if !ok {
+			return Null, nil
+		}
+		return char, nil
+	default:
+		return nil, fmt.Errorf("index operator not supported: %s", left.Type())
+	}
+}
+
+func (vm *VM) executeBinaryOperation(op Opcode, left, right object.Object) (object.Object, error) {
+	switch left := left.(type) {
+	case *object.Integer:
+		if right, ok := right.(*object.Integer); ok {
+			return executeBinaryIntegerOperation(op, left.Value, right.Value)
+		}
+	case *object.String:
+		if right, ok := right.(*object.String); ok {
+			if op != OpAdd {
+				return nil, fmt.Errorf("unknown string operator: %d", op)
+			}
+			err := vm.allocate(object.StringSize(len(left.Value) + len(right.Value)))
+			if err != nil {
+				return nil, err
+			}
+			return &object.String{Value: left.Value + right.Value}, nil
+		}
+	}
+	if leftValue, rightValue, ok := object.FloatOperands(left, right); ok {
+		return executeBinaryFloatOperation(op, leftValue, rightValue)
+	}
+	return nil, fmt.Errorf("unsupported types for binary operation: %s %s", left.Type(), right.Type())
+}
+
+func executeBinaryIntegerOperation(op Opcode, left, right int64) (object.Object, error) {
+	var result int64
+	switch op {
+	case OpAdd:
+		result = left + right
+	case OpSub:
+		result = left - right
+	case OpMul:
+		result = left * right
+	case OpDiv:
+		if right == 0 {
+			return nil, fmt.Errorf("division by zero")
+		}
+		result = left / right
+	default:
+		return nil, fmt.Errorf("unknown integer operator: %d", op)
+	}
+	return object.NewInteger(result), nil
+}
+
+// executeBinaryFloatOperation computes an operation on two numbers of which one at least is a float
+func executeBinaryFloatOperation(op Opcode, left, right float64) (object.Object, error) {
+	var result float64
+	switch op {
+	case OpAdd:
+		result = left + right
+	case OpSub:
+		result = left - right
+	case OpMul:
+		result = left * right
+	case OpDiv:
+		if right == 0 {
+			return nil, fmt.Errorf("division by zero")
+		}
+		result = left / right
+	default:
+		return nil, fmt.Errorf("unknown float operator: %d", op)
+	}
+	return &object.Float{Value: result}, nil
+}
+
+func executeComparison(op Opcode, left, right object.Object) (object.Object, error) {
+	if left, ok := left.(*object.Integer); ok {
+		if right, ok := right.(*object.Integer); ok {
+			switch op {
+			case OpEqual:
+				return nativeBoolToBooleanObject(left.Value == right.Value), nil
+			case OpNotEqual:
+				return nativeBoolToBooleanObject(left.Value != right.Value), nil
+			case OpGreaterThan:
+				return nativeBoolToBooleanObject(left.Value > right.Value), nil
+			}
+		}
+	}
+	if leftValue, rightValue, ok := object.FloatOperands(left, right); ok && op == OpGreaterThan {
+		return nativeBoolToBooleanObject(leftValue > rightValue), nil
+	}
+	if left, ok := left.(*object.String); ok && op == OpGreaterThan {
+		if right, ok := right.(*object.String); ok {
+			return nativeBoolToBooleanObject(left.Value > right.Value), nil
+		}
+	}
+	switch op {
+	case OpEqual:
+		return nativeBoolToBooleanObject(object.Equal(left, right)), nil
+	case OpNotEqual:
+		return nativeBoolToBooleanObject(!object.Equal(left, right)), nil
+	default:
+		return nil, fmt.Errorf("unknown operator: %d (%s %s)", op, left.Type(), right.Type())
+	}
+}
+
+func nativeBoolToBooleanObject(input bool) *object.Boolean {
+	if input {
+		return True
+	}
+	return False
+}
+
+// ensureStack grows the stack to hold size values
+func (vm *VM) ensureStack(size int) error {
+	if size <= len(vm.stack) {
+		return nil
+	}
+	maxSize := StackSize
+	if vm.limits.MaxStackSize > 0 {
+		maxSize = vm.limits.MaxStackSize
+	}
+	if size > maxSize {
+		return vm.stackOverflow(maxSize)
+	}
+	stack := make([]object.Object, min(max(2*len(vm.stack), size), maxSize))
+	copy(stack, vm.stack)
+	vm.stack = stack
+	return nil
+}
+
+func (vm *VM) stackOverflow(maxSize int) error {
+	return fmt.Errorf("%w: the stack exceeds %d values", object.ErrStackOverflow, maxSize)
+}
+
+func (vm *VM) pushFrame(f Frame) error {
+	if vm.framesIndex >= len(vm.frames) {
+		maxFrames := MaxFrames
+		if vm.limits.MaxCallDepth > 0 {
+			maxFrames = vm.limits.MaxCallDepth + 1 // the main frame isn't a call
+		}
+		if vm.framesIndex >= maxFrames {
+			return fmt.Errorf("%w: more than %d frames", object.ErrStackOverflow, maxFrames)
+		}
+		frames := make([]Frame, min(2*len(vm.frames), maxFrames))
+		copy(frames, vm.frames[:vm.framesIndex])
+		vm.frames = frames
+	}
+	vm.frames[vm.framesIndex] = f
+	vm.framesIndex++
+	return nil
+}
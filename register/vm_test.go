@@ -0,0 +1,111 @@
+package register
+
+import (
+	"errors"
+	"jonathan/object"
+	"testing"
+)
+
+// The language itself is tested by the suites of the stack vm, they run every case on this vm as well.
+
+func compile(t *testing.T, input string, builtins *object.BuiltinRegistry) *VM {
+	t.Helper()
+	compiler := NewCompilerWithBuiltins(builtins)
+	err := compiler.Compile(parse(input))
+	if err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	return NewVm(compiler.Bytecode())
+}
+
+func TestCallingFunctionsWithWrongArguments(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"fn(){ 1; }(1);", "wrong number of arguments: want=0, got=1"},
+		{"fn(a){ a; }();", "wrong number of arguments: want=1, got=0"},
+		{"let f = fn(a, b) { a + b }; let g = fn(a) { f(a) }; g(1)", "wrong number of arguments: want=2, got=1"},
+	}
+	for _, tt := range tests {
+		err := compile(t, tt.input, object.NewBuiltinRegistry()).Run()
+		if err == nil || err.Error() != tt.expected {
+			t.Errorf("wrong vm error: want=%q, got=%v", tt.expected, err)
+		}
+	}
+}
+
+func TestDivisionByZero(t *testing.T) {
+	for _, input := range []string{"1 / 0", "let zero = fn() { 0 }; 1 / zero()", "let f = fn(a, b) { a / b }; f(1, 0)", "PI / 0", "1 / (sqrt(4) - 2)", "1.5 / 0"} {
+		err := compile(t, input, object.NewBuiltinRegistry()).Run()
+		if err == nil || err.Error() != "division by zero" {
+			t.Errorf("wrong vm error for %s: want=%q, got=%v", input, "division by zero", err)
+		}
+	}
+}
+
+func TestCallbacks(t *testing.T) {
+	builtins := object.NewBuiltinRegistry()
+	_, err := builtins.Register("twice", 2, func(ctx object.CallContext, args ...object.Object) object.Object {
+		once, err := ctx.Call(args[0], args[1])
+		if err != nil {
+			return &object.Error{Message: err.Error()}
+		}
+		twice, err := ctx.Call(args[0], once)
+		if err != nil {
+			return &object.Error{Message: err.Error()}
+		}
+		return twice
+	})
+	if err != nil {
+		t.Fatalf("register failed: %s", err)
+	}
+	vm := compile(t, "let add = fn(n) { let one = 1; n + one }; let f = fn(x) { twice(add, x) + x }; f(10)", builtins)
+	err = vm.Run()
+	if err != nil {
+		t.Fatalf("vm error: %s", err)
+	}
+	if result, ok := vm.LastResult().(*object.Integer); !ok || result.Value != 22 {
+		t.Errorf("wrong result. got=%s", vm.LastResult().Inspect())
+	}
+
+	// the functions of the script can be called by the host after the run
+	vm = compile(t, "let double = fn(x) { [x, x * 2][1] }; double", builtins)
+	err = vm.Run()
+	if err != nil {
+		t.Fatalf("vm error: %s", err)
+	}
+	result, err := vm.Call(vm.LastResult(), &object.Integer{Value: 21})
+	if err != nil {
+		t.Fatalf("call error: %s", err)
+	}
+	if result, ok := result.(*object.Integer); !ok || result.Value != 42 {
+		t.Errorf("wrong result of Call. got=%s", result.Inspect())
+	}
+	_, err = vm.Call(vm.LastResult())
+	if err == nil || err.Error() != "wrong number of arguments: want=1, got=0" {
+		t.Errorf("wrong error of Call. got=%v", err)
+	}
+}
+
+func TestExecutionLimits(t *testing.T) {
+	tests := []struct {
+		input    string
+		limits   object.Limits
+		expected error
+	}{
+		{"let f = fn(x) { f(x + 1) }; f(0)", object.Limits{MaxInstructions: 10000}, object.ErrBudgetExceeded},
+		{"let f = fn(x) { 1 + f(x + 1) }; f(0)", object.Limits{MaxCallDepth: 100}, object.ErrBudgetExceeded},
+		{"let f = fn(x) { 1 + f(x + 1) }; f(0)", object.Limits{MaxStackSize: 1000}, object.ErrStackOverflow},
+		{"let f = fn(x) { 1 + f(x + 1) }; f(0)", object.Limits{}, object.ErrStackOverflow},
+		{"let f = fn(s) { f(s + s) }; f(\"ab\")", object.Limits{MaxAllocBytes: 1 << 20}, object.ErrMemoryLimitExceeded},
+	}
+	for _, tt := range tests {
+		vm := compile(t, tt.input, object.NewBuiltinRegistry())
+		vm.SetLimits(tt.limits)
+		err := vm.Run()
+		if !errors.Is(err, tt.expected) {
+			t.Errorf("%s: wrong error. want=%s, got=%v", tt.input, tt.expected, err)
+		}
+	}
+}
@@ -17,8 +17,9 @@ func Start(in io.Reader, out io.Writer) {
 	scanner := bufio.NewScanner(in)
 	var constants []object.Object
 	globals := make([]object.Object, vm.GlobalsSize)
+	builtins := object.NewBuiltinRegistry()
 	symbolTable := compiler.NewSymbolTable()
-	for i, v := range object.Builtins {
+	for i, v := range builtins.Definitions() {
 		symbolTable.DefineBuiltin(i, v.Name)
 	}
 	for {
@@ -38,7 +39,7 @@ func Start(in io.Reader, out io.Writer) {
 			continue
 		}
 
-		comp := compiler.NewCompilerWithState(symbolTable, constants)
+		comp := compiler.NewCompilerWithState(symbolTable, constants, builtins)
 		err := comp.Compile(program)
 		if err != nil {
 			_, err := fmt.Fprintf(out, "Woops! Compilation failed:\n %s\n", err)
@@ -47,6 +48,12 @@ func Start(in io.Reader, out io.Writer) {
 			}
 			continue
 		}
+		for _, warning := range comp.Warnings() {
+			_, err := fmt.Fprintf(out, "warning: %s\n", warning)
+			if err != nil {
+				return
+			}
+		}
 		code := comp.Bytecode()
 		constants = code.Constants // update the constants
 
@@ -13,6 +13,7 @@ const (
 	// IDENT Identifiers + literals
 	IDENT  = "IDENT" // add, foobar, x, y, ...
 	INT    = "INT"
+	FLOAT  = "FLOAT" // 1.5, 0.25, ...
 	STRING = "STRING"
 
 	// ASSIGN Operators
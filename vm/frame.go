@@ -7,12 +7,12 @@ import (
 
 type Frame struct {
 	cl          *object.Closure
-	ip          int
+	ip          int // the position of the next instruction
 	basePointer int
 }
 
 func NewFrame(cl *object.Closure, basePointer int) *Frame {
-	return &Frame{cl: cl, ip: -1, basePointer: basePointer}
+	return &Frame{cl: cl, ip: 0, basePointer: basePointer}
 }
 
 func (f *Frame) Instructions() code.Instructions {
@@ -0,0 +1,57 @@
+package vm
+
+import (
+	"fmt"
+	"jonathan/object"
+	"strings"
+)
+
+// maxTraceFrames is the number of innermost frames a StackOverflowError keeps
+const maxTraceFrames = 16
+
+// StackOverflowError is returned when the value stack or the frames reach their limit.
+// It wraps object.ErrStackOverflow.
+type StackOverflowError struct {
+	Reason  string
+	Trace   []string // the functions of the innermost frames, innermost first
+	Omitted int      // frames left out of Trace
+}
+
+func (e *StackOverflowError) Error() string {
+	var out strings.Builder
+	fmt.Fprintf(&out, "%s: %s", object.ErrStackOverflow, e.Reason)
+	for _, name := range e.Trace {
+		fmt.Fprintf(&out, "\n\tat %s", name)
+	}
+	if e.Omitted > 0 {
+		fmt.Fprintf(&out, "\n\t... %d more frames", e.Omitted)
+	}
+	return out.String()
+}
+
+func (e *StackOverflowError) Unwrap() error {
+	return object.ErrStackOverflow
+}
+
+func (vm *VM) stackOverflow(reason string) error {
+	err := &StackOverflowError{Reason: reason}
+	for i := vm.framesIndex - 1; i >= 0; i-- {
+		if len(err.Trace) == maxTraceFrames {
+			err.Omitted = i + 1
+			break
+		}
+		err.Trace = append(err.Trace, frameName(vm.frames[i], i))
+	}
+	return err
+}
+
+func frameName(frame *Frame, index int) string {
+	switch {
+	case index == 0:
+		return "<main>"
+	case frame.cl.Fn.Name == "":
+		return "<anonymous>"
+	default:
+		return frame.cl.Fn.Name
+	}
+}
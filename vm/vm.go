@@ -1,19 +1,26 @@
 package vm
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"jonathan/code"
 	"jonathan/compiler"
 	"jonathan/object"
 )
 
-const StackSize = 2048
-const GlobalsSize = 65536
-const MaxFrames = 1024
+// StackSize and MaxFrames are the default limits of the value stack and the frames.
+// Both start small and grow on demand, Limits.MaxStackSize and Limits.MaxCallDepth replace the defaults.
+const StackSize = 1 << 20
+const GlobalsSize = compiler.MaxGlobals
+const MaxFrames = 1 << 16
 
-var True = &object.Boolean{Value: true}
-var False = &object.Boolean{Value: false}
-var Null = &object.Null{}
+const initialStackSize = 256
+const initialFrames = 64
+
+var True = object.TRUE
+var False = object.FALSE
+var Null = object.NULL
 
 type VM struct {
 	constants []object.Object //The value of number \ string or the function instruction
@@ -22,23 +29,46 @@ type VM struct {
 	sp          int // Always points to the next value. Top of stack is stack[sp-1]
 	globals     []object.Object
 	frames      []*Frame
-	framesIndex int // it points to the next frame of frames
+	framesIndex int                     // it points to the next frame of frames
+	builtins    *object.BuiltinRegistry // resolves the operand of OpGetBuiltin
+	callErr     error                   // the error of a Call made by the running builtin
+	ctx         context.Context         // cancels the current run
+	limits      object.Limits
+	executed    int64 // instructions executed in the current run
+	nextCheck   int64 // the count of executed instructions at which the limits are checked next
+	allocated   int64 // bytes accounted in the current run
+	random      object.RandomSource
 }
 
 func NewVm(bytecode *compiler.Bytecode) *VM {
 	mainFn := &object.CompiledFunction{Instructions: bytecode.Instructions}
 	mainClosure := &object.Closure{Fn: mainFn}
 	mainFrame := NewFrame(mainClosure, 0)
-	frames := make([]*Frame, MaxFrames)
+	frames := make([]*Frame, initialFrames)
 	frames[0] = mainFrame
+	builtins := bytecode.Builtins
+	if builtins == nil {
+		builtins = object.NewBuiltinRegistry()
+	}
 	return &VM{
 		//instructions: bytecode.Instructions,
 		constants:   bytecode.Constants,
-		stack:       make([]object.Object, StackSize),
+		stack:       make([]object.Object, initialStackSize),
 		sp:          0,
 		globals:     make([]object.Object, GlobalsSize),
 		frames:      frames,
 		framesIndex: 1,
+		builtins:    builtins,
+		ctx:         context.Background(),
+	}
+}
+
+// SetLimits bounds the instructions, call depth, allocations and stack size of the following runs.
+func (vm *VM) SetLimits(limits object.Limits) {
+	vm.limits = limits
+	vm.scheduleCheck()
+	if limits.MaxStackSize > 0 && limits.MaxStackSize < len(vm.stack) && vm.sp <= limits.MaxStackSize {
+		vm.stack = vm.stack[:limits.MaxStackSize]
 	}
 }
 
@@ -56,179 +86,184 @@ func (vm *VM) StackTop() object.Object {
 }
 
 func (vm *VM) Run() error {
-	var ip int
-	var ins code.Instructions
-	var op code.Opcode
-	for vm.currentFrame().ip < len(vm.currentFrame().Instructions())-1 {
-		vm.currentFrame().ip++
-		ip = vm.currentFrame().ip
-		ins = vm.currentFrame().Instructions()
-		op = code.Opcode(ins[ip])
-		switch op {
-		case code.OpConstant:
-			constIndex := code.ReadUint16(ins[ip+1:])
-			vm.currentFrame().ip += 2
-			err := vm.push(vm.constants[constIndex])
-			if err != nil {
-				return err
-			}
-		case code.OpArray:
-			numElements := int(code.ReadUint16(ins[ip+1:]))
-			vm.currentFrame().ip += 2
-			array := vm.buildArray(vm.sp-numElements, vm.sp)
-			vm.sp = vm.sp - numElements
-			err := vm.push(array)
-			if err != nil {
-				return err
-			}
-		case code.OpHash:
-			numElements := int(code.ReadUint16(ins[ip+1:]))
-			vm.currentFrame().ip += 2
-			hash, err := vm.buildHash(vm.sp-numElements, vm.sp)
-			if err != nil {
-				return err
-			}
-			vm.sp = vm.sp - numElements
-			err = vm.push(hash)
-			if err != nil {
-				return err
-			}
-		case code.OpIndex:
-			index := vm.pop()
-			left := vm.pop()
-			err := vm.executeIndexExpression(left, index)
-			if err != nil {
-				return err
-			}
-		case code.OpSetGlobal:
-			globalIndex := code.ReadUint16(ins[ip+1:])
-			vm.currentFrame().ip += 2
-			vm.globals[globalIndex] = vm.pop()
-		case code.OpGetGlobal:
-			globalIndex := code.ReadUint16(ins[ip+1:])
-			vm.currentFrame().ip += 2
-			err := vm.push(vm.globals[globalIndex])
-			if err != nil {
-				return err
-			}
-		case code.OpGetBuiltin:
-			buildinIndex := code.ReadUint8(ins[ip+1:])
-			vm.currentFrame().ip += 1
-			definition := object.Builtins[buildinIndex]
-			err := vm.push(definition.Builtin)
-			if err != nil {
-				return err
-			}
-		case code.OpGetFree:
-			freeIndex := code.ReadUint8(ins[ip+1:])
-			vm.currentFrame().ip += 1
-			currentClosure := vm.currentFrame().cl
-			err := vm.push(currentClosure.Free[freeIndex])
+	return vm.RunWithContext(context.Background())
+}
+
+// RunWithContext runs the bytecode until it is done or ctx is done. It returns an error wrapping
+// object.ErrTimeout when the deadline of ctx passes and object.ErrBudgetExceeded when a limit is hit.
+func (vm *VM) RunWithContext(ctx context.Context) error {
+	outerCtx := vm.ctx
+	vm.ctx = ctx
+	vm.executed = 0
+	vm.allocated = 0
+	vm.random.Reset()
+	vm.scheduleCheck()
+	defer func() { vm.ctx = outerCtx }()
+	return vm.run(0)
+}
+
+// run executes instructions until the frame at exitFrame returns or the main function is done.
+// The running frame, its instructions, ip and base pointer are kept in locals. They are written back
+// to the frame only before instructions that push, pop or reuse frames and read again after them.
+// The count of executed instructions is kept in a local as well, it is written back before the limits
+// are checked and before calls, which may run script functions of their own, and read again after them.
+// The switch over the opcodes is dense, so the Go compiler turns it into a jump table.
+func (vm *VM) run(exitFrame int) error {
+	frame := vm.currentFrame()
+	ins := frame.Instructions()
+	ip := frame.ip // the position of the next instruction
+	bp := frame.basePointer
+	executed, nextCheck := vm.executed, vm.nextCheck
+	for ip < len(ins) {
+		var err error
+		executed++
+		if executed >= nextCheck {
+			vm.executed = executed
+			err = vm.checkLimits()
 			if err != nil {
 				return err
 			}
+			nextCheck = vm.nextCheck
+		}
+		op := code.Opcode(ins[ip])
+		switch op {
+		case code.OpConstant:
+			err = vm.push(vm.constants[code.ReadUint16(ins[ip+1:])])
+			ip += 3
 		case code.OpAdd, code.OpSub, code.OpMul, code.OpDiv:
-			err := vm.executeBinaryOperation(op)
-			if err != nil {
-				return err
-			}
-		case code.OpEqual, code.OpNotEqual, code.OpGreaterThan:
-			err := vm.executeComparison(op)
-			if err != nil {
-				return err
-			}
+			err = vm.executeBinaryOperation(op)
+			ip++
+		case code.OpPop:
+			vm.sp--
+			ip++
 		case code.OpTrue:
-			err := vm.push(True)
-			if err != nil {
-				return err
-			}
+			err = vm.push(True)
+			ip++
 		case code.OpFalse:
-			err := vm.push(False)
-			if err != nil {
-				return err
-			}
+			err = vm.push(False)
+			ip++
+		case code.OpEqual, code.OpNotEqual, code.OpGreaterThan:
+			err = vm.executeComparison(op)
+			ip++
+		case code.OpMinus:
+			err = vm.executeMinusOperator()
+			ip++
+		case code.OpBang:
+			err = vm.executeBangOperator()
+			ip++
 		case code.OpJumpNotTruthy:
-			pos := int(code.ReadUint16(ins[ip+1:]))
-			vm.currentFrame().ip += 2
-			condition := vm.pop()
-			if !isTruthy(condition) {
-				vm.currentFrame().ip = pos - 1
+			if object.IsTruthy(vm.pop()) {
+				ip += 3
+			} else {
+				ip = int(code.ReadUint16(ins[ip+1:]))
 			}
 		case code.OpJump:
-			pos := int(code.ReadUint16(ins[ip+1:]))
-			vm.currentFrame().ip = pos - 1 // change the next instructions
-		case code.OpCall:
-			numArgs := code.ReadUint8(ins[ip+1:])
-			vm.currentFrame().ip += 1
-			err := vm.executeCall(int(numArgs))
-			if err != nil {
-				return err
+			ip = int(code.ReadUint16(ins[ip+1:]))
+		case code.OpNull:
+			err = vm.push(Null)
+			ip++
+		case code.OpGetGlobal:
+			err = vm.push(vm.global(int(code.ReadUint16(ins[ip+1:]))))
+			ip += 3
+		case code.OpSetGlobal:
+			vm.globals[code.ReadUint16(ins[ip+1:])] = vm.pop()
+			ip += 3
+		case code.OpArray:
+			err = vm.executeArray(int(code.ReadUint16(ins[ip+1:])))
+			ip += 3
+		case code.OpHash:
+			err = vm.executeHash(int(code.ReadUint16(ins[ip+1:])))
+			ip += 3
+		case code.OpIndex:
+			index := vm.pop()
+			left := vm.pop()
+			err = vm.executeIndexExpression(left, index)
+			ip++
+		case code.OpSlice:
+			end := vm.pop()
+			start := vm.pop()
+			left := vm.pop()
+			err = vm.executeSliceExpression(left, start, end)
+			ip++
+		case code.OpCall, code.OpTailCall:
+			frame.ip = ip + 2
+			vm.executed = executed
+			if op == code.OpCall {
+				err = vm.executeCall(int(ins[ip+1]))
+			} else {
+				err = vm.executeTailCall(int(ins[ip+1]))
 			}
-		case code.OpClosure:
-			constIndex := code.ReadUint16(ins[ip+1:])
-			numFree := code.ReadUint8(ins[ip+3:])
-			vm.currentFrame().ip += 3
-			err := vm.pushClosure(int(constIndex), int(numFree))
-			if err != nil {
-				return err
+			executed, nextCheck = vm.executed, vm.nextCheck
+			frame = vm.currentFrame()
+			ins, ip, bp = frame.Instructions(), frame.ip, frame.basePointer
+		case code.OpReturnValue, code.OpReturn:
+			var returnValue object.Object = Null
+			if op == code.OpReturnValue {
+				returnValue = vm.pop()
 			}
-		case code.OpCurrentClosure:
-			currentClosure := vm.currentFrame().cl
-			err := vm.push(currentClosure)
-			if err != nil {
+			vm.popFrame()
+			vm.sp = bp - 1 // drop the arguments and the callee
+			err = vm.push(returnValue)
+			if vm.framesIndex <= exitFrame {
+				vm.executed = executed
 				return err
 			}
-		case code.OpSetLocal:
-			localIndex := code.ReadUint8(ins[ip+1:]) //local index is the offset relative to the basepointer
-			vm.currentFrame().ip += 1
-			frame := vm.currentFrame()
-			vm.stack[frame.basePointer+int(localIndex)] = vm.pop()
+			frame = vm.currentFrame()
+			ins, ip, bp = frame.Instructions(), frame.ip, frame.basePointer
 		case code.OpGetLocal:
-			localIndex := code.ReadUint8(ins[ip+1:]) //local index is the offset relative to the basepointer
-			vm.currentFrame().ip += 1
-			frame := vm.currentFrame()
-			err := vm.push(vm.stack[frame.basePointer+int(localIndex)])
-			if err != nil {
-				return err
-			}
-		case code.OpReturnValue:
-			returnValue := vm.pop()
-			frame := vm.popFrame()
-			vm.sp = frame.basePointer - 1 // Get the sp status in the function frame
-			//vm.pop()  //we set the sp in the last operation,so don`t need pop any more
-			err := vm.push(returnValue)
-			if err != nil {
-				return err
-			}
-		case code.OpReturn:
-			frame := vm.popFrame()
-			vm.sp = frame.basePointer - 1
-			//vm.pop()//we set the sp in the last operation ,so don`t need pop any more
-			err := vm.push(Null)
-			if err != nil {
-				return err
-			}
-		case code.OpBang:
-			err := vm.executeBangOperator()
-			if err != nil {
-				return err
-			}
-		case code.OpMinus:
-			err := vm.executeMinusOperator()
-			if err != nil {
-				return err
-			}
-		case code.OpNull:
-			err := vm.push(Null)
-			if err != nil {
-				return err
+			err = vm.push(vm.stack[bp+int(ins[ip+1])]) //local index is the offset relative to the basepointer
+			ip += 2
+		case code.OpSetLocal:
+			vm.stack[bp+int(ins[ip+1])] = vm.pop()
+			ip += 2
+		case code.OpGetBuiltin:
+			err = vm.pushBuiltin(int(ins[ip+1]))
+			ip += 2
+		case code.OpClosure:
+			err = vm.pushClosure(int(code.ReadUint16(ins[ip+1:])), int(ins[ip+3]))
+			ip += 4
+		case code.OpGetFree:
+			err = vm.push(frame.cl.Free[ins[ip+1]])
+			ip += 2
+		case code.OpCurrentClosure:
+			err = vm.push(frame.cl)
+			ip++
+		case code.OpDup:
+			err = vm.push(vm.stack[vm.sp-1])
+			ip++
+		case code.OpWide:
+			frame.ip = ip
+			vm.executed = executed
+			err = vm.executeWide(ins, ip)
+			executed, nextCheck = vm.executed, vm.nextCheck
+			frame = vm.currentFrame()
+			ins, ip, bp = frame.Instructions(), frame.ip, frame.basePointer
+		case code.OpGetLocal0, code.OpGetLocal1, code.OpGetLocal2, code.OpGetLocal3:
+			err = vm.push(vm.stack[bp+int(op-code.OpGetLocal0)])
+			ip++
+		case code.OpAddConst, code.OpSubConst:
+			err = vm.executeConstOperation(op, vm.constants[code.ReadUint16(ins[ip+1:])])
+			ip += 3
+		case code.OpJumpIfNotGreater, code.OpJumpIfNotEqual:
+			var holds bool
+			holds, err = vm.executeComparisonJump(op)
+			if holds {
+				ip += 3
+			} else {
+				ip = int(code.ReadUint16(ins[ip+1:]))
 			}
-		case code.OpPop:
-			vm.pop()
 		default:
+			vm.executed = executed
 			return nil
 		}
+		if err != nil {
+			frame.ip = ip
+			vm.executed = executed
+			return err
+		}
 	}
+	frame.ip = ip
+	vm.executed = executed
 	return nil
 }
 
@@ -244,49 +279,290 @@ func (vm *VM) executeCall(numArgs int) error {
 	}
 }
 
+// executeWide executes the instruction after an OpWide prefix at ip, its operands are twice as wide
+func (vm *VM) executeWide(ins code.Instructions, ip int) error {
+	op := code.Opcode(ins[ip+1])
+	def, err := code.Lookup(byte(op))
+	if err != nil {
+		return err
+	}
+	operands, read := code.ReadOperands(code.Widen(def), ins[ip+2:])
+	vm.currentFrame().ip += 2 + read
+	switch op {
+	case code.OpConstant:
+		return vm.push(vm.constants[operands[0]])
+	case code.OpArray:
+		return vm.executeArray(operands[0])
+	case code.OpHash:
+		return vm.executeHash(operands[0])
+	case code.OpSetGlobal:
+		vm.globals[operands[0]] = vm.pop()
+	case code.OpGetGlobal:
+		return vm.push(vm.global(operands[0]))
+	case code.OpGetBuiltin:
+		return vm.pushBuiltin(operands[0])
+	case code.OpGetFree:
+		return vm.push(vm.currentFrame().cl.Free[operands[0]])
+	case code.OpJumpNotTruthy:
+		if !object.IsTruthy(vm.pop()) {
+			vm.currentFrame().ip = operands[0]
+		}
+	case code.OpJump:
+		vm.currentFrame().ip = operands[0]
+	case code.OpCall:
+		return vm.executeCall(operands[0])
+	case code.OpTailCall:
+		return vm.executeTailCall(operands[0])
+	case code.OpClosure:
+		return vm.pushClosure(operands[0], operands[1])
+	case code.OpSetLocal:
+		vm.stack[vm.currentFrame().basePointer+operands[0]] = vm.pop()
+	case code.OpGetLocal:
+		return vm.push(vm.stack[vm.currentFrame().basePointer+operands[0]])
+	case code.OpAddConst, code.OpSubConst:
+		return vm.executeConstOperation(op, vm.constants[operands[0]])
+	case code.OpJumpIfNotGreater, code.OpJumpIfNotEqual:
+		holds, err := vm.executeComparisonJump(op)
+		if !holds {
+			vm.currentFrame().ip = operands[0]
+		}
+		return err
+	default:
+		return fmt.Errorf("%s has no wide form", def.Name)
+	}
+	return nil
+}
+
+// executeTailCall calls a closure in the frame of the caller, the instruction after a tail call returns
+// the result anyway. Builtins don't use a frame and are called as usual.
+func (vm *VM) executeTailCall(numArgs int) error {
+	cl, ok := vm.stack[vm.sp-1-numArgs].(*object.Closure)
+	if !ok {
+		return vm.executeCall(numArgs)
+	}
+	if numArgs != cl.Fn.NumParameters {
+		return fmt.Errorf("wrong number of arguments: want=%d, got=%d", cl.Fn.NumParameters, numArgs)
+	}
+	frame := vm.currentFrame()
+	// move the callee and its arguments to where the caller and its arguments are
+	copy(vm.stack[frame.basePointer-1:], vm.stack[vm.sp-1-numArgs:vm.sp])
+	frame.cl = cl
+	frame.ip = 0
+	err := vm.ensureStack(frame.basePointer + cl.Fn.NumLocals)
+	if err != nil {
+		return err
+	}
+	vm.sp = frame.basePointer + cl.Fn.NumLocals
+	vm.clearLocals(frame.basePointer+numArgs, vm.sp)
+	return nil
+}
+
+// global returns the global at index, null if no let has set it yet
+func (vm *VM) global(index int) object.Object {
+	global := vm.globals[index]
+	if global == nil {
+		return Null
+	}
+	return global
+}
+
+// clearLocals sets the locals from start to end to null, so a local a let hasn't set yet
+// doesn't read what the stack held before
+func (vm *VM) clearLocals(start, end int) {
+	for i := start; i < end; i++ {
+		vm.stack[i] = Null
+	}
+}
+
+// scheduleCheck sets nextCheck to the next multiple of object.ContextCheckInterval, or to the instruction past the budget if that comes first
+func (vm *VM) scheduleCheck() {
+	vm.nextCheck = (vm.executed/object.ContextCheckInterval + 1) * object.ContextCheckInterval
+	if vm.limits.MaxInstructions > 0 && vm.limits.MaxInstructions+1 < vm.nextCheck {
+		vm.nextCheck = vm.limits.MaxInstructions + 1
+	}
+}
+
+func (vm *VM) checkLimits() error {
+	if vm.limits.MaxInstructions > 0 && vm.executed > vm.limits.MaxInstructions {
+		return object.InstructionBudgetError(vm.limits.MaxInstructions)
+	}
+	if vm.executed%object.ContextCheckInterval == 0 {
+		select {
+		case <-vm.ctx.Done():
+			return object.ContextError(vm.ctx)
+		default:
+		}
+	}
+	vm.scheduleCheck()
+	return nil
+}
+
 func (vm *VM) callClosure(cl *object.Closure, numArgs int) error {
 	if numArgs != cl.Fn.NumParameters { // check the functionLiteral argumnents number and the call arguments number
 		return fmt.Errorf("wrong number of arguments: want=%d, got=%d", cl.Fn.NumParameters, numArgs)
 	}
-	frame := NewFrame(cl, vm.sp-numArgs) // Store the sp status in the function frame，the second argument is the base pointer
-	vm.pushFrame(frame)
+	if vm.limits.MaxCallDepth > 0 && vm.framesIndex > vm.limits.MaxCallDepth { // the main frame isn't a call
+		return object.CallDepthError(vm.limits.MaxCallDepth)
+	}
+	frame, err := vm.pushFrame(cl, vm.sp-numArgs) // Store the sp status in the function frame，the second argument is the base pointer
+	if err != nil {
+		return err
+	}
+	err = vm.ensureStack(frame.basePointer + cl.Fn.NumLocals)
+	if err != nil {
+		return err
+	}
 	vm.sp = frame.basePointer + cl.Fn.NumLocals
+	vm.clearLocals(frame.basePointer+numArgs, vm.sp)
 	return nil
 }
 
 func (vm *VM) callBuiltin(builtin *object.Builtin, numArgs int) error {
 	args := vm.stack[vm.sp-numArgs : vm.sp]
-	result := builtin.Fn(args...)
+	outerErr := vm.callErr
+	vm.callErr = nil
+	result := builtin.Fn(vm, args...)
+	callErr := vm.callErr
+	vm.callErr = outerErr
+	if callErr != nil { // a function the builtin called back failed, that stops the vm
+		return callErr
+	}
 	vm.sp = vm.sp - numArgs - 1
 	if result != nil {
-		vm.push(result)
-	} else {
-		vm.push(Null)
+		return vm.push(result)
+	}
+	return vm.push(Null)
+}
+
+// Call applies a closure or builtin to args and returns the result. It runs on the stack of the vm,
+// so it can be used by builtins while the vm is running as well as by the host after Run returned.
+func (vm *VM) Call(fn object.Object, args ...object.Object) (object.Object, error) {
+	sp := vm.sp
+	framesIndex := vm.framesIndex
+	result, err := vm.call(fn, args)
+	if err != nil {
+		// drop whatever the failed call left behind, the vm can still be called afterwards
+		vm.sp = sp
+		vm.framesIndex = framesIndex
+		vm.callErr = err
+		return nil, err
+	}
+	return result, nil
+}
+
+// TryCall is Call for the try builtin. If the call exceeds the memory limit, the vm drops what the call
+// allocated from its account and goes on, the error is only returned.
+func (vm *VM) TryCall(fn object.Object, args ...object.Object) (object.Object, error) {
+	allocated := vm.allocated
+	result, err := vm.Call(fn, args...)
+	if errors.Is(err, object.ErrMemoryLimitExceeded) {
+		vm.allocated = allocated
+		vm.callErr = nil
+	}
+	return result, err
+}
+
+func (vm *VM) call(fn object.Object, args []object.Object) (object.Object, error) {
+	err := vm.push(fn)
+	if err != nil {
+		return nil, err
+	}
+	for _, arg := range args {
+		err = vm.push(arg)
+		if err != nil {
+			return nil, err
+		}
+	}
+	switch fn := fn.(type) {
+	case *object.Closure:
+		exitFrame := vm.framesIndex
+		err = vm.callClosure(fn, len(args))
+		if err != nil {
+			return nil, err
+		}
+		err = vm.run(exitFrame)
+	case *object.Builtin:
+		err = vm.callBuiltin(fn, len(args))
+	default:
+		err = fmt.Errorf("calling non-function and non-built-in")
+	}
+	if err != nil {
+		return nil, err
+	}
+	return vm.pop(), nil
+}
+
+// Allocate accounts size bytes against Limits.MaxAllocBytes. Exceeding the limit stops the vm,
+// even if the builtin that allocates ignores the error.
+func (vm *VM) Allocate(size int64) error {
+	err := vm.allocate(size)
+	if err != nil {
+		vm.callErr = err
+	}
+	return err
+}
+
+// Random returns the random numbers of the current run.
+func (vm *VM) Random() *object.RandomSource {
+	return &vm.random
+}
+
+func (vm *VM) allocate(size int64) error {
+	vm.allocated += size
+	if vm.limits.MaxAllocBytes > 0 && vm.allocated > vm.limits.MaxAllocBytes {
+		return object.AllocationError(vm.limits.MaxAllocBytes)
 	}
 	return nil
 }
 
-func (vm *VM) buildArray(startIndex, endIndex int) object.Object {
+// executeArray replaces the numElements values on top of the stack with an array of them
+func (vm *VM) executeArray(numElements int) error {
+	array, err := vm.buildArray(vm.sp-numElements, vm.sp)
+	if err != nil {
+		return err
+	}
+	vm.sp = vm.sp - numElements
+	return vm.push(array)
+}
+
+// executeHash replaces the numElements keys and values on top of the stack with a hash of them
+func (vm *VM) executeHash(numElements int) error {
+	hash, err := vm.buildHash(vm.sp-numElements, vm.sp)
+	if err != nil {
+		return err
+	}
+	vm.sp = vm.sp - numElements
+	return vm.push(hash)
+}
+
+func (vm *VM) buildArray(startIndex, endIndex int) (object.Object, error) {
+	err := vm.allocate(object.ArraySize(endIndex - startIndex))
+	if err != nil {
+		return nil, err
+	}
 	elements := make([]object.Object, endIndex-startIndex)
 	for i := startIndex; i < endIndex; i++ {
 		elements[i-startIndex] = vm.stack[i]
 	}
-	return &object.Array{Elements: elements}
+	return &object.Array{Elements: elements}, nil
 }
 
 func (vm *VM) buildHash(startIndex, endIndex int) (object.Object, error) {
-	hashedPairs := make(map[object.HashKey]object.HashPair)
+	err := vm.allocate(object.HashSize((endIndex - startIndex) / 2))
+	if err != nil {
+		return nil, err
+	}
+	hash := object.NewHash((endIndex - startIndex) / 2)
 	for i := startIndex; i < endIndex; i += 2 {
 		key := vm.stack[i]
 		value := vm.stack[i+1]
-		pair := object.HashPair{Key: key, Value: value}
-		hashKey, ok := key.(object.Hashable)
+		hashKey, ok := object.AsHashable(key)
 		if !ok {
 			return nil, fmt.Errorf("unusable as hash key: %s", key.Type())
 		}
-		hashedPairs[hashKey.HashKey()] = pair
+		hash.Set(hashKey, value)
 	}
-	return &object.Hash{Pairs: hashedPairs}, nil
+	return hash, nil
 }
 
 func (vm *VM) executeIndexExpression(left, index object.Object) error {
@@ -295,11 +571,34 @@ func (vm *VM) executeIndexExpression(left, index object.Object) error {
 		return vm.executeArrayIndex(left, index)
 	case left.Type() == object.HashObj:
 		return vm.executeHashIndex(left, index)
+	case left.Type() == object.StringObj && index.Type() == object.IntegerObj:
+		char, ok := object.CharAt(left.(*object.String).Value, index.(*object.Integer).Value)
+		if !ok {
+			return vm.push(Null)
+		}
+		return vm.push(char)
 	default:
 		return fmt.Errorf("index operator not supported: %s", left.Type())
 	}
 }
 
+func (vm *VM) executeSliceExpression(left, start, end object.Object) error {
+	result, err := object.Slice(left, start, end)
+	if err != nil {
+		return err
+	}
+	switch result := result.(type) {
+	case *object.Array:
+		err = vm.allocate(object.ArraySize(len(result.Elements)))
+	case *object.String:
+		err = vm.allocate(object.StringSize(len(result.Value)))
+	}
+	if err != nil {
+		return err
+	}
+	return vm.push(result)
+}
+
 func (vm *VM) executeArrayIndex(array, index object.Object) error {
 	arrayObject := array.(*object.Array)
 	i := index.(*object.Integer).Value
@@ -312,15 +611,15 @@ func (vm *VM) executeArrayIndex(array, index object.Object) error {
 
 func (vm *VM) executeHashIndex(hash, index object.Object) error {
 	hashObject := hash.(*object.Hash)
-	key, ok := index.(object.Hashable)
+	key, ok := object.AsHashable(index)
 	if !ok {
 		return fmt.Errorf("unusable as hash key: %s", index.Type())
 	}
-	pair, ok := hashObject.Pairs[key.HashKey()]
+	value, ok := hashObject.Get(key)
 	if !ok {
 		return vm.push(Null)
 	}
-	return vm.push(pair.Value)
+	return vm.push(value)
 }
 
 func (vm *VM) executeBinaryOperation(op code.Opcode) error {
@@ -332,6 +631,8 @@ func (vm *VM) executeBinaryOperation(op code.Opcode) error {
 	switch {
 	case leftType == object.IntegerObj && rightType == object.IntegerObj:
 		return vm.executeBinaryIntegerOperation(op, left, right)
+	case leftType == object.FloatObj || rightType == object.FloatObj:
+		return vm.executeBinaryFloatOperation(op, left, right)
 	case leftType == object.StringObj && rightType == object.StringObj:
 		return vm.executeBinaryStringOperation(op, left, right)
 	default:
@@ -339,17 +640,73 @@ func (vm *VM) executeBinaryOperation(op code.Opcode) error {
 	}
 }
 
+// executeConstOperation adds constant to or subtracts it from the top of the stack.
+// Integers are computed in place, anything else takes the way of OpConstant and OpAdd or OpSub.
+func (vm *VM) executeConstOperation(op code.Opcode, constant object.Object) error {
+	left, ok := vm.stack[vm.sp-1].(*object.Integer)
+	right, rightOk := constant.(*object.Integer)
+	if ok && rightOk {
+		if op == code.OpAddConst {
+			vm.stack[vm.sp-1] = object.NewInteger(left.Value + right.Value)
+		} else {
+			vm.stack[vm.sp-1] = object.NewInteger(left.Value - right.Value)
+		}
+		return nil
+	}
+	err := vm.push(constant)
+	if err != nil {
+		return err
+	}
+	if op == code.OpAddConst {
+		return vm.executeBinaryOperation(code.OpAdd)
+	}
+	return vm.executeBinaryOperation(code.OpSub)
+}
+
+// executeComparisonJump compares the two values on top of the stack for a jump that is taken unless the comparison holds.
+// Integers are compared right away, anything else takes the way of OpGreaterThan or OpEqual and OpJumpNotTruthy.
+func (vm *VM) executeComparisonJump(op code.Opcode) (bool, error) {
+	left, ok := vm.stack[vm.sp-2].(*object.Integer)
+	right, rightOk := vm.stack[vm.sp-1].(*object.Integer)
+	var holds bool
+	switch {
+	case ok && rightOk && op == code.OpJumpIfNotGreater:
+		vm.sp -= 2
+		holds = left.Value > right.Value
+	case ok && rightOk:
+		vm.sp -= 2
+		holds = left.Value == right.Value
+	default:
+		comparison := code.OpEqual
+		if op == code.OpJumpIfNotGreater {
+			comparison = code.OpGreaterThan
+		}
+		err := vm.executeComparison(comparison)
+		if err != nil {
+			return false, err
+		}
+		holds = object.IsTruthy(vm.pop())
+	}
+	return holds, nil
+}
+
 func (vm *VM) executeComparison(op code.Opcode) error {
 	right := vm.pop()
 	left := vm.pop()
 	if left.Type() == object.IntegerObj && right.Type() == object.IntegerObj {
 		return vm.executeIntegerComparison(op, left, right)
 	}
+	if leftValue, rightValue, ok := object.FloatOperands(left, right); ok && op == code.OpGreaterThan {
+		return vm.push(nativeBoolToBooleanObject(leftValue > rightValue))
+	}
+	if left.Type() == object.StringObj && right.Type() == object.StringObj && op == code.OpGreaterThan {
+		return vm.push(nativeBoolToBooleanObject(left.(*object.String).Value > right.(*object.String).Value))
+	}
 	switch op {
 	case code.OpEqual:
-		return vm.push(nativeBoolToBooleanObject(right == left))
+		return vm.push(nativeBoolToBooleanObject(object.Equal(left, right)))
 	case code.OpNotEqual:
-		return vm.push(nativeBoolToBooleanObject(right != left))
+		return vm.push(nativeBoolToBooleanObject(!object.Equal(left, right)))
 	default:
 		return fmt.Errorf("unknown operator: %d (%s %s)", op, left.Type(), right.Type())
 	}
@@ -394,12 +751,14 @@ func (vm *VM) executeBangOperator() error {
 }
 
 func (vm *VM) executeMinusOperator() error {
-	operand := vm.pop()
-	if operand.Type() != object.IntegerObj {
+	switch operand := vm.pop().(type) {
+	case *object.Integer:
+		return vm.push(object.NewInteger(-operand.Value))
+	case *object.Float:
+		return vm.push(&object.Float{Value: -operand.Value})
+	default:
 		return fmt.Errorf("unsupported type for negation: %s", operand.Type())
 	}
-	value := operand.(*object.Integer).Value
-	return vm.push(&object.Integer{Value: -value})
 }
 
 func (vm *VM) executeBinaryIntegerOperation(op code.Opcode,
@@ -416,11 +775,41 @@ func (vm *VM) executeBinaryIntegerOperation(op code.Opcode,
 	case code.OpMul:
 		result = leftValue * rightValue
 	case code.OpDiv:
+		if rightValue == 0 {
+			return fmt.Errorf("division by zero")
+		}
 		result = leftValue / rightValue
 	default:
 		return fmt.Errorf("unknown integer operator: %d", op)
 	}
-	return vm.push(&object.Integer{Value: result})
+	return vm.push(object.NewInteger(result))
+}
+
+// executeBinaryFloatOperation computes an operation on two numbers of which one at least is a float
+func (vm *VM) executeBinaryFloatOperation(op code.Opcode,
+	left, right object.Object,
+) error {
+	leftValue, rightValue, ok := object.FloatOperands(left, right)
+	if !ok {
+		return fmt.Errorf("unsupported types for binary operation: %s %s", left.Type(), right.Type())
+	}
+	var result float64
+	switch op {
+	case code.OpAdd:
+		result = leftValue + rightValue
+	case code.OpSub:
+		result = leftValue - rightValue
+	case code.OpMul:
+		result = leftValue * rightValue
+	case code.OpDiv:
+		if rightValue == 0 {
+			return fmt.Errorf("division by zero")
+		}
+		result = leftValue / rightValue
+	default:
+		return fmt.Errorf("unknown float operator: %d", op)
+	}
+	return vm.push(&object.Float{Value: result})
 }
 
 func (vm *VM) executeBinaryStringOperation(op code.Opcode,
@@ -431,12 +820,19 @@ func (vm *VM) executeBinaryStringOperation(op code.Opcode,
 	}
 	leftValue := left.(*object.String).Value
 	rightValue := right.(*object.String).Value
+	err := vm.allocate(object.StringSize(len(leftValue) + len(rightValue)))
+	if err != nil {
+		return err
+	}
 	return vm.push(&object.String{Value: leftValue + rightValue})
 }
 
 func (vm *VM) push(o object.Object) error {
-	if vm.sp >= StackSize {
-		return fmt.Errorf("stack overflow")
+	if vm.sp >= len(vm.stack) {
+		err := vm.ensureStack(vm.sp + 1)
+		if err != nil {
+			return err
+		}
 	}
 	vm.stack[vm.sp] = o
 	vm.sp++
@@ -453,23 +849,50 @@ func (vm *VM) LastPoppedStackElem() object.Object {
 	return vm.stack[vm.sp]
 }
 
-func isTruthy(obj object.Object) bool {
-	switch obj := obj.(type) {
-	case *object.Boolean:
-		return obj.Value
-	case *object.Null:
-		return false
-	default:
-		return true
-	}
-}
-
 func (vm *VM) currentFrame() *Frame {
 	return vm.frames[vm.framesIndex-1]
 }
-func (vm *VM) pushFrame(f *Frame) {
-	vm.frames[vm.framesIndex] = f
+
+// ensureStack grows the stack to hold size values
+func (vm *VM) ensureStack(size int) error {
+	if size <= len(vm.stack) {
+		return nil
+	}
+	maxSize := StackSize
+	if vm.limits.MaxStackSize > 0 {
+		maxSize = vm.limits.MaxStackSize
+	}
+	if size > maxSize {
+		return vm.stackOverflow(fmt.Sprintf("the stack exceeds %d values", maxSize))
+	}
+	stack := make([]object.Object, min(max(2*len(vm.stack), size), maxSize))
+	copy(stack, vm.stack[:vm.sp])
+	vm.stack = stack
+	return nil
+}
+
+// pushFrame pushes a frame calling cl. The frames above the current one are reused, so most calls don't allocate.
+func (vm *VM) pushFrame(cl *object.Closure, basePointer int) (*Frame, error) {
+	if vm.framesIndex >= len(vm.frames) {
+		maxFrames := MaxFrames
+		if vm.limits.MaxCallDepth > 0 {
+			maxFrames = vm.limits.MaxCallDepth + 1 // the main frame isn't a call
+		}
+		if vm.framesIndex >= maxFrames {
+			return nil, vm.stackOverflow(fmt.Sprintf("more than %d frames", maxFrames))
+		}
+		frames := make([]*Frame, min(2*len(vm.frames), maxFrames))
+		copy(frames, vm.frames[:vm.framesIndex])
+		vm.frames = frames
+	}
+	f := vm.frames[vm.framesIndex]
+	if f == nil {
+		f = &Frame{}
+		vm.frames[vm.framesIndex] = f
+	}
+	f.cl, f.ip, f.basePointer = cl, 0, basePointer
 	vm.framesIndex++
+	return f, nil
 }
 
 func (vm *VM) popFrame() *Frame {
@@ -477,6 +900,14 @@ func (vm *VM) popFrame() *Frame {
 	return vm.frames[vm.framesIndex]
 }
 
+func (vm *VM) pushBuiltin(index int) error {
+	builtin := vm.builtins.Get(index)
+	if builtin == nil {
+		return fmt.Errorf("builtin %d undefined", index)
+	}
+	return vm.push(builtin)
+}
+
 func (vm *VM) pushClosure(constIndex int, numFree int) error {
 	constant := vm.constants[constIndex]
 	function, ok := constant.(*object.CompiledFunction)
@@ -1,13 +1,19 @@
 package vm
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"jonathan/ast"
 	"jonathan/compiler"
 	"jonathan/lexer"
 	"jonathan/object"
 	"jonathan/parser"
+	"jonathan/register"
+	"math"
+	"strings"
 	"testing"
+	"time"
 )
 
 func parse(input string) *ast.Program {
@@ -24,32 +30,58 @@ type vmTestCase struct {
 func runVmTests(t *testing.T, tests []vmTestCase) {
 	t.Helper()
 	for _, tt := range tests {
-		program := parse(tt.input)
-		comp := compiler.NewCompiler()
-		err := comp.Compile(program)
-		if err != nil {
-			t.Fatalf("compiler error: %s", err)
+		// every case has to give the same result with and without the optimisations
+		for _, optimizations := range []compiler.Optimizations{0, compiler.AllOptimizations} {
+			runVmTest(t, tt, optimizations)
 		}
+		// and the register vm has to agree with the stack vm
+		runRegisterVmTest(t, tt)
+	}
+}
 
-		for i, constant := range comp.Bytecode().Constants {
-			fmt.Printf("CONSTANT %d %p (%T):\n", i, constant, constant)
-			switch constant := constant.(type) {
-			case *object.CompiledFunction:
-				fmt.Printf("Instructions:\n%s", constant.Instructions)
-			case *object.Integer:
-				fmt.Printf("Value: %d\n", constant.Value)
-			}
-			fmt.Printf("\n")
-		}
+func runRegisterVmTest(t *testing.T, tt vmTestCase) {
+	t.Helper()
+	comp := register.NewCompiler()
+	err := comp.Compile(parse(tt.input))
+	if err != nil {
+		t.Fatalf("register compiler error: %s", err)
+	}
+	vm := register.NewVm(comp.Bytecode())
+	err = vm.Run()
+	if err != nil {
+		t.Fatalf("register vm error: %s", err)
+	}
+	testExpectedObject(t, tt.expected, vm.LastResult())
+}
 
-		vm := NewVm(comp.Bytecode())
-		err = vm.Run()
-		if err != nil {
-			t.Fatalf("vm error: %s", err)
+func runVmTest(t *testing.T, tt vmTestCase, optimizations compiler.Optimizations) {
+	t.Helper()
+	program := parse(tt.input)
+	comp := compiler.NewCompiler()
+	comp.SetOptimizations(optimizations)
+	err := comp.Compile(program)
+	if err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+
+	for i, constant := range comp.Bytecode().Constants {
+		fmt.Printf("CONSTANT %d %p (%T):\n", i, constant, constant)
+		switch constant := constant.(type) {
+		case *object.CompiledFunction:
+			fmt.Printf("Instructions:\n%s", constant.Instructions)
+		case *object.Integer:
+			fmt.Printf("Value: %d\n", constant.Value)
 		}
-		stackElem := vm.LastPoppedStackElem()
-		testExpectedObject(t, tt.expected, stackElem)
+		fmt.Printf("\n")
+	}
+
+	vm := NewVm(comp.Bytecode())
+	err = vm.Run()
+	if err != nil {
+		t.Fatalf("vm error: %s", err)
 	}
+	stackElem := vm.LastPoppedStackElem()
+	testExpectedObject(t, tt.expected, stackElem)
 }
 
 func testExpectedObject(t *testing.T,
@@ -62,6 +94,11 @@ func testExpectedObject(t *testing.T,
 		if err != nil {
 			t.Errorf("testIntegerObject failed: %s", err)
 		}
+	case float64:
+		err := testFloatObject(expected, actual)
+		if err != nil {
+			t.Errorf("testFloatObject failed: %s", err)
+		}
 	case string:
 		err := testStringObject(expected, actual)
 		if err != nil {
@@ -95,15 +132,20 @@ func testExpectedObject(t *testing.T,
 			t.Errorf("object is not Hash. got=%T (%+v)", actual, actual)
 			return
 		}
-		if len(hash.Pairs) != len(expected) {
+		if hash.Len() != len(expected) {
 			t.Errorf("hash has wrong number of Pairs. want=%d, got=%d",
-				len(expected), len(hash.Pairs))
+				len(expected), hash.Len())
 			return
 		}
+		pairs := make(map[object.HashKey]object.HashPair, hash.Len())
+		for _, pair := range hash.Pairs() {
+			pairs[pair.Key.(object.Hashable).HashKey()] = pair
+		}
 		for expectedKey, expectedValue := range expected {
-			pair, ok := hash.Pairs[expectedKey]
+			pair, ok := pairs[expectedKey]
 			if !ok {
 				t.Errorf("no pair for given key in Pairs")
+				continue
 			}
 			err := testIntegerObject(expectedValue, pair.Value)
 			if err != nil {
@@ -151,6 +193,18 @@ func testIntegerObject(expected int64, actual object.Object) error {
 	return nil
 }
 
+func testFloatObject(expected float64, actual object.Object) error {
+	result, ok := actual.(*object.Float)
+	if !ok {
+		return fmt.Errorf("object is not Float. got=%T (%+v)", actual, actual)
+	}
+	if result.Value != expected {
+		return fmt.Errorf("object has wrong value. got=%g, want=%g",
+			result.Value, expected)
+	}
+	return nil
+}
+
 func testStringObject(expected string, actual object.Object) error {
 	result, ok := actual.(*object.String)
 	if !ok {
@@ -186,6 +240,39 @@ func TestIntegerArithmetic(t *testing.T) {
 	runVmTests(t, tests)
 }
 
+func TestFloatArithmetic(t *testing.T) {
+	tests := []vmTestCase{
+		{"PI * 2", math.Pi * 2},
+		{"sqrt(4) + 1", 3.0},
+		{"1 + sqrt(4)", 3.0},
+		{"10 - sqrt(4)", 8.0},
+		{"sqrt(4) - 10", -8.0},
+		{"1 / sqrt(4)", 0.5},
+		{"sqrt(4) / 4", 0.5},
+		{"-E", -math.E},
+		{"-sqrt(4) * 3", -6.0},
+		{"sqrt(2) > 1", true},
+		{"sqrt(2) < 1", false},
+		{"2 > sqrt(2)", true},
+		{"PI < E", false},
+		{"sqrt(4) == 2", true},
+		{"sqrt(4) != 2", false},
+		{"if (sqrt(2) > 1) { 1 } else { 2 }", 1},
+		{"let f = fn(x) { x + 1 + 2 }; f(sqrt(4))", 5.0},
+		{"2.5", 2.5},
+		{"-0.5 * 3", -1.5},
+		{"1 < 1.5", true},
+		{"0.1 + 0.2 == 0.3", false},
+		{"round(2.5)", 3},
+		{"floor(-1.5)", -2},
+		{"if (0.0) { 1 } else { 2 }", 1},
+		// the constants of x * a * b must not be merged, they would wrap around for integers only
+		{"let x = PI; x * 4611686018427387904 * 4", math.Pi * 4611686018427387904 * 4},
+		{"let f = fn(x) { x * 4611686018427387904 * 4 }; f(PI)", math.Pi * 4611686018427387904 * 4},
+	}
+	runVmTests(t, tests)
+}
+
 func TestBooleanExpressions(t *testing.T) {
 	tests := []vmTestCase{
 		{"true", true},
@@ -214,6 +301,18 @@ func TestBooleanExpressions(t *testing.T) {
 		{"!!false", false},
 		{"!!5", true},
 		{"!(if (false) { 5; })", true},
+		{`"a" + "b" == "ab"`, true},
+		{`"a" != "b"`, true},
+		{"[1, 2] == [1, 2]", true},
+		{"[1, 2] == [2, 1]", false},
+		{"[1, [2, 3]] == [1, [2, 3]]", true},
+		{"[1, 2] != [1, 2, 3]", true},
+		{`{"a": 1, "b": [2]} == {"b": [2], "a": 1}`, true},
+		{`{"a": 1} == {"a": 2}`, false},
+		{`{"a": 1} == {"b": 1}`, false},
+		{"[1] == 1", false},
+		{"let f = fn() { 1 }; f == f", true},
+		{"fn() { 1 } == fn() { 1 }", false},
 	}
 	runVmTests(t, tests)
 }
@@ -229,6 +328,11 @@ func TestConditionals(t *testing.T) {
 		{"if (1 > 2) { 10 } else { 20 }", 20},
 		{"if (1 > 2) { 10 }", Null},
 		{"if (false) { 10 }", Null},
+		{"if (false) { let y = 1 }; y", Null},
+		{"if (true) { 1 } else { let y = 1; if (y > 0) { let z = 2 } }; z", Null},
+		{"let f = fn() { if (false) { let y = 1 }; y }; f()", Null},
+		{"let f = fn(n) { if (n > 0) { let y = n; y }; if (n == 0) { y } else { f(n - 1) } }; f(2)", Null},
+		{"if (false) { let y = 1 } else { let x = 2; x }; x + 1", 3},
 	}
 	runVmTests(t, tests)
 }
@@ -512,6 +616,22 @@ func TestCallingFunctionsWithWrongArguments(t *testing.T) {
 	}
 }
 
+func TestDivisionByZero(t *testing.T) {
+	for _, input := range []string{"1 / 0", "1 / (2 - 2)", "let f = fn(a, b) { a / b }; f(1, 0)", "PI / 0", "1 / (sqrt(4) - 2)", "1.5 / 0"} {
+		for _, optimizations := range []compiler.Optimizations{0, compiler.AllOptimizations} {
+			comp := compiler.NewCompiler()
+			comp.SetOptimizations(optimizations)
+			if err := comp.Compile(parse(input)); err != nil {
+				t.Fatalf("compiler error: %s", err)
+			}
+			err := NewVm(comp.Bytecode()).Run()
+			if err == nil || err.Error() != "division by zero" {
+				t.Errorf("wrong vm error for %s: want=%q, got=%v", input, "division by zero", err)
+			}
+		}
+	}
+}
+
 func TestBuiltinFunctions(t *testing.T) {
 	tests := []vmTestCase{
 		{`len("")`, 0},
@@ -683,3 +803,830 @@ func TestRecursiveFibonacci(t *testing.T) {
 	}
 	runVmTests(t, tests)
 }
+
+func TestHostBuiltins(t *testing.T) {
+	builtins := object.NewBuiltinRegistry()
+	_, err := builtins.Register("add", 2, func(ctx object.CallContext, args ...object.Object) object.Object {
+		left := args[0].(*object.Integer).Value
+		right := args[1].(*object.Integer).Value
+		return &object.Integer{Value: left + right}
+	})
+	if err != nil {
+		t.Fatalf("register failed: %s", err)
+	}
+	tests := []vmTestCase{
+		{`add(1, 2)`, 3},
+		{`let f = fn(x) { add(x, len([1, 2])) }; f(40)`, 42},
+		{`add(1)`, &object.Error{Message: "wrong number of arguments. got=1, want=2"}},
+	}
+	for _, tt := range tests {
+		comp := compiler.NewCompilerWithBuiltins(builtins)
+		err := comp.Compile(parse(tt.input))
+		if err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+		vm := NewVm(comp.Bytecode())
+		err = vm.Run()
+		if err != nil {
+			t.Fatalf("vm error: %s", err)
+		}
+		testExpectedObject(t, tt.expected, vm.LastPoppedStackElem())
+	}
+
+	comp := compiler.NewCompiler()
+	err = comp.Compile(parse(`add(1, 2)`))
+	if err == nil || err.Error() != "undefined variable add" {
+		t.Fatalf("builtin leaked into default registry. err=%v", err)
+	}
+}
+
+// newCallbackBuiltins registers builtins calling back into the functions they get as arguments.
+func newCallbackBuiltins(t *testing.T) *object.BuiltinRegistry {
+	t.Helper()
+	builtins := object.NewBuiltinRegistry()
+	_, err := builtins.Register("twice", 2, func(ctx object.CallContext, args ...object.Object) object.Object {
+		once, err := ctx.Call(args[0], args[1])
+		if err != nil {
+			return &object.Error{Message: err.Error()}
+		}
+		twice, err := ctx.Call(args[0], once)
+		if err != nil {
+			return &object.Error{Message: err.Error()}
+		}
+		return twice
+	})
+	if err != nil {
+		t.Fatalf("register failed: %s", err)
+	}
+	_, err = builtins.RegisterFunc("each_index", func(ctx object.CallContext, n int, fn object.Object) ([]object.Object, error) {
+		results := make([]object.Object, n)
+		for i := range results {
+			result, err := ctx.Call(fn, &object.Integer{Value: int64(i)})
+			if err != nil {
+				return nil, err
+			}
+			results[i] = result
+		}
+		return results, nil
+	})
+	if err != nil {
+		t.Fatalf("register failed: %s", err)
+	}
+	return builtins
+}
+
+func TestBuiltinsCallingClosures(t *testing.T) {
+	builtins := newCallbackBuiltins(t)
+	tests := []vmTestCase{
+		{`twice(fn(x) { x * 2 }, 3)`, 12},
+		{`let offset = 10; twice(fn(x) { x + offset }, 1)`, 21},
+		{`let f = fn(y) { twice(fn(x) { x + y }, 0) }; f(5) + 1`, 11},
+		{`twice(len, "abc")`, &object.Error{Message: "argument to `len` not supported, got INTEGER"}},
+		{`each_index(3, fn(i) { i * i })`, []int{0, 1, 4}},
+		{`each_index(2, fn(i) { twice(fn(x) { x + i }, i) })`, []int{0, 3}},
+	}
+	for _, tt := range tests {
+		comp := compiler.NewCompilerWithBuiltins(builtins)
+		err := comp.Compile(parse(tt.input))
+		if err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+		vm := NewVm(comp.Bytecode())
+		err = vm.Run()
+		if err != nil {
+			t.Fatalf("vm error: %s", err)
+		}
+		testExpectedObject(t, tt.expected, vm.LastPoppedStackElem())
+	}
+}
+
+func TestBuiltinCallbackErrorStopsVm(t *testing.T) {
+	comp := compiler.NewCompilerWithBuiltins(newCallbackBuiltins(t))
+	err := comp.Compile(parse(`twice(fn() { 1 }, 3); 99`))
+	if err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	vm := NewVm(comp.Bytecode())
+	err = vm.Run()
+	if err == nil || err.Error() != "wrong number of arguments: want=0, got=1" {
+		t.Fatalf("wrong vm error. got=%v", err)
+	}
+}
+
+func TestCallFromHost(t *testing.T) {
+	comp := compiler.NewCompiler()
+	err := comp.Compile(parse(`let base = 100; let add = fn(a, b) { a + b + base }; add`))
+	if err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	vm := NewVm(comp.Bytecode())
+	err = vm.Run()
+	if err != nil {
+		t.Fatalf("vm error: %s", err)
+	}
+	add := vm.LastPoppedStackElem()
+	for i := 0; i < 3; i++ {
+		result, err := vm.Call(add, &object.Integer{Value: int64(i)}, &object.Integer{Value: 2})
+		if err != nil {
+			t.Fatalf("call failed: %s", err)
+		}
+		testExpectedObject(t, 102+i, result)
+	}
+	_, err = vm.Call(add, &object.Integer{Value: 1})
+	if err == nil || err.Error() != "wrong number of arguments: want=2, got=1" {
+		t.Fatalf("wrong call error. got=%v", err)
+	}
+	result, err := vm.Call(add, &object.Integer{Value: 1}, &object.Integer{Value: 1})
+	if err != nil {
+		t.Fatalf("vm unusable after failed call: %s", err)
+	}
+	testExpectedObject(t, 102, result)
+
+	length, _ := object.NewBuiltinRegistry().Lookup("len")
+	result, err = vm.Call(length, &object.String{Value: "four"})
+	if err != nil {
+		t.Fatalf("call failed: %s", err)
+	}
+	testExpectedObject(t, 4, result)
+
+	// a limit the call hits is reported like Run reports it
+	comp = compiler.NewCompiler()
+	err = comp.Compile(parse(`fn(n) { [n, n, n, n, n, n, n, n] }`))
+	if err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	vm = NewVm(comp.Bytecode())
+	err = vm.Run()
+	if err != nil {
+		t.Fatalf("vm error: %s", err)
+	}
+	vm.SetLimits(object.Limits{MaxAllocBytes: 100})
+	_, err = vm.Call(vm.LastPoppedStackElem(), &object.Integer{Value: 1})
+	if !errors.Is(err, object.ErrMemoryLimitExceeded) {
+		t.Fatalf("wrong call error. want=%v, got=%v", object.ErrMemoryLimitExceeded, err)
+	}
+}
+
+func TestExecutionLimits(t *testing.T) {
+	tests := []struct {
+		input    string
+		limits   object.Limits
+		expected error
+	}{
+		{`let f = fn(x) { 1 + f(x + 1) }; f(0)`, object.Limits{MaxCallDepth: 100}, object.ErrBudgetExceeded},
+		{`let f = fn(x) { f(x + 1) }; f(0)`, object.Limits{MaxInstructions: 100000}, object.ErrBudgetExceeded},
+		{`let f = fn(x) { if (x > 0) { f(x - 1) } else { 0 } }; f(100)`, object.Limits{MaxCallDepth: 200}, nil},
+		{`let f = fn(x) { if (x > 0) { f(x - 1) } else { 0 } }; f(100)`, object.Limits{MaxInstructions: 500}, object.ErrBudgetExceeded},
+		{`1 + 2`, object.Limits{MaxInstructions: 500}, nil},
+		{`let grow = fn(arr, n) { if (n > 0) { grow(push(arr, n), n - 1) } else { arr } }; len(grow([], 500))`, object.Limits{MaxAllocBytes: 100000}, object.ErrMemoryLimitExceeded},
+		{`let grow = fn(arr, n) { if (n > 0) { grow(push(arr, n), n - 1) } else { arr } }; len(grow([], 500))`, object.Limits{MaxAllocBytes: 10000000}, nil},
+		{`let double = fn(s, n) { if (n > 0) { double(s + s, n - 1) } else { len(s) } }; double("ab", 40)`, object.Limits{MaxAllocBytes: 1 << 20}, object.ErrMemoryLimitExceeded},
+		{`let shrink = fn(arr) { if (len(arr) > 0) { shrink(rest(arr)) } else { 0 } }; shrink([1, 2, 3, 4, 5, 6, 7, 8])`, object.Limits{MaxAllocBytes: 300}, object.ErrMemoryLimitExceeded},
+		{`{"a": 1, "b": 2, "c": 3}`, object.Limits{MaxAllocBytes: 100}, object.ErrMemoryLimitExceeded},
+		{`{"a": 1, "b": 2, "c": 3}`, object.Limits{MaxAllocBytes: 1000}, nil},
+	}
+	for _, tt := range tests {
+		comp := compiler.NewCompiler()
+		err := comp.Compile(parse(tt.input))
+		if err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+		vm := NewVm(comp.Bytecode())
+		vm.SetLimits(tt.limits)
+		err = vm.Run()
+		if tt.expected == nil && err != nil {
+			t.Errorf("%s: unexpected vm error: %s", tt.input, err)
+		}
+		if tt.expected != nil && !errors.Is(err, tt.expected) {
+			t.Errorf("%s: wrong vm error. want=%v, got=%v", tt.input, tt.expected, err)
+		}
+	}
+}
+
+func TestTryMemoryLimit(t *testing.T) {
+	grow := `let grow = fn(s) { grow(s + s) }; `
+	limits := object.Limits{MaxAllocBytes: 1 << 20, MaxInstructions: 100000}
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{grow + `try(fn() { grow("ab") }, fn(err) { err })`, "memory limit exceeded: more than 1048576 bytes allocated"},
+		// what the failed call allocated is forgotten, the script can go on allocating
+		{grow + `let caught = try(fn() { grow("ab") }, fn(err) { "caught" }); len(repeat(caught, 1000))`, 6000},
+		{grow + `try(fn() { [1, 2, 3] }, fn(err) { [] })`, []int64{1, 2, 3}},
+		// the handler and other limits aren't caught
+		{grow + `try(fn() { grow("ab") }, fn(err) { grow(err) })`, object.ErrMemoryLimitExceeded},
+		{`let f = fn(x) { f(x + 1) }; try(fn() { f(0) }, fn(err) { 0 })`, object.ErrBudgetExceeded},
+	}
+	for _, tt := range tests {
+		comp := compiler.NewCompiler()
+		err := comp.Compile(parse(tt.input))
+		if err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+		vm := NewVm(comp.Bytecode())
+		vm.SetLimits(limits)
+		err = vm.Run()
+		registerComp := register.NewCompiler()
+		err2 := registerComp.Compile(parse(tt.input))
+		if err2 != nil {
+			t.Fatalf("register compiler error: %s", err2)
+		}
+		registerVm := register.NewVm(registerComp.Bytecode())
+		registerVm.SetLimits(limits)
+		err2 = registerVm.Run()
+		if expected, ok := tt.expected.(error); ok {
+			if !errors.Is(err, expected) || !errors.Is(err2, expected) {
+				t.Errorf("%s: wrong error. want=%v, got=%v and %v from the register vm", tt.input, expected, err, err2)
+			}
+			continue
+		}
+		if err != nil || err2 != nil {
+			t.Fatalf("%s: vm error: %v, register vm error: %v", tt.input, err, err2)
+		}
+		testExpectedObject(t, tt.expected, vm.LastPoppedStackElem())
+		testExpectedObject(t, tt.expected, registerVm.LastResult())
+	}
+}
+
+func TestRunWithContext(t *testing.T) {
+	fibonacci := `let fib = fn(x) { if (x < 2) { x } else { fib(x - 1) + fib(x - 2) } }; fib(35)`
+	comp := compiler.NewCompiler()
+	err := comp.Compile(parse(fibonacci))
+	if err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	err = NewVm(comp.Bytecode()).RunWithContext(ctx)
+	if !errors.Is(err, object.ErrTimeout) {
+		t.Errorf("expected timeout. got=%v", err)
+	}
+
+	ctx, cancel = context.WithCancel(context.Background())
+	cancel()
+	err = NewVm(comp.Bytecode()).RunWithContext(ctx)
+	if !errors.Is(err, context.Canceled) || errors.Is(err, object.ErrTimeout) {
+		t.Errorf("expected cancellation. got=%v", err)
+	}
+}
+
+func TestConstantFolding(t *testing.T) {
+	tests := []vmTestCase{
+		{"-(10 - 4) / 2 + 1 * 3", 0},
+		{`"mon" + "key"`, "monkey"},
+		{"1 < 2 == !false", true},
+		{"!!5", true},
+		{"true != (1 > 2)", true},
+		{"let x = 9223372036854775807; x + 1 - 1", 9223372036854775807},
+		{"let x = 10; x - 3 - 4 + 2", 5},
+		{"let x = 10; x * 2 * -3", -60},
+		{"if (1 > 2) { 10 } else { 20 }", 20},
+		{"if (0) { 10 }", 10},
+		{"if (!true) { 10 }", Null},
+		{"let f = fn(x) { if (true) { x + 1 + 1 } }; f(1)", 3},
+	}
+	runVmTests(t, tests)
+}
+
+func TestDeadCode(t *testing.T) {
+	tests := []vmTestCase{
+		{"let f = fn() { return 1; 2 }; f()", 1},
+		{"let f = fn(x) { if (x) { return 1 } else { return 2 }; 3 }; f(false)", 2},
+		{"let f = fn() { let unused = [1, 2]; let used = 3; used }; f()", 3},
+		{"let f = fn() { let a = 1; let g = fn() { a }; g() }; f()", 1},
+	}
+	runVmTests(t, tests)
+}
+
+func TestTailCalls(t *testing.T) {
+	tests := []vmTestCase{
+		{"let loop = fn(n, acc) { if (n == 0) { acc } else { loop(n - 1, acc + 1) } }; loop(100000, 0)", 100000},
+		{"let loop = fn(n) { if (n == 0) { return 0 }; return loop(n - 1) }; loop(5000)", 0},
+		{`
+		let build = fn(n, arr) { if (n == 0) { arr } else { build(n - 1, push(arr, n)) } };
+		let sum = fn(arr, acc) { if (len(arr) == 0) { return acc }; sum(rest(arr), acc + first(arr)) };
+		sum(build(2000, []), 0)`, 2001000},
+		{`
+		let isEven = fn(n, isOdd) { if (n == 0) { true } else { isOdd(n - 1, isEven) } };
+		let isOdd = fn(n, isEven) { if (n == 0) { false } else { isEven(n - 1, isOdd) } };
+		isEven(10001, isOdd)`, false},
+		{"let count = fn(a) { len(a) }; count([1, 2, 3])", 3},
+		{"let adder = fn(x) { fn(n, acc) { if (n == 0) { acc } else { adder(x)(n - 1, acc + x) } } }; adder(2)(3000, 0)", 6000},
+	}
+	runVmTests(t, tests)
+}
+
+func TestGrowingStack(t *testing.T) {
+	tests := []vmTestCase{
+		// deeper than the old fixed 1024 frames and 2048 values
+		{"let f = fn(n) { if (n == 0) { 0 } else { 1 + f(n - 1) } }; f(5000)", 5000},
+		{"let f = fn(n) { if (n == 0) { [] } else { push(f(n - 1), n) } }; len(f(3000))", 3000},
+	}
+	runVmTests(t, tests)
+}
+
+func TestStackOverflow(t *testing.T) {
+	tests := []struct {
+		input   string
+		limits  object.Limits
+		reason  string
+		trace   []string
+		omitted bool
+	}{
+		{
+			input:   "let f = fn(n) { 1 + f(n + 1) }; f(0)",
+			reason:  fmt.Sprintf("more than %d frames", MaxFrames),
+			trace:   []string{"f", "f"},
+			omitted: true,
+		},
+		{
+			input:   "let f = fn(n) { 1 + f(n + 1) }; let g = fn() { f(0) + 1 }; g()",
+			limits:  object.Limits{MaxStackSize: 50},
+			reason:  "the stack exceeds 50 values",
+			trace:   []string{"f", "f"},
+			omitted: true,
+		},
+		{
+			input:  "[1, 2, 3, 4, 5, 6, 7, 8, 9, 10]",
+			limits: object.Limits{MaxStackSize: 5},
+			reason: "the stack exceeds 5 values",
+			trace:  []string{"<main>"},
+		},
+		{
+			input:  "fn() { [1, 2, 3, 4, 5, 6, 7, 8, 9, 10] }()",
+			limits: object.Limits{MaxStackSize: 5},
+			reason: "the stack exceeds 5 values",
+			trace:  []string{"<anonymous>", "<main>"},
+		},
+	}
+	for _, tt := range tests {
+		comp := compiler.NewCompiler()
+		err := comp.Compile(parse(tt.input))
+		if err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+		vm := NewVm(comp.Bytecode())
+		vm.SetLimits(tt.limits)
+		err = vm.Run()
+		if !errors.Is(err, object.ErrStackOverflow) {
+			t.Fatalf("%s: expected stack overflow. got=%v", tt.input, err)
+		}
+		var overflow *StackOverflowError
+		if !errors.As(err, &overflow) {
+			t.Fatalf("%s: error is not a StackOverflowError. got=%T", tt.input, err)
+		}
+		if overflow.Reason != tt.reason {
+			t.Errorf("%s: wrong reason. want=%q, got=%q", tt.input, tt.reason, overflow.Reason)
+		}
+		if len(overflow.Trace) < len(tt.trace) {
+			t.Fatalf("%s: trace too short. got=%q", tt.input, overflow.Trace)
+		}
+		for i, name := range tt.trace {
+			if overflow.Trace[i] != name {
+				t.Errorf("%s: wrong frame %d. want=%s, got=%s", tt.input, i, name, overflow.Trace[i])
+			}
+		}
+		if (overflow.Omitted > 0) != tt.omitted {
+			t.Errorf("%s: wrong number of omitted frames. got=%d", tt.input, overflow.Omitted)
+		}
+	}
+}
+
+func TestWideOperands(t *testing.T) {
+	names := make([]string, 260)
+	lets := make([]string, 260)
+	values := make([]string, 260)
+	for i := range names {
+		names[i] = letterName(i)
+		lets[i] = fmt.Sprintf("let %s = %d;", names[i], i)
+		values[i] = fmt.Sprint(i)
+	}
+	tests := []vmTestCase{
+		// more than 256 locals
+		{fmt.Sprintf("fn() { %s %s + %s }()", strings.Join(lets, " "), names[0], names[259]), 259},
+		// more than 256 arguments
+		{fmt.Sprintf("fn(%s) { %s + %s }(%s)", strings.Join(names, ", "), names[1], names[259], strings.Join(values, ", ")), 260},
+	}
+	runVmTests(t, tests)
+
+	// more than 65535 constants
+	constants := make([]object.Object, 70000)
+	for i := range constants {
+		constants[i] = &object.Integer{Value: int64(i)}
+	}
+	comp := compiler.NewCompilerWithState(compiler.NewSymbolTable(), constants, object.NewBuiltinRegistry())
+	err := comp.Compile(parse("40 + 2"))
+	if err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	vm := NewVm(comp.Bytecode())
+	err = vm.Run()
+	if err != nil {
+		t.Fatalf("vm error: %s", err)
+	}
+	testExpectedObject(t, 42, vm.LastPoppedStackElem())
+
+	// more than 256 builtins
+	builtins := object.NewBuiltinRegistry()
+	for i := builtins.Len(); i < 300; i++ {
+		value := int64(i)
+		_, err := builtins.Register(letterName(i), 0, func(ctx object.CallContext, args ...object.Object) object.Object {
+			return &object.Integer{Value: value}
+		})
+		if err != nil {
+			t.Fatalf("register failed: %s", err)
+		}
+	}
+	comp = compiler.NewCompilerWithBuiltins(builtins)
+	err = comp.Compile(parse(letterName(299) + "()"))
+	if err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	vm = NewVm(comp.Bytecode())
+	err = vm.Run()
+	if err != nil {
+		t.Fatalf("vm error: %s", err)
+	}
+	testExpectedObject(t, 299, vm.LastPoppedStackElem())
+}
+
+// letterName returns a distinct identifier for every i, identifiers can't contain digits
+func letterName(i int) string {
+	name := ""
+	for ; i >= 0; i = i/26 - 1 {
+		name = string(rune('a'+i%26)) + name
+	}
+	return "v" + name
+}
+
+func TestSpecializedInstructions(t *testing.T) {
+	tests := []vmTestCase{
+		{"let f = fn(a, b, c, d, e) { a + b + c + d + e }; f(1, 2, 3, 4, 5)", 15},
+		{"let f = fn(x) { if (x < 2) { x } else { f(x - 1) + f(x - 2) } }; f(15)", 610},
+		{"let f = fn(x) { if (x > 1) { x + 10 } else { x - 10 } }; [f(1), f(2)]", []int{-9, 12}},
+		{"let f = fn(x) { if (x == 1) { 1 } else { 2 } }; [f(1), f(true)]", []int{1, 2}},
+		// the operands aren't integers, the specialised instructions do what the general ones do
+		{`let f = fn(s) { s + "b" }; f("a")`, "ab"},
+		{"let f = fn(b) { if (b == true) { 1 } else { 2 } }; [f(true), f(false)]", []int{1, 2}},
+		{"let f = fn(b) { if (b != true) { 1 } else { 2 } }; [f(true), f(false)]", []int{2, 1}},
+	}
+	runVmTests(t, tests)
+}
+
+func TestStringInterning(t *testing.T) {
+	tests := []vmTestCase{
+		// equal string constants are the same object
+		{`"a" == "a"`, true},
+		{`let f = fn() { "key" }; f() == "key"`, true},
+		{`let h = {"one": 1}; h["one"]`, 1},
+	}
+	runVmTests(t, tests)
+}
+
+func TestHashOrder(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`{"b": 1, "a": 2, 3: 4, true: 5}`, "{b: 1, a: 2, 3: 4, true: 5}"},
+		// a key set again keeps its place
+		{`{"a": 1, "b": 2, "a": 3}`, "{a: 3, b: 2}"},
+	}
+	for _, tt := range tests {
+		comp := compiler.NewCompiler()
+		err := comp.Compile(parse(tt.input))
+		if err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+		vm := NewVm(comp.Bytecode())
+		err = vm.Run()
+		if err != nil {
+			t.Fatalf("vm error: %s", err)
+		}
+		if got := vm.LastPoppedStackElem().Inspect(); got != tt.expected {
+			t.Errorf("wrong hash. want=%s, got=%s", tt.expected, got)
+		}
+
+		registerComp := register.NewCompiler()
+		err = registerComp.Compile(parse(tt.input))
+		if err != nil {
+			t.Fatalf("register compiler error: %s", err)
+		}
+		registerVm := register.NewVm(registerComp.Bytecode())
+		err = registerVm.Run()
+		if err != nil {
+			t.Fatalf("register vm error: %s", err)
+		}
+		if got := registerVm.LastResult().Inspect(); got != tt.expected {
+			t.Errorf("wrong hash on the register vm. want=%s, got=%s", tt.expected, got)
+		}
+	}
+}
+
+func TestCompositeHashKeys(t *testing.T) {
+	tests := []vmTestCase{
+		{`{[1, 2]: 3}[[1, 2]]`, 3},
+		{`{[1, 2]: 3}[[2, 1]]`, Null},
+		{`{[1, 2]: 3}[[1, 2, 3]]`, Null},
+		{`{[]: 1, [[]]: 2}[[[]]]`, 2},
+		{`{[1, ["a", true]]: 4}[[1, ["a", true]]]`, 4},
+		{`let nothing = if (false) { 1 }; {nothing: 5}[nothing]`, 5},
+		{`{[1, 2]: 1, [1, 2]: 2}[[1, 2]]`, 2},
+		// a memoisation table keyed by the arguments
+		{`
+let table = {[1, 1]: 1, [2, 1]: 2, [2, 2]: 4};
+let lookup = fn(x, y) { table[[x, y]] };
+lookup(2, 1) + lookup(2, 2)
+`, 6},
+	}
+	runVmTests(t, tests)
+}
+
+func TestStringsAndSlices(t *testing.T) {
+	tests := []vmTestCase{
+		{`"a" < "b"`, true},
+		{`"b" < "a"`, false},
+		{`"ab" > "a"`, true},
+		{`let a = "x"; let b = "y"; a < b`, true},
+		{`"héllo"[1]`, "é"},
+		{`"héllo"[4]`, "o"},
+		{`"abc"[3]`, Null},
+		{`"abc"[-1]`, Null},
+		{`"héllo"[1:3]`, "él"},
+		{`"abc"[:-1]`, "ab"},
+		{`"abc"[-2:]`, "bc"},
+		{`"abc"[2:1]`, ""},
+		{`"abc"[-10:10]`, "abc"},
+		{"[1, 2, 3, 4][1:3]", []int{2, 3}},
+		{"[1, 2, 3, 4][:2]", []int{1, 2}},
+		{"[1, 2, 3, 4][-1:]", []int{4}},
+		{"[1, 2, 3, 4][:]", []int{1, 2, 3, 4}},
+		{"let a = [1, 2, 3]; let f = fn(n) { a[n:] }; f(1)", []int{2, 3}},
+	}
+	runVmTests(t, tests)
+}
+
+func TestCollectionBuiltins(t *testing.T) {
+	tests := []vmTestCase{
+		{`keys({"a": 1, "b": 2}) == ["a", "b"]`, true},
+		{`values({"a": 1, "b": 2}) == [1, 2]`, true},
+		{`entries({"a": 1, 2: "b"}) == [["a", 1], [2, "b"]]`, true},
+		{`has({"a": 1}, "a")`, true},
+		{`has({"a": 1}, "b")`, false},
+		{`has({[1, 2]: 1}, [1, 2])`, true},
+		{`delete({"a": 1, "b": 2}, "a") == {"b": 2}`, true},
+		{`let h = {"a": 1}; delete(h, "a"); h == {"a": 1}`, true},
+		{`merge({"a": 1, "b": 2}, {"b": 3}, {"c": 4}) == {"a": 1, "b": 3, "c": 4}`, true},
+		{`keys(merge({"b": 1}, {"a": 2, "b": 3})) == ["b", "a"]`, true},
+		{`slice([1, 2, 3, 4], 1, 3)`, []int{2, 3}},
+		{`slice([1, 2, 3, 4], -2)`, []int{3, 4}},
+		{`slice("hello", 1, 3)`, "el"},
+		{`concat([1], [], [2, 3])`, []int{1, 2, 3}},
+		{`reverse([1, 2, 3])`, []int{3, 2, 1}},
+		{`index_of([1, [2], 3], [2])`, 1},
+		{`index_of([1, 2, 3], 4)`, -1},
+		{`contains(["a", "b"], "b")`, true},
+		{`contains(["a", "b"], "c")`, false},
+		{`join([1, "a", true], ", ")`, "1, a, true"},
+		{`join([], "-")`, ""},
+		{`range(4)`, []int{0, 1, 2, 3}},
+		{`range(2, 5)`, []int{2, 3, 4}},
+		{`range(5, 0, -2)`, []int{5, 3, 1}},
+		{`range(3, 1)`, []int{}},
+		{`zip([1, 2, 3], ["a", "b"]) == [[1, "a"], [2, "b"]]`, true},
+		{`flatten([1, [2, 3], [], [[4]]]) == [1, 2, 3, [4]]`, true},
+		{`unique([1, 2, 1, [3], [3], "a", "a"]) == [1, 2, [3], "a"]`, true},
+		{`unique([1, 1.0])`, []int64{1}},
+		{`unique([1.0, 1]) == [1.0]`, true},
+		{`unique([[1.0], [1], [1.5], [1.5]]) == [[1.0], [1.5]]`, true},
+		{`keys(1)`, &object.Error{Message: "argument to `keys` must be HASH, got INTEGER"}},
+		{`has({}, fn() {})`, &object.Error{Message: "unusable as hash key: CLOSUREOBJ"}},
+		{`merge({}, [])`, &object.Error{Message: "argument to `merge` must be HASH, got ARRAY"}},
+		{`concat()`, &object.Error{Message: "wrong number of arguments. got=0, want at least 1"}},
+		{`reverse("abc")`, &object.Error{Message: "argument to `reverse` must be ARRAY, got STRING"}},
+		{`join([1], 2)`, &object.Error{Message: "argument to `join` must be STRING, got INTEGER"}},
+		{`slice([1])`, &object.Error{Message: "wrong number of arguments. got=1, want=2 to 3"}},
+		{`range(1, 2, 0)`, &object.Error{Message: "step of `range` must not be 0"}},
+		{`range("a")`, &object.Error{Message: "argument to `range` must be INTEGER, got STRING"}},
+		{`range(4000000000)`, &object.Error{Message: "range of 4000000000 integers is too long"}},
+		{`range(0, 33554434, 2)`, &object.Error{Message: "range of 16777217 integers is too long"}},
+	}
+	runVmTests(t, tests)
+}
+
+func TestHigherOrderBuiltins(t *testing.T) {
+	tests := []vmTestCase{
+		{`map([1, 2, 3], fn(x) { x * 2 })`, []int{2, 4, 6}},
+		{`let k = 10; map([1, 2], fn(x) { x + k })`, []int{11, 12}},
+		{`map([], fn(x) { x })`, []int{}},
+		{`map(["a", "bc"], len)`, []int{1, 2}},
+		{`filter([1, 2, 3, 4], fn(x) { x > 2 })`, []int{3, 4}},
+		{`filter([0, 1, 2], fn(x) { x })`, []int{0, 1, 2}},
+		{`filter([1, 2, 3], fn(x) { if (x > 1) { x } })`, []int{2, 3}},
+		{`reduce([1, 2, 3, 4], 0, fn(acc, x) { acc + x })`, 10},
+		{`reduce([], 5, fn(acc, x) { acc + x })`, 5},
+		{`reduce(range(20000), 0, fn(acc, x) { acc + x })`, 199990000},
+		{`each([1, 2], fn(x) { x })`, Null},
+		{`sort([3, 1, 2])`, []int{1, 2, 3}},
+		{`sort(["b", "c", "a"]) == ["a", "b", "c"]`, true},
+		{`sort([3, 1, 2], fn(a, b) { a > b })`, []int{3, 2, 1}},
+		// stable: equal elements keep their order
+		{`sort([[1, "a"], [0, "b"], [1, "c"], [0, "d"]], fn(a, b) { a[0] < b[0] }) == [[0, "b"], [0, "d"], [1, "a"], [1, "c"]]`, true},
+		{`sort_by(["ccc", "a", "bb", "d"], len) == ["a", "d", "bb", "ccc"]`, true},
+		{`sort_by([{"n": 2}, {"n": 1}], fn(h) { h["n"] }) == [{"n": 1}, {"n": 2}]`, true},
+		{`map(1, fn(x) { x })`, &object.Error{Message: "argument to `map` must be ARRAY, got INTEGER"}},
+		{`map([1], 1)`, &object.Error{Message: "argument to `map` must be a function, got INTEGER"}},
+		{`map([1], len)`, &object.Error{Message: "argument to `len` not supported, got INTEGER"}},
+		{`sort([1, "a"])`, &object.Error{Message: "`sort` can't compare STRING and INTEGER"}},
+		{`sort([1, 2], fn(a, b) { 1 })`, &object.Error{Message: "function of `sort` must return BOOLEAN, got INTEGER"}},
+	}
+	runVmTests(t, tests)
+}
+
+func TestStringBuiltins(t *testing.T) {
+	tests := []vmTestCase{
+		{`len("héllo")`, 5},
+		{`split("a,b,,c", ",") == ["a", "b", "", "c"]`, true},
+		{`split("hé", "") == ["h", "é"]`, true},
+		{`join(split("a b c", " "), "-")`, "a-b-c"},
+		{`trim("  a b  ")`, "a b"},
+		{`upper("héllo")`, "HÉLLO"},
+		{`lower("ÄB")`, "äb"},
+		{`replace("a-b-c", "-", "+")`, "a+b+c"},
+		{`contains("héllo", "ll")`, true},
+		{`contains("hello", "x")`, false},
+		{`starts_with("hello", "he")`, true},
+		{`ends_with("hello", "he")`, false},
+		{`index_of("héllo", "l")`, 2},
+		{`index_of("hello", "x")`, -1},
+		{`repeat("ab", 3)`, "ababab"},
+		{`repeat("ab", 0)`, ""},
+		{`chars("hé") == ["h", "é"]`, true},
+		{`ord("é")`, 233},
+		{`chr(233)`, "é"},
+		{`chr(ord("a") + 1)`, "b"},
+		{`pad_left("7", 3, "0")`, "007"},
+		{`pad_right("ab", 4)`, "ab  "},
+		{`pad_left("abc", 2)`, "abc"},
+		{`format("%s is %d, 100%%", "x", 5)`, "x is 5, 100%"},
+		{`format("%v and %s", [1, "a"], {"k": true})`, "[1, a] and {k: true}"},
+		{`upper(1)`, &object.Error{Message: "argument to `upper` must be STRING, got INTEGER"}},
+		{`contains("a", 1)`, &object.Error{Message: "argument to `contains` must be STRING, got INTEGER"}},
+		{`repeat("a", -1)`, &object.Error{Message: "count of `repeat` must not be negative, got -1"}},
+		{`ord("ab")`, &object.Error{Message: "argument to `ord` must be a single character, got \"ab\""}},
+		{`chr(-1)`, &object.Error{Message: "argument to `chr` is not a code point: -1"}},
+		{`format("%d", "a")`, &object.Error{Message: "%d of format must be INTEGER, got STRING"}},
+		{`format("%s %s", 1)`, &object.Error{Message: "format has more verbs than arguments"}},
+		{`format("%s", 1, 2)`, &object.Error{Message: "format has 2 arguments but 1 verbs"}},
+		{`format("%x", 1)`, &object.Error{Message: "unknown verb %x in format"}},
+		{`format("%é", 1)`, &object.Error{Message: "unknown verb %é in format"}},
+		{`format("é%s", "ü")`, "éü"},
+	}
+	runVmTests(t, tests)
+}
+
+func TestRandomNumbersPerRun(t *testing.T) {
+	run := func(input string) object.Object {
+		comp := compiler.NewCompiler()
+		if err := comp.Compile(parse(input)); err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+		vm := NewVm(comp.Bytecode())
+		if err := vm.Run(); err != nil {
+			t.Fatalf("vm error: %s", err)
+		}
+		return vm.LastPoppedStackElem()
+	}
+	first := run("random_int(1, 1000000)")
+	seeded := run("random_seed(42); random_int(1, 1000000)")
+	// neither the numbers the runs before used nor their seeds change what an unseeded run gets
+	if again := run("random_int(1, 1000000)"); !object.Equal(first, again) {
+		t.Errorf("unseeded runs got different numbers: %s and %s", first.Inspect(), again.Inspect())
+	}
+	if again := run("random_seed(42); random_int(1, 1000000)"); !object.Equal(seeded, again) {
+		t.Errorf("runs with the same seed got different numbers: %s and %s", seeded.Inspect(), again.Inspect())
+	}
+	if object.Equal(first, seeded) {
+		t.Errorf("random_seed didn't change the numbers: %s", seeded.Inspect())
+	}
+}
+
+func TestMathBuiltins(t *testing.T) {
+	tests := []vmTestCase{
+		{`abs(-3)`, 3},
+		{`abs(pow(-2, -1)) == pow(2, -1)`, true},
+		{`min(3, 1, 2)`, 1},
+		{`max(3, 1, 2)`, 3},
+		{`max("a", "b")`, "b"},
+		{`min(1, PI) == 1`, true},
+		{`pow(2, 10)`, 1024},
+		{`pow(-3, 3)`, -27},
+		{`pow(4, pow(2, -1)) == 2`, true},
+		{`sqrt(16) == 4`, true},
+		{`floor(PI)`, 3},
+		{`ceil(PI)`, 4},
+		{`round(E)`, 3},
+		{`round(pow(2, -1))`, 1},
+		{`floor(-7)`, -7},
+		{`clamp(5, 1, 3)`, 3},
+		{`clamp(-5, 1, 3)`, 1},
+		{`clamp(2, 1, 3)`, 2},
+		{`gcd(12, 18)`, 6},
+		{`gcd(-4, 6)`, 2},
+		{`gcd(0, 0)`, 0},
+		{`sin(0) == 0`, true},
+		{`cos(0) == 1`, true},
+		{`floor(acos(-1)) == floor(PI)`, true},
+		{`round(tan(1))`, 2},
+		{`floor(atan(1, 0))`, 1},
+		{`asin(1) == atan(1, 0)`, true},
+		{`atan(0) == 0`, true},
+		{`random_seed(7); let a = random(); random_seed(7); a == random()`, true},
+		{`random_seed(7); let a = random_int(1, 100); random_seed(7); a == random_int(1, 100)`, true},
+		{`random_int(5, 5)`, 5},
+		{`let r = random_int(-2, 2); contains([-2, -1, 0, 1, 2], r)`, true},
+		{`let r = random(); floor(r)`, 0},
+		{`let PI = 3; PI`, 3},
+		{`pow(2, 63)`, &object.Error{Message: "result of `pow` is out of the INTEGER range"}},
+		{`abs(-9223372036854775807 - 1)`, &object.Error{Message: "result of `abs` is out of the INTEGER range"}},
+		{`gcd(-9223372036854775807 - 1, 0)`, &object.Error{Message: "result of `gcd` is out of the INTEGER range"}},
+		{`gcd(-9223372036854775807 - 1, 6)`, 2},
+		{`sqrt(-1)`, &object.Error{Message: "argument to `sqrt` must not be negative, got -1"}},
+		{`abs("a")`, &object.Error{Message: "argument to `abs` must be INTEGER or FLOAT, got STRING"}},
+		{`min(1, "a")`, &object.Error{Message: "`min` can't compare STRING and INTEGER"}},
+		{`clamp(1, 3, 2)`, &object.Error{Message: "bounds of `clamp` are reversed: 3 > 2"}},
+		{`random_int(2, 1)`, &object.Error{Message: "bounds of `random_int` are reversed: 2 > 1"}},
+		{`floor(pow(pow(2, -1), -100))`, &object.Error{Message: "result of `floor` is out of the INTEGER range: 1.2676506002282294e+30"}},
+	}
+	runVmTests(t, tests)
+}
+
+func TestJSONBuiltins(t *testing.T) {
+	tests := []vmTestCase{
+		{`json_stringify({"b": [1, true, json_parse("null")], "a": "x"})`, `{"a":"x","b":[1,true,null]}`},
+		{`json_stringify({"b": 1, "a": 2}) == json_stringify({"a": 2, "b": 1})`, true},
+		{`json_stringify({"a": [1, 2], "b": {}, "c": []}, 2)`, "{\n  \"a\": [\n    1,\n    2\n  ],\n  \"b\": {},\n  \"c\": []\n}"},
+		{`json_stringify(["a", "b"], chr(9))`, "[\n\t\"a\",\n\t\"b\"\n]"},
+		{`json_stringify(PI)`, "3.141592653589793"},
+		{`json_stringify(chr(34) + chr(10) + "é")`, `"\"\né"`},
+		{`json_parse(json_stringify({"b": 1, "a": [true, "x"]})) == {"b": 1, "a": [true, "x"]}`, true},
+		{`join(keys(json_parse(json_stringify({"b": 1, "a": 2, "c": 3}))), ",")`, "a,b,c"},
+		{`json_parse("[1, 2.5, -3]")[0]`, 1},
+		{`floor(json_parse("[1, 2.5, -3]")[1])`, 2},
+		{`json_parse("  true ")`, true},
+		{`json_parse("null")`, Null},
+		{`let s = json_stringify({"k": [1, {"n": json_parse("null")}]}, 4); json_stringify(json_parse(s), 4) == s`, true},
+		{`json_parse("[1,")`, &object.Error{Message: "invalid JSON: unexpected end of JSON input"}},
+		{`json_parse("")`, &object.Error{Message: "invalid JSON: unexpected end of JSON input"}},
+		{`json_parse("[1] 2")`, &object.Error{Message: "invalid JSON: unexpected data after the value"}},
+		{`json_parse("nope")`, &object.Error{Message: "invalid JSON: invalid character 'o' in literal null (expecting 'u')"}},
+		{`json_parse(1)`, &object.Error{Message: "argument to `json_parse` must be STRING, got INTEGER"}},
+		{`json_stringify(fn(x) { x })`, &object.Error{Message: "can't convert CLOSUREOBJ to JSON"}},
+		{`json_stringify([len])`, &object.Error{Message: "can't convert BUILTIN to JSON"}},
+		{`json_stringify({1: 2})`, &object.Error{Message: "can't convert to JSON: hash key must be STRING, got INTEGER"}},
+		{`json_stringify(1, -1)`, &object.Error{Message: "indent of `json_stringify` must be between 0 and 16, got -1"}},
+	}
+	runVmTests(t, tests)
+}
+
+func TestTypeBuiltins(t *testing.T) {
+	tests := []vmTestCase{
+		{`type(1)`, "INTEGER"},
+		{`type(PI)`, "FLOAT"},
+		{`type("a")`, "STRING"},
+		{`type(true)`, "BOOLEAN"},
+		{`type(json_parse("null"))`, "NULL"},
+		{`type([])`, "ARRAY"},
+		{`type({})`, "HASH"},
+		{`type(fn() { 1 })`, "FUNCTION"},
+		{`let a = 1; type(fn() { a })`, "FUNCTION"},
+		{`type(len)`, "BUILTIN"},
+		{`str(12)`, "12"},
+		{`str("a")`, "a"},
+		{`str([1, "a"])`, "[1, a]"},
+		{`str(true) + str(json_parse("null"))`, "truenull"},
+		{`int("42")`, 42},
+		{`int(" -7 ")`, -7},
+		{`int(7)`, 7},
+		{`int(pow(2, -1))`, 0},
+		{`int(pow(-2, -1))`, 0},
+		{`int(E)`, 2},
+		{`bool(0)`, true},
+		{`bool("")`, true},
+		{`bool(false)`, false},
+		{`bool(json_parse("null"))`, false},
+		{`bool([])`, true},
+		{`arity(fn(a, b) { a })`, 2},
+		{`let c = 1; arity(fn(a) { a + c })`, 1},
+		{`arity(fn() { 1 })`, 0},
+		{`arity(len)`, 1},
+		{`arity(format)`, -1},
+		{`let f = fn(x) { if (type(x) == "INTEGER") { x } else { int(x) } }; f("3") + f(4)`, 7},
+		{`int("4a")`, &object.Error{Message: "can't convert \"4a\" to INTEGER"}},
+		{`int(true)`, &object.Error{Message: "argument to `int` must be INTEGER, FLOAT or STRING, got BOOLEAN"}},
+		{`arity(1)`, &object.Error{Message: "argument to `arity` must be a function, got INTEGER"}},
+	}
+	runVmTests(t, tests)
+}